@@ -0,0 +1,332 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package arp
+
+import (
+	"github.com/platinasystems/elib/cpu"
+	"github.com/platinasystems/vnet"
+	"github.com/platinasystems/vnet/ethernet"
+	"github.com/platinasystems/vnet/ip"
+	"github.com/platinasystems/vnet/ip4"
+)
+
+type nodeMain struct {
+	inputNode inputNode
+	gleanNode gleanNode
+}
+
+func (m *nodeMain) nodeInit(v *vnet.Vnet, em *ethernet.Main, im *ip4.Main) {
+	n := &m.inputNode
+	n.em, n.im = em, im
+	n.Next = []string{
+		input_next_drop: "error",
+	}
+	n.Errors = []string{
+		input_error_too_short:          "packet too short",
+		input_error_not_ethernet_ip4:   "not ethernet/ip4",
+		input_error_unknown_opcode:     "unknown opcode",
+		input_error_not_local:          "request for non-local address",
+		input_error_replies_sent:       "replies sent",
+		input_error_neighbors_learned:  "neighbors learned",
+		input_error_neighbor_add_error: "neighbor add error",
+	}
+	v.RegisterInOutNode(n, "arp-input")
+	n.rewrites.Init(v)
+	em.RegisterType(ethernet.ARP, "arp-input")
+	em.RegisterIpNeighborProber(ip.Ip4, n)
+
+	g := &m.gleanNode
+	g.em, g.im = em, im
+	g.Next = []string{
+		glean_next_drop: "error",
+	}
+	g.Errors = []string{
+		glean_error_not_glean:     "adjacency is not glean",
+		glean_error_no_address:    "no interface address",
+		glean_error_throttled:     "request throttled",
+		glean_error_requests_sent: "requests sent",
+	}
+	v.RegisterInOutNode(g, "ip4-arp")
+	im.RegisterGleanNode(g, "ip4-arp")
+	im.RegisterNeighborSolicitor(em.ResolveIpNeighbor)
+}
+
+// Sender address of arp packets sent on given interface.
+func (n *inputNode) interfaceAddress(si vnet.Si) (a ethernet.Address, ok bool) {
+	v := n.Vnet
+	var h ethernet.HwInterfacer
+	if h, ok = v.HwIfer(v.SupHi(si)).(ethernet.HwInterfacer); ok {
+		a = h.GetInterface().Address
+	}
+	return
+}
+
+const (
+	input_next_drop = iota
+)
+
+const (
+	input_error_none = iota
+	input_error_too_short
+	input_error_not_ethernet_ip4
+	input_error_unknown_opcode
+	input_error_not_local
+	input_error_replies_sent
+	input_error_neighbors_learned
+	input_error_neighbor_add_error
+)
+
+type inputNode struct {
+	vnet.InOutNode
+	em *ethernet.Main
+	im *ip4.Main
+	// Rewrites for replies sent by interface.
+	rewrites ethernet.RewriteCache
+}
+
+// Returns interface address on given interface whose subnet contains address a.
+func (n *inputNode) subnetAddress(si vnet.Si, a *ip4.Address) (ia *ip.IfAddress) {
+	n.im.ForeachIfAddress(si, func(_ ip.IfAddr, i *ip.IfAddress) (err error) {
+		if p := ip4.FromIp4Prefix(&i.Prefix); a.MatchesPrefix(&p) {
+			ia = i
+		}
+		return
+	})
+	return
+}
+
+// Add or update neighbor for sender of arp packet.
+func (n *inputNode) learn(r *vnet.Ref, h *HeaderEthernetIp4) {
+	s := &h.Addrs[0]
+	if s.Ip4.IsZero() || n.subnetAddress(r.Si, &s.Ip4) == nil {
+		return
+	}
+	nb := ethernet.IpNeighbor{
+		Ethernet: s.Ethernet,
+		Ip:       s.Ip4.ToIp(),
+		Si:       r.Si,
+	}
+	if err := n.em.AddDelIpNeighbor(&n.im.Main, &nb, false); err != nil {
+		n.CountError(input_error_neighbor_add_error, 1)
+	} else {
+		n.CountError(input_error_neighbors_learned, 1)
+	}
+}
+
+func (n *inputNode) inputNext(r *vnet.Ref) (next uint) {
+	next = input_next_drop
+	if r.DataLen() < HeaderEthernetIp4Bytes {
+		n.SetError(r, input_error_too_short)
+		return
+	}
+	h := GetHeader(r)
+	if h.GetL2Type() != L2TypeEthernet || h.GetL3Type() != ethernet.IP4 ||
+		h.NL2AddressBytes != ethernet.AddressBytes || h.NL3AddressBytes != ip4.AddressBytes {
+		n.SetError(r, input_error_not_ethernet_ip4)
+		return
+	}
+
+	op := h.GetOpcode()
+	if op != Request && op != Reply {
+		n.SetError(r, input_error_unknown_opcode)
+		return
+	}
+
+	// Replies and gratuitous arps (sender == target) are learned whether or not they are for us.
+	s, t := &h.Addrs[0], &h.Addrs[1]
+	isGratuitous := s.Ip4.IsEqual(&t.Ip4)
+	if op == Reply || isGratuitous {
+		n.learn(r, h)
+	}
+	if op != Request || isGratuitous {
+		return
+	}
+
+	// Only answer requests for our interface addresses on receiving interface.
+	var p ip.Prefix
+	p.Address = t.Ip4.ToIp()
+	ai, ok := n.im.IfAddrForPrefix(r.Si, &p)
+	if !ok || n.im.GetIfAddr(ai).Si != r.Si {
+		n.SetError(r, input_error_not_local)
+		return
+	}
+	ea, ok := n.interfaceAddress(r.Si)
+	if !ok {
+		n.SetError(r, input_error_not_ethernet_ip4)
+		return
+	}
+
+	// Requester must be a neighbor for us to reply; learn it now to avoid an arp for our reply.
+	n.learn(r, h)
+
+	// Turn request into reply in place.
+	h.Opcode = Reply.FromHost()
+	*t = *s
+	s.Ethernet = ea
+	s.Ip4 = ip4.Address{}
+	copy(s.Ip4[:], p.Address[:ip4.AddressBytes])
+	r.SetDataLen(HeaderEthernetIp4Bytes)
+
+	next = n.rewrites.PerformRewrite(n.Vnet, n, r, r.Si, vnet.ARP, &t.Ethernet)
+	n.CountError(input_error_replies_sent, 1)
+	return
+}
+
+// Fill in arp header for request with given addresses.
+func (h *HeaderEthernetIp4) setRequest(srcEthernet *ethernet.Address, src, dst *ip4.Address) {
+	*h = HeaderEthernetIp4{
+		Header: Header{
+			L2Type:          L2TypeEthernet.FromHost(),
+			L3Type:          vnet.Uint16(ethernet.IP4.FromHost()),
+			NL2AddressBytes: ethernet.AddressBytes,
+			NL3AddressBytes: ip4.AddressBytes,
+			Opcode:          Request.FromHost(),
+		},
+	}
+	h.Addrs[0].Ethernet = *srcEthernet
+	h.Addrs[0].Ip4 = *src
+	h.Addrs[1].Ip4 = *dst
+}
+
+// See ethernet.IpNeighborProber interface.
+// Probes are arp requests sent to neighbor's ethernet address.
+func (n *inputNode) ProbeIpNeighbor(r *vnet.Ref, nb *ethernet.IpNeighbor, isSolicit bool) (t vnet.PacketType, da ethernet.Address, ok bool) {
+	var dst, src ip4.Address
+	copy(dst[:], nb.Ip[:ip4.AddressBytes])
+	ia := n.subnetAddress(nb.Si, &dst)
+	if ia == nil {
+		return
+	}
+	copy(src[:], ia.Prefix.Address[:ip4.AddressBytes])
+	ea, ok := n.interfaceAddress(nb.Si)
+	if !ok {
+		return
+	}
+	r.SetDataLen(HeaderEthernetIp4Bytes)
+	GetHeader(r).setRequest(&ea, &src, &dst)
+	t, da = vnet.ARP, nb.Ethernet
+	if isSolicit {
+		da = ethernet.BroadcastAddr
+	}
+	return
+}
+
+func (n *inputNode) NodeInput(in *vnet.RefIn, o *vnet.RefOut) {
+	for i := uint(0); i < in.Len(); i++ {
+		r := &in.Refs[i]
+		x := n.inputNext(r)
+		o.Outs[x].BufferPool = in.BufferPool
+		no := o.Outs[x].AddLen(n.Vnet)
+		o.Outs[x].Refs[no] = *r
+	}
+}
+
+const (
+	glean_next_drop = iota
+)
+
+const (
+	glean_error_none = iota
+	glean_error_not_glean
+	glean_error_no_address
+	glean_error_throttled
+	glean_error_requests_sent
+)
+
+// Minimum time in seconds between requests for a given address.
+const gleanThrottleInterval = 1
+
+// Node receiving ip4 packets for glean adjacencies.
+// Sends arp requests for unresolved destinations, re-using packet buffer.
+type gleanNode struct {
+	vnet.InOutNode
+	em *ethernet.Main
+	im *ip4.Main
+
+	// Addresses for which requests have been sent since throttle time.
+	throttleTime cpu.Time
+	throttle     map[ip4.Address]struct{}
+}
+
+func (n *gleanNode) isThrottled(a *ip4.Address) (ok bool) {
+	now := cpu.TimeNow()
+	if n.throttle == nil || n.Vnet.TimeDiff(now, n.throttleTime) > gleanThrottleInterval {
+		n.throttle = make(map[ip4.Address]struct{})
+		n.throttleTime = now
+	}
+	if _, ok = n.throttle[*a]; !ok {
+		n.throttle[*a] = struct{}{}
+	}
+	return
+}
+
+// Source address for requests: interface address of adjacency or else first address of interface.
+func (n *gleanNode) sourceAddress(a *ip.Adjacency) (src ip4.Address, ok bool) {
+	im := &n.im.Main
+	if a.IfAddr != ip.IfAddrNil {
+		copy(src[:], im.GetIfAddr(a.IfAddr).Prefix.Address[:ip4.AddressBytes])
+		ok = true
+		return
+	}
+	im.ForeachIfAddress(a.Si, func(_ ip.IfAddr, ia *ip.IfAddress) (err error) {
+		if !ok {
+			copy(src[:], ia.Prefix.Address[:ip4.AddressBytes])
+			ok = true
+		}
+		return
+	})
+	return
+}
+
+func (n *gleanNode) gleanNext(r *vnet.Ref, p *vnet.BufferPool) (next uint) {
+	next = glean_next_drop
+	im := n.im
+	ih := ip4.GetHeader(r)
+	dst := ih.Dst
+
+	a := im.GetAdjacency(ip.GetRefAdj(r))
+	if a.LookupNextIndex != ip.LookupNextGlean {
+		n.SetError(r, glean_error_not_glean)
+		return
+	}
+	if n.isThrottled(&dst) {
+		n.SetError(r, glean_error_throttled)
+		return
+	}
+
+	rw := &a.Rewrite
+	h, ok := n.Vnet.HwIfer(n.Vnet.SupHi(rw.Si)).(ethernet.HwInterfacer)
+	if !ok {
+		n.SetError(r, glean_error_not_glean)
+		return
+	}
+	src, ok := n.sourceAddress(a)
+	if !ok {
+		n.SetError(r, glean_error_no_address)
+		return
+	}
+	dstAddr := dst.ToIp()
+	n.em.SolicitIpNeighbor(&im.Main, &dstAddr, rw.Si)
+
+	// Packet which triggered request is replaced by request.
+	p.Unchain(r)
+	r.SetDataLen(HeaderEthernetIp4Bytes)
+	GetHeader(r).setRequest(&h.GetInterface().Address, &src, &dst)
+
+	vnet.PerformRewrite(r, rw)
+	r.Si = rw.Si
+	n.CountError(glean_error_requests_sent, 1)
+	return uint(rw.NextIndex)
+}
+
+func (n *gleanNode) NodeInput(in *vnet.RefIn, o *vnet.RefOut) {
+	for i := uint(0); i < in.Len(); i++ {
+		r := &in.Refs[i]
+		x := n.gleanNext(r, in.BufferPool)
+		o.Outs[x].BufferPool = in.BufferPool
+		no := o.Outs[x].AddLen(n.Vnet)
+		o.Outs[x].Refs[no] = *r
+	}
+}
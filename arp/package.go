@@ -0,0 +1,32 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package arp
+
+import (
+	"github.com/platinasystems/vnet"
+	"github.com/platinasystems/vnet/ethernet"
+	"github.com/platinasystems/vnet/ip4"
+)
+
+var packageIndex uint
+
+type Main struct {
+	vnet.Package
+	nodeMain
+}
+
+func Init(v *vnet.Vnet) {
+	m := &Main{}
+	packageIndex = v.AddPackage("arp", m)
+	m.DependsOn("ethernet", "ip4")
+}
+
+func GetMain(v *vnet.Vnet) *Main { return v.GetPackage(packageIndex).(*Main) }
+
+func (m *Main) Init() (err error) {
+	v := m.Vnet
+	m.nodeInit(v, ethernet.GetMain(v), ip4.GetMain(v))
+	return
+}
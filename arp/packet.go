@@ -70,7 +70,7 @@ type HeaderEthernetIp4 struct {
 	Addrs [2]EthernetIp4Addr
 }
 
-const HeaderEthernetIp4Bytes = 8 + 6 + 4
+const HeaderEthernetIp4Bytes = 8 + 2*(6+4)
 
 func (h *HeaderEthernetIp4) String() (s string) {
 	s = fmt.Sprintf("%s, l2/l3 type/size %s/%d %s/%d, %s/%s -> %s/%s",
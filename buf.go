@@ -20,6 +20,12 @@ type refOpaque struct {
 	err ErrorRef
 
 	Si Si
+
+	// Passed from node to next node; e.g. adjacency found by ip input node for rewrite.
+	Aux uint32
+
+	// Offloads requested of interface output; zero when packet is complete.
+	TxOffload TxOffload
 }
 
 type Ref struct {
@@ -155,6 +161,21 @@ func (p *BufferPool) FreeRefs(r *Ref, n uint, freeNext bool) {
 	(*hw.BufferPool)(p).FreeRefs((*hw.RefHeader)(&r.RefHeader), n, freeNext)
 }
 
+// Unchain replaces chained packet with a copy of its first buffer and frees chain.
+// For nodes which turn packets into short packets of their own (e.g. icmp errors).
+func (p *BufferPool) Unchain(r *Ref) {
+	if r.NextValidFlag() == 0 {
+		return
+	}
+	var x Ref
+	p.AllocRefsStride(&x, 1, 1)
+	x.refOpaque = r.refOpaque
+	x.SetDataLen(r.DataLen())
+	copy(x.DataSlice(), r.DataSlice())
+	p.FreeRefs(r, 1, true)
+	*r = x
+}
+
 func (i *RefIn) AllocRefs(n uint)       { i.AllocPoolRefs(i.BufferPool, n) }
 func (i *RefIn) FreeRefs(n uint)        { i.FreePoolRefs(i.BufferPool, n) }
 func (i *RefIn) SetLen(v *Vnet, l uint) { i.In.SetLen(&v.loop, l) }
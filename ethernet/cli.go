@@ -0,0 +1,47 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ethernet
+
+import (
+	"github.com/platinasystems/elib/cli"
+	"github.com/platinasystems/vnet"
+
+	"fmt"
+)
+
+func (m *Main) createSubInterface(c cli.Commander, w cli.Writer, in *cli.Input) (err error) {
+	var (
+		hi           vnet.Hi
+		outer, inner uint
+	)
+	if !in.Parse("%v %d", &hi, m.Vnet, &inner) {
+		err = fmt.Errorf("looking for INTERFACE VLAN-ID, got `%s'", in)
+		return
+	}
+	in.Parse("outer %d", &outer)
+	if !in.End() {
+		err = cli.ParseError
+		return
+	}
+	var si vnet.Si
+	if si, err = m.CreateSubInterface(hi, outer, inner); err != nil {
+		return
+	}
+	fmt.Fprintf(w, "%s\n", si.Name(m.Vnet))
+	return
+}
+
+func (m *Main) cliInit(v *vnet.Vnet) {
+	cmds := [...]cli.Command{
+		cli.Command{
+			Name:      "create sub-interface",
+			ShortHelp: "create vlan sub interface",
+			Action:    m.createSubInterface,
+		},
+	}
+	for i := range cmds {
+		v.CliAdd(&cmds[i])
+	}
+}
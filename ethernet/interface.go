@@ -126,6 +126,8 @@ type InterfaceConfig struct {
 	Address      Address
 	PhyInterface PhyInterface
 	NativeVlan   Vlan
+	// Type of outer tag of double tagged packets sent; zero means 802.1ad (VLAN_8021AD).
+	QinqType Type
 }
 
 type Interface struct {
@@ -152,6 +154,12 @@ type HwInterfacer interface {
 // Dummy function to mark ethernet interfaces as supporting ARP.
 func (i *Interface) SupportsArp() {}
 
+// See vnet.L2Headerer interface.
+func (hi *Interface) L2HeaderBytes(v *vnet.Vnet, si vnet.Si) (n uint) {
+	n = HeaderBytes + nVlanTags(v, si)*VlanHeaderBytes
+	return
+}
+
 func RegisterInterface(v *vnet.Vnet, hi HwInterfacer, config *InterfaceConfig, format string, args ...interface{}) {
 	i := hi.GetInterface()
 	i.InterfaceConfig = *config
@@ -176,17 +184,25 @@ type rwHeader struct {
 
 func (hi *Interface) SetRewrite(v *vnet.Vnet, rw *vnet.Rewrite, packetType vnet.PacketType, da []byte) {
 	var h rwHeader
-	sw := v.SwIf(rw.Si)
-	sup := v.SupSwIf(sw)
 	t := rewriteTypeMap[packetType].FromHost()
 	size := uintptr(HeaderBytes)
-	if sw != sup {
+	switch nVlanTags(v, rw.Si) {
+	case 0:
+		h.Type = t
+	case 1:
+		_, inner := subInterfaceTags(v.SwIf(rw.Si).Id(v))
 		h.Type = VLAN.FromHost()
-		h.vlan[0].Priority_cfi_and_id = vnet.Uint16(sw.Id(v)).FromHost()
+		h.vlan[0].Priority_cfi_and_id = vnet.Uint16(inner).FromHost()
 		h.vlan[0].Type = t
 		size += VlanHeaderBytes
-	} else {
-		h.Type = t
+	case 2:
+		outer, inner := subInterfaceTags(v.SwIf(rw.Si).Id(v))
+		h.Type = hi.qinqType().FromHost()
+		h.vlan[0].Priority_cfi_and_id = vnet.Uint16(outer).FromHost()
+		h.vlan[0].Type = VLAN.FromHost()
+		h.vlan[1].Priority_cfi_and_id = vnet.Uint16(inner).FromHost()
+		h.vlan[1].Type = t
+		size += 2 * VlanHeaderBytes
 	}
 	if len(da) > 0 {
 		copy(h.Dst[:], da)
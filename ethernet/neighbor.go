@@ -5,14 +5,53 @@
 package ethernet
 
 import (
+	"github.com/platinasystems/elib"
 	"github.com/platinasystems/elib/cpu"
 	"github.com/platinasystems/vnet"
 	"github.com/platinasystems/vnet/ip"
 
 	"errors"
+	"fmt"
+)
+
+// Neighbor reachability state (as in RFC 4861 section 7.3.2).
+type NeighborState uint8
+
+const (
+	// Resolution in progress; no adjacency is installed.
+	NeighborIncomplete NeighborState = iota
+	// Reachability confirmed within reachable time.
+	NeighborReachable
+	// Reachable time elapsed; entry is used as is until it is used for forwarding.
+	NeighborStale
+	// Entry used while stale; unicast probes are being sent.
+	NeighborProbe
+	// Resolution or probes failed; adjacency has been removed.
+	NeighborFailed
+)
+
+var neighborStateStrings = [...]string{
+	NeighborIncomplete: "incomplete",
+	NeighborReachable:  "reachable",
+	NeighborStale:      "stale",
+	NeighborProbe:      "probe",
+	NeighborFailed:     "failed",
+}
+
+func (x NeighborState) String() string { return elib.StringerHex(neighborStateStrings[:], int(x)) }
+
+// Neighbor aging constants in seconds.
+const (
+	neighborReachableTime = 30
+	neighborRetransTime   = 1
+	neighborMaxProbes     = 3
+	// Stale entries not used for forwarding in this time are removed.
+	neighborStaleTime = 60
 )
 
 type ipNeighborFamily struct {
+	// Ip main of family; set when first neighbor is added.
+	im             *ip.Main
 	pool           ipNeighborPool
 	indexByAddress map[ipNeighborKey]uint
 }
@@ -21,9 +60,16 @@ type ipNeighborMain struct {
 	v *vnet.Vnet
 	// Ip4/Ip6 neighbors.
 	ipNeighborFamilies [ip.NFamily]ipNeighborFamily
+	timer              ipNeighborTimer
+	probeNode          ipNeighborProbeNode
+	addDelHooks        IpNeighborAddDelHookVec
 }
 
-func (m *ipNeighborMain) init(v *vnet.Vnet) { m.v = v }
+func (m *ipNeighborMain) init(v *vnet.Vnet) {
+	m.v = v
+	m.timer.m = m
+	m.probeInit(v)
+}
 
 type ipNeighborKey struct {
 	Ip ip.Address
@@ -34,82 +80,390 @@ type IpNeighbor struct {
 	Ethernet Address
 	Ip       ip.Address
 	Si       vnet.Si
+	// Static neighbors are never aged or probed.
+	Static bool
 }
 
 type ipNeighbor struct {
 	IpNeighbor
-	index        uint
+	index uint
+	state NeighborState
+	// Time of last state change.
+	stateTime cpu.Time
+	// Last time neighbor's adjacency was seen to be used for forwarding.
 	lastTimeUsed cpu.Time
+	// Number of probes sent in probe state.
+	nProbes uint
+	// Fib where neighbor's adjacency is installed.
+	fi ip.FibIndex
+}
+
+func (n *ipNeighbor) hasAdjacency() bool {
+	return n.state != NeighborIncomplete && n.state != NeighborFailed
+}
+
+func (n *ipNeighbor) getAdjacency(im *ip.Main) (ai ip.Adj, ok bool) {
+	prefix := neighborPrefix(im, &n.IpNeighbor)
+	return im.GetRouteFibIndex(&prefix, n.fi)
 }
 
 //go:generate gentemplate -d Package=ethernet -id ipNeighbor -d PoolType=ipNeighborPool -d Data=neighbors -d Type=ipNeighbor github.com/platinasystems/elib/pool.tmpl
 
+// Hooks are called when a neighbor's adjacency is installed, rewritten with a new ethernet address or removed.
+type IpNeighborAddDelHook func(im *ip.Main, n *IpNeighbor, isDel bool)
+
+//go:generate gentemplate -id IpNeighborAddDelHook -d Package=ethernet -d DepsType=IpNeighborAddDelHookVec -d Type=IpNeighborAddDelHook -d Data=hooks github.com/platinasystems/elib/dep/dep.tmpl
+
+func (m *ipNeighborMain) RegisterIpNeighborAddDelHook(h IpNeighborAddDelHook) {
+	m.addDelHooks.Add(h)
+}
+
+func (m *ipNeighborMain) callAddDelHooks(im *ip.Main, in *ipNeighbor, isDel bool) {
+	for i := range m.addDelHooks.hooks {
+		m.addDelHooks.Get(i)(im, &in.IpNeighbor, isDel)
+	}
+}
+
 var ErrDelUnknownNeighbor = errors.New("delete unknown neighbor")
 
+func (m *ipNeighborMain) family(im *ip.Main) (nf *ipNeighborFamily) {
+	nf = &m.ipNeighborFamilies[im.Family]
+	if nf.im == nil {
+		im.RegisterInterfaceTableHook(m.interfaceTable)
+	}
+	nf.im = im
+	if nf.indexByAddress == nil {
+		nf.indexByAddress = make(map[ipNeighborKey]uint)
+	}
+	return
+}
+
+func neighborPrefix(im *ip.Main, n *IpNeighbor) (p ip.Prefix) {
+	p.Address = n.Ip
+	p.Len = 32
+	if im.Family == ip.Ip6 {
+		p.Len = 128
+	}
+	return
+}
+
+// AddDelIpNeighbor adds neighbor as reachable or deletes it along with its adjacency.
 func (m *ipNeighborMain) AddDelIpNeighbor(im *ip.Main, n *IpNeighbor, isDel bool) (err error) {
-	nf := &m.ipNeighborFamilies[im.Family]
+	if !isDel {
+		return m.UpdateIpNeighbor(im, n, NeighborReachable)
+	}
+	nf := m.family(im)
+	i, ok := nf.indexByAddress[ipNeighborKey{Ip: n.Ip, Si: n.Si}]
+	if !ok {
+		err = ErrDelUnknownNeighbor
+		return
+	}
+	return m.del(nf, i)
+}
 
-	var (
-		k  ipNeighborKey
-		i  uint
-		ok bool
-	)
-	k.Si, k.Ip = n.Si, n.Ip
-	if i, ok = nf.indexByAddress[k]; !ok {
-		if isDel {
-			err = ErrDelUnknownNeighbor
+// UpdateIpNeighbor adds or updates neighbor with given state.
+// Adjacency is installed or rewritten when ethernet address is new or has changed.
+// Dynamic updates leave static neighbors unchanged.
+func (m *ipNeighborMain) UpdateIpNeighbor(im *ip.Main, n *IpNeighbor, s NeighborState) (err error) {
+	nf := m.family(im)
+	k := ipNeighborKey{Ip: n.Ip, Si: n.Si}
+	i, ok := nf.indexByAddress[k]
+	if !ok {
+		i = nf.pool.GetIndex()
+		nf.pool.neighbors[i] = ipNeighbor{index: i, state: NeighborIncomplete}
+		nf.indexByAddress[k] = i
+	}
+	in := &nf.pool.neighbors[i]
+	if ok && in.Static && !n.Static {
+		return
+	}
+
+	if !n.Static {
+		m.startTimer()
+	}
+
+	hadAdjacency := in.hasAdjacency()
+	// Interface has moved to another table: adjacency moves with it.
+	if hadAdjacency && in.fi != im.FibIndexForSiAddress(n.Si, &n.Ip) {
+		if err = m.delAdjacency(im, in); err != nil {
 			return
 		}
-		i = nf.pool.GetIndex()
+		hadAdjacency = false
 	}
+	isChanged := !hadAdjacency || in.Ethernet != n.Ethernet
+	in.IpNeighbor = *n
+	if isChanged {
+		if err = m.setAdjacency(im, in, hadAdjacency); err != nil {
+			return
+		}
+	}
+	m.setState(in, s)
+	in.lastTimeUsed = in.stateTime
+	return
+}
+
+// SolicitIpNeighbor creates incomplete entry for address being resolved.
+// Entry fails unless it is updated with an ethernet address in time.
+func (m *ipNeighborMain) SolicitIpNeighbor(im *ip.Main, a *ip.Address, si vnet.Si) {
+	nf := m.family(im)
+	k := ipNeighborKey{Ip: *a, Si: si}
+	if _, ok := nf.indexByAddress[k]; ok {
+		return
+	}
+	i := nf.pool.GetIndex()
 	in := &nf.pool.neighbors[i]
+	*in = ipNeighbor{index: i}
+	in.Ip, in.Si = *a, si
+	m.setState(in, NeighborIncomplete)
+	nf.indexByAddress[k] = i
+	m.startTimer()
+}
+
+// ResolveIpNeighbor is as SolicitIpNeighbor but also sends first solicitation.
+// Used to resolve next hops of routes before any packet is sent to them.
+func (m *ipNeighborMain) ResolveIpNeighbor(im *ip.Main, a *ip.Address, si vnet.Si) {
+	nf := m.family(im)
+	k := ipNeighborKey{Ip: *a, Si: si}
+	if _, ok := nf.indexByAddress[k]; ok {
+		return
+	}
+	m.SolicitIpNeighbor(im, a, si)
+	m.probe(im, &nf.pool.neighbors[nf.indexByAddress[k]])
+}
+
+// Returns neighbor with given address on given interface and its state.
+func (m *ipNeighborMain) GetIpNeighbor(im *ip.Main, a *ip.Address, si vnet.Si) (n IpNeighbor, s NeighborState, ok bool) {
+	nf := &m.ipNeighborFamilies[im.Family]
+	var i uint
+	if i, ok = nf.indexByAddress[ipNeighborKey{Ip: *a, Si: si}]; ok {
+		in := &nf.pool.neighbors[i]
+		n, s = in.IpNeighbor, in.state
+	}
+	return
+}
 
+// ForeachIpNeighbor calls fn for each neighbor of given family with its state, seconds since
+// it was last used for forwarding and its adjacency (AdjNil when neighbor is unresolved).
+func (m *ipNeighborMain) ForeachIpNeighbor(im *ip.Main, fn func(n *IpNeighbor, s NeighborState, age float64, ai ip.Adj)) {
+	nf := &m.ipNeighborFamilies[im.Family]
+	now := cpu.TimeNow()
+	for _, i := range nf.indexByAddress {
+		in := &nf.pool.neighbors[i]
+		ai, t := ip.AdjNil, in.stateTime
+		if in.hasAdjacency() {
+			if a, ok := in.getAdjacency(im); ok {
+				ai = a
+			}
+			t = in.lastTimeUsed
+		}
+		fn(&in.IpNeighbor, in.state, m.v.TimeDiff(now, t), ai)
+	}
+}
+
+func (m *ipNeighborMain) setState(in *ipNeighbor, s NeighborState) {
+	in.state = s
+	in.stateTime = cpu.TimeNow()
+	in.nProbes = 0
+}
+
+// Add or rewrite /32 or /128 adjacency for neighbor.
+func (m *ipNeighborMain) setAdjacency(im *ip.Main, in *ipNeighbor, hadAdjacency bool) (err error) {
 	var (
-		ai     ip.Adj
-		as     []ip.Adjacency
-		prefix ip.Prefix
+		ai ip.Adj
+		as []ip.Adjacency
+		ok bool
 	)
-	prefix.Address = n.Ip
-	prefix.Len = 32
-	if im.Family == ip.Ip6 {
-		prefix.Len = 128
-	}
-	if ok {
-		ai, ok = im.GetRoute(&prefix, n.Si)
-		if !ok {
+	prefix := neighborPrefix(im, &in.IpNeighbor)
+	if hadAdjacency {
+		if ai, ok = in.getAdjacency(im); !ok {
 			panic("get route")
 		}
 		as = im.GetAdj(ai)
-		delete(nf.indexByAddress, k)
-	}
-	if isDel {
-		*in = ipNeighbor{}
 	} else {
-		is_new_adj := len(as) == 0
-		if is_new_adj {
-			ai, as = im.NewAdj(1)
-		}
-		m.v.SetRewrite(&as[0].Rewrite, n.Si, im.RewriteNode, im.PacketType, n.Ethernet[:])
-		as[0].LookupNextIndex = ip.LookupNextRewrite
+		ai, as = im.NewAdj(1)
+		in.fi = im.FibIndexForSiAddress(in.Si, &in.Ip)
+	}
+	m.v.SetRewrite(&as[0].Rewrite, in.Si, im.RewriteNode, im.PacketType, in.Ethernet[:])
+	as[0].LookupNextIndex = ip.LookupNextRewrite
 
-		if is_new_adj {
-			im.CallAdjAddHooks(ai)
-		}
+	if !hadAdjacency {
+		im.CallAdjAddHooks(ai)
+	}
+	if _, err = im.AddDelRoute(&prefix, in.fi, ai, false); err != nil {
+		return
+	}
+	m.callAddDelHooks(im, in, false)
+	return
+}
+
+func (m *ipNeighborMain) delAdjacency(im *ip.Main, in *ipNeighbor) (err error) {
+	prefix := neighborPrefix(im, &in.IpNeighbor)
+	ai, ok := in.getAdjacency(im)
+	if !ok {
+		return
+	}
+	if _, err = im.AddDelRoute(&prefix, in.fi, ai, true); err != nil {
+		return
+	}
+	im.CallAdjDelHooks(ai)
+	im.DelAdj(ai)
+	m.callAddDelHooks(im, in, true)
+	return
+}
 
-		if _, err = im.AddDelRoute(&prefix, im.FibIndexForSi(n.Si), ai, isDel); err != nil {
+func (m *ipNeighborMain) del(nf *ipNeighborFamily, i uint) (err error) {
+	in := &nf.pool.neighbors[i]
+	if in.hasAdjacency() {
+		if err = m.delAdjacency(nf.im, in); err != nil {
 			return
 		}
+	}
+	delete(nf.indexByAddress, ipNeighborKey{Ip: in.Ip, Si: in.Si})
+	*in = ipNeighbor{}
+	nf.pool.PutIndex(i)
+	return
+}
 
-		// Update neighbor fields (ethernet address may change).
-		in.IpNeighbor = *n
-		in.index = i
-		in.lastTimeUsed = cpu.TimeNow()
+// Moves adjacencies of interface's neighbors (static or dynamic) when interface changes table:
+// adjacencies are removed from old table before interface routes and added to new one afterwards.
+func (m *ipNeighborMain) interfaceTable(im *ip.Main, si vnet.Si, isDel bool) (err error) {
+	nf := m.family(im)
+	for _, i := range nf.indexByAddress {
+		in := &nf.pool.neighbors[i]
+		if in.Si != si || !in.hasAdjacency() {
+			continue
+		}
+		if isDel {
+			err = m.delAdjacency(im, in)
+		} else {
+			err = m.setAdjacency(im, in, false)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
 
-		if nf.indexByAddress == nil {
-			nf.indexByAddress = make(map[ipNeighborKey]uint)
+// RewriteIpNeighbors re-makes rewrites of adjacencies of interface's neighbors
+// (e.g. after ethernet address of interface has changed).
+func (m *ipNeighborMain) RewriteIpNeighbors(si vnet.Si) (err error) {
+	for f := range m.ipNeighborFamilies {
+		nf := &m.ipNeighborFamilies[f]
+		if nf.im == nil {
+			continue
+		}
+		for _, i := range nf.indexByAddress {
+			in := &nf.pool.neighbors[i]
+			if in.Si != si || !in.hasAdjacency() {
+				continue
+			}
+			if err = m.setAdjacency(nf.im, in, true); err != nil {
+				return
+			}
 		}
-		nf.indexByAddress[k] = i
 	}
+	return
+}
+
+// Returns whether neighbor's adjacency has been used for forwarding since last call.
+func (m *ipNeighborMain) isUsed(nf *ipNeighborFamily, in *ipNeighbor) bool {
+	ai, ok := in.getAdjacency(nf.im)
+	return ok && nf.im.GetAndClearAdjUsed(ai)
+}
 
+// Move dynamic neighbor between states.
+func (m *ipNeighborMain) age(nf *ipNeighborFamily, i uint, now cpu.Time) (err error) {
+	in := &nf.pool.neighbors[i]
+	if in.hasAdjacency() && m.isUsed(nf, in) {
+		in.lastTimeUsed = now
+	}
+	dt := m.v.TimeDiff(now, in.stateTime)
+	switch in.state {
+	case NeighborIncomplete:
+		// First solicitation is sent by glean node; others are retransmitted here.
+		if dt < neighborRetransTime {
+			break
+		}
+		if in.nProbes+1 >= neighborMaxProbes {
+			m.setState(in, NeighborFailed)
+		} else {
+			in.stateTime = now
+			m.probe(nf.im, in)
+		}
+	case NeighborFailed:
+		// Failed entries are kept for one timer interval so that they may be seen.
+		err = m.del(nf, i)
+	case NeighborReachable:
+		if dt > neighborReachableTime {
+			m.setState(in, NeighborStale)
+		}
+	case NeighborStale:
+		switch {
+		case in.lastTimeUsed > in.stateTime:
+			m.setState(in, NeighborProbe)
+			m.probe(nf.im, in)
+		case m.v.TimeDiff(now, in.lastTimeUsed) > neighborStaleTime:
+			err = m.del(nf, i)
+		}
+	case NeighborProbe:
+		if dt < neighborRetransTime {
+			break
+		}
+		if in.nProbes >= neighborMaxProbes {
+			// Adjacency is kept in probe state until it can be removed.
+			if err = m.delAdjacency(nf.im, in); err != nil {
+				break
+			}
+			m.setState(in, NeighborFailed)
+		} else {
+			in.stateTime = now
+			m.probe(nf.im, in)
+		}
+	}
 	return
 }
+
+// Timer event to age neighbors and send probes.
+type ipNeighborTimer struct {
+	vnet.Event
+	m       *ipNeighborMain
+	running bool
+}
+
+func (e *ipNeighborTimer) String() string {
+	n := 0
+	for i := range e.m.ipNeighborFamilies {
+		n += len(e.m.ipNeighborFamilies[i].indexByAddress)
+	}
+	return fmt.Sprintf("ip neighbor timer %d neighbors", n)
+}
+
+func (m *ipNeighborMain) startTimer() {
+	if !m.timer.running {
+		m.timer.running = true
+		m.probeNode.AddTimedEvent(&m.timer, neighborRetransTime)
+	}
+}
+
+func (e *ipNeighborTimer) EventAction() {
+	m := e.m
+	now := cpu.TimeNow()
+	nDynamic := 0
+	for f := range m.ipNeighborFamilies {
+		nf := &m.ipNeighborFamilies[f]
+		for _, i := range nf.indexByAddress {
+			if nf.pool.neighbors[i].Static {
+				continue
+			}
+			if err := m.age(nf, i, now); err != nil {
+				n := &nf.pool.neighbors[i]
+				m.v.Logf("ip neighbor %s: age: %s\n", n.Si.Name(m.v), err)
+			}
+			nDynamic++
+		}
+	}
+	if e.running = nDynamic > 0; e.running {
+		e.AddTimedEvent(e, neighborRetransTime)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ethernet
+
+import (
+	"github.com/platinasystems/vnet"
+	"github.com/platinasystems/vnet/ip"
+)
+
+// IpNeighborProber builds packets probing reachability of neighbors (arp requests, ip6 neighbor solicitations).
+type IpNeighborProber interface {
+	// Writes probe for neighbor into r and sets its length.
+	// Probes of known neighbors are unicast to neighbor's ethernet address; solicitations of neighbors
+	// being resolved (isSolicit) are broadcast or multicast.
+	// Returns packet type and ethernet destination of probe or false when no probe can be sent.
+	ProbeIpNeighbor(r *vnet.Ref, n *IpNeighbor, isSolicit bool) (t vnet.PacketType, dst Address, ok bool)
+}
+
+func (m *ipNeighborMain) RegisterIpNeighborProber(f ip.Family, p IpNeighborProber) {
+	m.probeNode.probers[f] = p
+}
+
+const (
+	probe_next_drop = iota
+)
+
+const (
+	probe_error_none = iota
+	probe_error_no_prober
+	probe_error_probes_sent
+)
+
+type ipNeighborProbe struct {
+	family    ip.Family
+	isSolicit bool
+	IpNeighbor
+}
+
+// Input node sending unicast probes to stale neighbors which are in use and solicitations for incomplete neighbors.
+// Probes are queued by neighbor timer and sent in buffers of node's own pool.
+type ipNeighborProbeNode struct {
+	vnet.InputNode
+	pool    vnet.BufferPool
+	probers [ip.NFamily]IpNeighborProber
+	pending []ipNeighborProbe
+	refs    vnet.RefVec
+	// Rewrites for probes sent by interface.
+	rewrites RewriteCache
+}
+
+func (m *ipNeighborMain) probeInit(v *vnet.Vnet) {
+	n := &m.probeNode
+	n.Next = []string{
+		probe_next_drop: "error",
+	}
+	n.Errors = []string{
+		probe_error_no_prober:   "no neighbor prober",
+		probe_error_probes_sent: "neighbor probes sent",
+	}
+	v.RegisterInputNode(n, "ip-neighbor-probe")
+	n.rewrites.Init(v)
+
+	p := &n.pool
+	t := &p.BufferTemplate
+	*t = vnet.DefaultBufferPool.BufferTemplate
+	p.Name = n.Name()
+	v.AddBufferPool(p)
+}
+
+// Queue probe for neighbor.
+func (m *ipNeighborMain) probe(im *ip.Main, in *ipNeighbor) {
+	n := &m.probeNode
+	in.nProbes++
+	n.pending = append(n.pending, ipNeighborProbe{
+		family:     im.Family,
+		isSolicit:  in.state == NeighborIncomplete,
+		IpNeighbor: in.IpNeighbor,
+	})
+	n.Activate(true)
+}
+
+func (n *ipNeighborProbeNode) NodeInput(o *vnet.RefOut) {
+	np := uint(len(n.pending))
+	if np > vnet.MaxVectorLen {
+		np = vnet.MaxVectorLen
+	}
+	if np == 0 {
+		n.Activate(false)
+		return
+	}
+	n.refs.Validate(np - 1)
+	rs := n.refs[:np]
+	n.pool.AllocRefs(rs)
+
+	nSent := uint(0)
+	for i := range rs {
+		r := &rs[i]
+		p := &n.pending[i]
+
+		x := uint(probe_next_drop)
+		if pr := n.probers[p.family]; pr == nil {
+			n.SetError(r, probe_error_no_prober)
+		} else if t, dst, ok := pr.ProbeIpNeighbor(r, &p.IpNeighbor, p.isSolicit); !ok {
+			n.SetError(r, probe_error_no_prober)
+		} else {
+			x = n.rewrites.PerformRewrite(n.Vnet, n, r, p.Si, t, &dst)
+			nSent++
+		}
+		o.Outs[x].BufferPool = &n.pool
+		no := o.Outs[x].AddLen(n.Vnet)
+		o.Outs[x].Refs[no] = *r
+	}
+	n.CountError(probe_error_probes_sent, nSent)
+
+	n.pending = n.pending[:copy(n.pending, n.pending[np:])]
+	n.Activate(len(n.pending) > 0)
+}
@@ -5,6 +5,8 @@
 package ethernet
 
 import (
+	"github.com/platinasystems/elib"
+	"github.com/platinasystems/elib/loop"
 	"github.com/platinasystems/vnet"
 )
 
@@ -14,6 +16,13 @@ type nodeMain struct {
 
 type inputNode struct {
 	vnet.InOutNode
+
+	// Next index indexed by ethernet type (in host byte order).
+	// Zero means type is not handled and packet will be dropped.
+	nextByType [1 << 16]uint8
+
+	// Node names to resolve for each registered type.
+	nodeNameByType map[Type]string
 }
 
 const (
@@ -21,15 +30,140 @@ const (
 	input_next_punt
 )
 
+const (
+	input_error_none = iota
+	input_error_too_short
+	input_error_unknown_type
+	input_error_unknown_vlan
+)
+
 func (m *Main) nodeInit(v *vnet.Vnet) {
 	n := &m.inputNode
 	n.Next = []string{
 		input_next_drop: "error",
 		input_next_punt: "punt",
 	}
+	n.Errors = []string{
+		input_error_too_short:    "packet too short",
+		input_error_unknown_type: "unknown ethernet type",
+		input_error_unknown_vlan: "unknown vlan",
+	}
 	v.RegisterInOutNode(n, "ethernet-input")
+
+	// Standard layer 3 protocols.  Nodes that are not present are punted.
+	m.RegisterType(IP4, "ip4-input")
+	m.RegisterType(IP6, "ip6-input")
+
+	// Punt ARP to kernel until an ARP node registers itself.
+	n.nextByType[ARP] = input_next_punt
 }
 
-func (node *inputNode) NodeInput(in *vnet.RefIn, out *vnet.RefOut) {
-	node.Redirect(in, out, input_next_punt)
+// RegisterType sends packets of given ethernet type to named node.
+func (m *Main) RegisterType(t Type, nodeName string) {
+	n := &m.inputNode
+	if n.nodeNameByType == nil {
+		n.nodeNameByType = make(map[Type]string)
+	}
+	n.nodeNameByType[t] = nodeName
+}
+
+// Resolve node names after all packages have registered their nodes.
+func (n *inputNode) LoopInit(l *loop.Loop) {
+	for t, name := range n.nodeNameByType {
+		if next, err := l.AddNamedNext(n, name); err == nil {
+			n.nextByType[t] = uint8(next)
+		} else {
+			n.nextByType[t] = input_next_punt
+		}
+	}
+}
+
+// Sets software interface of tagged packet to vlan sub interface.
+// Packets tagged with native vlan stay on hardware interface and have their tag removed.
+func (n *inputNode) setSubInterface(r *vnet.Ref, ids []uint) (ok bool) {
+	v := n.Vnet
+	hi := v.SupHi(r.Si)
+	var outer, inner uint
+	if len(ids) == 2 {
+		outer, inner = ids[0], ids[1]
+	} else {
+		inner = ids[0]
+	}
+	var si vnet.Si
+	if si, ok = v.HwIf(hi).SubSi(SubInterfaceId(outer, inner)); ok {
+		r.Si = si
+		return
+	}
+	if outer != 0 {
+		return
+	}
+	h, isEthernet := v.HwIfer(hi).(HwInterfacer)
+	if !isEthernet {
+		return
+	}
+	if nv := h.GetInterface().NativeVlan.Id(); nv == 0 || nv != inner {
+		return
+	}
+	// Slide addresses over tag so that packet has untagged ethernet header.
+	b := r.DataSlice()
+	copy(b[VlanHeaderBytes:2*AddressBytes+VlanHeaderBytes], b[:2*AddressBytes])
+	r.Advance(VlanHeaderBytes)
+	ok = true
+	return
+}
+
+func (n *inputNode) inputNext(r *vnet.Ref) (next uint) {
+	if r.DataLen() < HeaderBytes {
+		n.SetError(r, input_error_too_short)
+		return input_next_drop
+	}
+
+	h := GetHeader(r)
+	t := h.GetType()
+	advance := HeaderBytes
+
+	// Skip over (possibly double) vlan tags to find inner type.
+	var (
+		ids   [2]uint
+		nTags int
+	)
+	for nTags < 2 && isVlanType(t) {
+		if r.DataLen() < uint(advance+VlanHeaderBytes) {
+			n.SetError(r, input_error_too_short)
+			return input_next_drop
+		}
+		vh := (*VlanHeader)(elib.PointerAdd(r.Data(), uintptr(advance)))
+		t = vh.GetType()
+		ids[nTags] = vh.GetId()
+		nTags++
+		advance += VlanHeaderBytes
+	}
+	if nTags > 0 && !n.setSubInterface(r, ids[:nTags]) {
+		n.SetError(r, input_error_unknown_vlan)
+		return input_next_drop
+	}
+	if nTags == 1 && r.Si == n.Vnet.SupSi(r.Si) {
+		advance -= VlanHeaderBytes
+	}
+
+	next = uint(n.nextByType[t])
+	switch next {
+	case input_next_drop:
+		n.SetError(r, input_error_unknown_type)
+	case input_next_punt:
+		// Punted packets keep their ethernet header.
+	default:
+		r.Advance(advance)
+	}
+	return
+}
+
+func (n *inputNode) NodeInput(in *vnet.RefIn, o *vnet.RefOut) {
+	for i := uint(0); i < in.Len(); i++ {
+		r := &in.Refs[i]
+		x := n.inputNext(r)
+		o.Outs[x].BufferPool = in.BufferPool
+		no := o.Outs[x].AddLen(n.Vnet)
+		o.Outs[x].Refs[no] = *r
+	}
 }
@@ -30,5 +30,6 @@ func (m *Main) Init() (err error) {
 	m.ipNeighborMain.init(v)
 	m.nodeInit(v)
 	m.pgMain.pgInit(v)
+	m.cliInit(v)
 	return
 }
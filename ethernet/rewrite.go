@@ -0,0 +1,76 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ethernet
+
+import (
+	"github.com/platinasystems/vnet"
+)
+
+// RewriteCache holds rewrites by interface for nodes sending packets to varying destinations
+// (arp replies, neighbor advertisements, probes).  Rewrite and its next (vnet.SetRewrite adds
+// a next to node) are made once per interface and packet type; only destination address is set per packet.
+type RewriteCache struct {
+	rws map[rewriteCacheKey]*cachedRewrite
+}
+
+type rewriteCacheKey struct {
+	si vnet.Si
+	t  vnet.PacketType
+}
+
+type cachedRewrite struct {
+	vnet.Rewrite
+	// Interface state rewrite was made with: rewrite is re-made when this changes.
+	src           Address
+	maxPacketSize uint
+}
+
+// Init removes cached rewrites of deleted interfaces.
+func (c *RewriteCache) Init(v *vnet.Vnet) {
+	c.rws = make(map[rewriteCacheKey]*cachedRewrite)
+	v.RegisterSwIfAddDelHook(c.swIfAddDel)
+}
+
+func (c *RewriteCache) swIfAddDel(v *vnet.Vnet, si vnet.Si, isDel bool) (err error) {
+	if isDel {
+		for k := range c.rws {
+			if k.si == si {
+				delete(c.rws, k)
+			}
+		}
+	}
+	return
+}
+
+// PerformRewrite adds ethernet header with given destination for interface si to packet.
+// Returns next index of noder for interface's output node.
+func (c *RewriteCache) PerformRewrite(v *vnet.Vnet, noder vnet.Noder, r *vnet.Ref, si vnet.Si, t vnet.PacketType, dst *Address) (next uint) {
+	hw := v.SupHwIf(v.SwIf(si))
+	h, ok := v.HwIfer(hw.Hi()).(HwInterfacer)
+	if !ok {
+		// Not an ethernet interface: header layout is unknown so no caching.
+		var rw vnet.Rewrite
+		v.SetRewrite(&rw, si, noder, t, dst[:])
+		vnet.PerformRewrite(r, &rw)
+		r.Si = rw.Si
+		return uint(rw.NextIndex)
+	}
+	ei := h.GetInterface()
+	k := rewriteCacheKey{si: si, t: t}
+	e, ok := c.rws[k]
+	if !ok || e.src != ei.Address || e.maxPacketSize != hw.MaxPacketSize() {
+		if !ok {
+			e = &cachedRewrite{}
+			c.rws[k] = e
+		}
+		v.SetRewrite(&e.Rewrite, si, noder, t, nil)
+		e.src = ei.Address
+		e.maxPacketSize = hw.MaxPacketSize()
+	}
+	vnet.PerformRewrite(r, &e.Rewrite)
+	(*Header)(r.Data()).Dst = *dst
+	r.Si = e.Si
+	return uint(e.NextIndex)
+}
@@ -56,6 +56,7 @@ const (
 	MAC_CONTROL             Type = 0x8808
 	SLOW_PROTOCOLS          Type = 0x8809
 	PPP                     Type = 0x880B
+	VLAN_8021AD             Type = 0x88A8
 	MPLS_UNICAST            Type = 0x8847
 	MPLS_MULTICAST          Type = 0x8848
 	PPPOE_DISCOVERY         Type = 0x8863
@@ -120,6 +121,7 @@ var typeStrings = [...]string{
 	MAC_CONTROL:             "MAC_CONTROL",
 	SLOW_PROTOCOLS:          "SLOW_PROTOCOLS",
 	PPP:                     "PPP",
+	VLAN_8021AD:             "VLAN_8021AD",
 	MPLS_UNICAST:            "MPLS_UNICAST",
 	MPLS_MULTICAST:          "MPLS_MULTICAST",
 	PPPOE_DISCOVERY:         "PPPOE_DISCOVERY",
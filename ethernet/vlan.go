@@ -0,0 +1,97 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ethernet
+
+import (
+	"github.com/platinasystems/elib/parse"
+	"github.com/platinasystems/vnet"
+
+	"fmt"
+)
+
+// Vlan sub interface ids: inner (or only) vlan id in low 12 bits; outer vlan id of double tagged packets above.
+const (
+	vlanIdBits = 12
+	vlanIdMask = 1<<vlanIdBits - 1
+	// Vlan ids 0 and 4095 are reserved.
+	MaxVlanId = vlanIdMask - 1
+)
+
+func SubInterfaceId(outer, inner uint) vnet.IfIndex {
+	return vnet.IfIndex(outer<<vlanIdBits | inner)
+}
+
+func subInterfaceTags(id vnet.IfIndex) (outer, inner uint) {
+	outer, inner = uint(id>>vlanIdBits), uint(id&vlanIdMask)
+	return
+}
+
+// Number of vlan tags for given software interface.
+func nVlanTags(v *vnet.Vnet, si vnet.Si) uint {
+	if v.SupSi(si) == si {
+		return 0
+	}
+	if outer, _ := subInterfaceTags(v.SwIf(si).Id(v)); outer != 0 {
+		return 2
+	}
+	return 1
+}
+
+func (vid Vlan) Id() uint { return uint(vid) & vlanIdMask }
+
+// Outer tags of double tagged packets are 802.1ad unless configured otherwise (e.g. VLAN_IN_VLAN).
+func (hi *Interface) qinqType() Type {
+	if hi.QinqType != 0 {
+		return hi.QinqType
+	}
+	return VLAN_8021AD
+}
+
+// Tag types accepted on input.
+func isVlanType(t Type) bool { return t == VLAN || t == VLAN_8021AD || t == VLAN_IN_VLAN }
+
+func (h *VlanHeader) GetId() uint { return uint(h.Priority_cfi_and_id.ToHost()) & vlanIdMask }
+
+// See vnet.SubInterfaceIder interface.
+func (hi *Interface) FormatSubInterfaceId(id vnet.IfIndex) string {
+	outer, inner := subInterfaceTags(id)
+	if outer != 0 {
+		return fmt.Sprintf("%d.%d", outer, inner)
+	}
+	return fmt.Sprintf("%d", inner)
+}
+
+func (hi *Interface) ParseSubInterfaceId(in *parse.Input, id *vnet.IfIndex) (ok bool) {
+	var outer, inner uint
+	switch {
+	case in.Parse(".%d.%d", &outer, &inner):
+	case in.Parse(".%d", &inner):
+	default:
+		return
+	}
+	*id = SubInterfaceId(outer, inner)
+	ok = true
+	return
+}
+
+// CreateSubInterface creates vlan sub interface of ethernet interface.
+// Outer vlan id is zero for single tagged sub interfaces.
+func (m *Main) CreateSubInterface(hi vnet.Hi, outer, inner uint) (si vnet.Si, err error) {
+	v := m.Vnet
+	h, ok := v.HwIfer(hi).(HwInterfacer)
+	if !ok {
+		err = fmt.Errorf("%s: not an ethernet interface", hi.Name(v))
+		return
+	}
+	if inner == 0 || inner > MaxVlanId || outer > MaxVlanId {
+		err = fmt.Errorf("vlan id out of range 1-%d", MaxVlanId)
+		return
+	}
+	if nv := h.GetInterface().NativeVlan.Id(); outer == 0 && nv != 0 && inner == nv {
+		err = fmt.Errorf("%s: vlan %d is native vlan", hi.Name(v), inner)
+		return
+	}
+	return v.NewSubInterface(hi, SubInterfaceId(outer, inner))
+}
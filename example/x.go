@@ -16,6 +16,8 @@ import (
 	ipcli "github.com/platinasystems/vnet/ip/cli"
 	"github.com/platinasystems/vnet/ip4"
 	"github.com/platinasystems/vnet/ip6"
+	"github.com/platinasystems/vnet/mpls"
+	"github.com/platinasystems/vnet/nd"
 	"github.com/platinasystems/vnet/pg"
 	"github.com/platinasystems/vnet/unix"
 
@@ -301,7 +303,10 @@ func main() {
 	unix.Init(v)
 	ethernet.Init(v)
 	ip4.Init(v)
+	arp.Init(v)
 	ip6.Init(v)
+	nd.Init(v)
+	mpls.Init(v)
 	ixge.Init(v)
 	pg.Init(v)
 	ipcli.Init(v)
@@ -60,6 +60,9 @@ func (h *HwIf) Hi() Hi         { return h.hi }
 func (h *HwIf) IsUnix() bool   { return false }
 
 func (h *HwIf) SetName(v *Vnet, name string) {
+	if h.name != "" {
+		delete(v.hwIfIndexByName, h.name)
+	}
 	h.name = name
 	v.hwIfIndexByName.Set(name, uint(h.hi))
 }
@@ -75,6 +78,20 @@ func (h *HwIf) SetSubInterface(id IfIndex, si Si) {
 	h.subSiById[id] = si
 }
 
+// SubSi returns software interface for given sub interface id.
+func (h *HwIf) SubSi(id IfIndex) (si Si, ok bool) {
+	si, ok = h.subSiById[id]
+	return
+}
+
+// Hardware interfaces may implement this interface to name sub interface ids
+// (e.g. ethernet outer.inner vlan tags).  Default is a single decimal number.
+type SubInterfaceIder interface {
+	FormatSubInterfaceId(id IfIndex) string
+	// Parses sub interface suffix of interface name including leading ".".
+	ParseSubInterfaceId(in *parse.Input, id *IfIndex) bool
+}
+
 func (h *HwIf) LinkString() (s string) {
 	s = "down"
 	if h.linkUp {
@@ -143,12 +160,17 @@ type swIf struct {
 
 //go:generate gentemplate -d Package=vnet -id swIf -d PoolType=swIfPool -d Type=swIf -d Data=elts github.com/platinasystems/elib/pool.tmpl
 
-func (m *Vnet) NewSwIf(typ swIfType, id IfIndex) (si Si) {
+func (m *Vnet) NewSwIf(typ swIfType, id IfIndex) (si Si) { return m.newSwIf(typ, id, SiNil) }
+
+func (m *Vnet) newSwIf(typ swIfType, id IfIndex, supSi Si) (si Si) {
 	si = Si(m.swInterfaces.GetIndex())
 	s := m.SwIf(si)
 	s.typ = typ
 	s.si = si
 	s.supSi = si
+	if supSi != SiNil {
+		s.supSi = supSi
+	}
 	s.id = id
 	m.counterValidateSw(si)
 
@@ -162,6 +184,25 @@ func (m *Vnet) NewSwIf(typ swIfType, id IfIndex) (si Si) {
 	return
 }
 
+// NewSubInterface creates sub interface of given hardware interface with given id.
+func (v *Vnet) NewSubInterface(hi Hi, id IfIndex) (si Si, err error) {
+	h := v.HwIf(hi)
+	if _, ok := h.SubSi(id); ok {
+		err = fmt.Errorf("%s: sub interface %s already exists", h.name, v.formatSubInterfaceId(hi, id))
+		return
+	}
+	si = v.newSwIf(swIfTypeSubInterface, id, h.si)
+	h.SetSubInterface(id, si)
+	return
+}
+
+func (m *interfaceMain) formatSubInterfaceId(hi Hi, id IfIndex) string {
+	if f, ok := m.HwIfer(hi).(SubInterfaceIder); ok {
+		return f.FormatSubInterfaceId(id)
+	}
+	return fmt.Sprintf("%d", id)
+}
+
 func (m *interfaceMain) SwIf(i Si) *swIf { return &m.swInterfaces.elts[i] }
 func (m *interfaceMain) SupSi(i Si) Si   { return m.SwIf(i).supSi }
 func (m *interfaceMain) SupSwIf(s *swIf) (sup *swIf) {
@@ -192,9 +233,10 @@ func (m *interfaceMain) HwIferForSi(i Si) (h HwInterfacer, ok bool) {
 }
 
 func (s *swIf) IfName(vn *Vnet) (v string) {
-	v = vn.SupHwIf(s).name
+	h := vn.SupHwIf(s)
+	v = h.name
 	if s.typ != swIfTypeHardware {
-		v += fmt.Sprintf(".%d", s.id)
+		v += "." + vn.formatSubInterfaceId(h.hi, s.id)
 	}
 	return
 }
@@ -511,6 +553,36 @@ type Devicer interface {
 	GetHwInterfaceCounterValues(t *InterfaceThread)
 }
 
+// Offloads performed by hardware on output.
+type Offloads uint
+
+const (
+	// Tcp and udp checksums over ip4 and ip6.
+	OffloadChecksum Offloads = 1 << iota
+	// Tcp segmentation over ip4.
+	OffloadTso4
+	// Tcp segmentation over ip6.
+	OffloadTso6
+)
+
+// Hardware interfaces which checksum and segment packets on output implement OffloadOutputer.
+// Packets needing these offloads (e.g. from kernel via tuntap virtio net header) are passed to them as is
+// with offload request in Ref's TxOffload; for all other packets TxOffload is zero.
+type OffloadOutputer interface {
+	OutputOffloads() Offloads
+}
+
+// Offload request of packet to output of an OffloadOutputer.
+// Checksum of layer 4 header at given offset is to be finished and packet is segmented when segment size is non-zero.
+type TxOffload uint32
+
+func MakeTxOffload(l4Offset, segmentSize uint) TxOffload {
+	return TxOffload(l4Offset<<16 | segmentSize)
+}
+
+func (o TxOffload) L4Offset() uint    { return uint(o >> 16) }
+func (o TxOffload) SegmentSize() uint { return uint(o & 0xffff) }
+
 type SwIfAddDelHook func(v *Vnet, si Si, isDel bool) error
 type SwIfAdminUpDownHook func(v *Vnet, si Si, isUp bool) error
 type SwIfCounterSyncHook func(v *Vnet)
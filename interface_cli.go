@@ -34,7 +34,12 @@ func (si *Si) ParseWithArgs(in *parse.Input, args *parse.Args) {
 		id IfIndex
 		ok bool
 	)
-	if in.Parse(".%d", &id) {
+	if f, isIder := v.HwIfer(hi).(SubInterfaceIder); isIder {
+		ok = f.ParseSubInterfaceId(in, &id)
+	} else {
+		ok = in.Parse(".%d", &id)
+	}
+	if ok {
 		if *si, ok = hw.subSiById[id]; !ok {
 			panic(fmt.Errorf("unkown sub interface id: %d", id))
 		}
@@ -39,16 +39,24 @@ const (
 	// might be another node for further output processing.
 	LookupNextRewrite
 
+	// Packet is dropped and icmp destination unreachable is sent to its source.
+	LookupNextUnreachable
+
+	// Packet is dropped and icmp administratively prohibited is sent to its source.
+	LookupNextProhibit
+
 	LookupNNext
 )
 
 var lookupNextNames = [...]string{
-	LookupNextMiss:    "miss",
-	LookupNextDrop:    "drop",
-	LookupNextPunt:    "punt",
-	LookupNextLocal:   "local",
-	LookupNextGlean:   "glean",
-	LookupNextRewrite: "rewrite",
+	LookupNextMiss:        "miss",
+	LookupNextDrop:        "drop",
+	LookupNextPunt:        "punt",
+	LookupNextLocal:       "local",
+	LookupNextGlean:       "glean",
+	LookupNextRewrite:     "rewrite",
+	LookupNextUnreachable: "unreachable",
+	LookupNextProhibit:    "prohibit",
 }
 
 func (n LookupNext) String() string { return elib.StringerHex(lookupNextNames[:], int(n)) }
@@ -57,7 +65,7 @@ func (n *LookupNext) Parse(in *parse.Input) {
 	switch text := in.Token(); text {
 	case "miss":
 		*n = LookupNextMiss
-	case "drop":
+	case "drop", "blackhole":
 		*n = LookupNextDrop
 	case "punt":
 		*n = LookupNextPunt
@@ -67,6 +75,10 @@ func (n *LookupNext) Parse(in *parse.Input) {
 		*n = LookupNextGlean
 	case "rewrite":
 		*n = LookupNextRewrite
+	case "unreachable":
+		*n = LookupNextUnreachable
+	case "prohibit":
+		*n = LookupNextProhibit
 	default:
 		panic(parse.ErrInput)
 	}
@@ -158,6 +170,10 @@ type adjacencyMain struct {
 
 	threads []*adjacencyThread
 
+	// Set by rewrite nodes when adjacency is used to forward packets.
+	// Read and cleared by neighbor aging.
+	adjUses []adjUse
+
 	adjAddDelHookVec
 	adjSyncCounterHookVec
 	adjGetCounterHookVec
@@ -165,6 +181,13 @@ type adjacencyMain struct {
 	missAdjIndex Adj
 }
 
+type adjUse struct {
+	used bool
+	// Adjacency marked as used when this one is used.
+	// Multipath block members mark their next hop's adjacency; others mark themselves.
+	alias Adj
+}
+
 type adjAddDelHook func(m *Main, adj Adj, isDel bool)
 type adjSyncCounterHook func(m *Main)
 type AdjGetCounterHandler func(tag string, v vnet.CombinedCounter)
@@ -205,6 +228,11 @@ type multipathMain struct {
 
 	// Indexed by heap id.  So, one element per heap block.
 	mpAdjVec multipathAdjacencyVec
+
+	// When set multipath blocks have fixed number of buckets and are derived from
+	// previous block so that only buckets of added or removed next hops are remapped.
+	resilientHash    bool
+	resilientBuckets uint
 }
 
 func (m *multipathMain) GetNextHops(i uint) []nextHop {
@@ -351,6 +379,105 @@ func (raw nextHopVec) normalizePow2(m *multipathMain, result *nextHopVec) (nAdj
 	return
 }
 
+// Default number of buckets for resilient hash blocks.
+const defaultResilientBuckets = 64
+
+// SetResilientHash enables or disables resilient hashing for multipath adjacencies created from now on.
+// Number of buckets is rounded up to a power of 2; zero means default.
+func (m *Main) SetResilientHash(enable bool, nBuckets uint) {
+	mp := &m.multipathMain
+	mp.resilientHash = enable
+	if nBuckets == 0 {
+		nBuckets = defaultResilientBuckets
+	}
+	mp.resilientBuckets = uint(elib.Word(nBuckets).MaxPow2())
+}
+
+func (m *Main) ResilientHash() (enabled bool, nBuckets uint) {
+	mp := &m.multipathMain
+	return mp.resilientHash, mp.resilientBuckets
+}
+
+// Assign next hops to resilient hash buckets.  Result has one next hop of weight 1 per bucket.
+// Given previous bucket assignment (of same size) buckets are kept unless their next hop has been
+// removed or has more buckets than its share; freed buckets are given to next hops below their share.
+func (raw nextHopVec) normalizeResilient(m *multipathMain, old nextHopVec, result *nextHopVec) (nAdj uint, norm nextHopVec) {
+	n := raw.Len()
+	if n == 0 {
+		return
+	}
+
+	nAdj = m.resilientBuckets
+	if nAdj == 0 {
+		nAdj = defaultResilientBuckets
+	}
+	if nAdj < n {
+		nAdj = uint(elib.Word(n).MaxPow2())
+	}
+
+	// First n elements are next hops with number of buckets as weight; next nAdj are buckets.
+	t := *result
+	t.Validate(n + nAdj - 1)
+	*result = t
+	nhs, buckets := t[:n], t[n:n+nAdj]
+	copy(nhs, raw)
+	sort.Sort(nextHopSort(nhs))
+
+	sumWeight := float64(0)
+	for i := range nhs {
+		sumWeight += float64(nhs[i].weight)
+	}
+	if sumWeight == 0 {
+		for i := range nhs {
+			nhs[i].weight = 1
+		}
+		sumWeight = float64(n)
+	}
+
+	// Share of buckets for each next hop: round down and give left over buckets to largest weights.
+	nLeft := nAdj
+	w := float64(nAdj) / sumWeight
+	for i := range nhs {
+		c := uint(w * float64(nhs[i].weight))
+		nhs[i].weight = NextHopWeight(c)
+		nLeft -= c
+	}
+	for i := uint(0); nLeft > 0; i = (i + 1) % n {
+		nhs[i].weight++
+		nLeft--
+	}
+
+	for i := range buckets {
+		buckets[i] = nextHop{adj: AdjNil, weight: 1}
+	}
+
+	// Keep previous buckets of next hops up to their share.
+	if uint(len(old)) == nAdj {
+		for i := range old {
+			if j, ok := nhs.find(old[i].adj); ok && nhs[j].weight > 0 {
+				buckets[i].adj = old[i].adj
+				nhs[j].weight--
+			}
+		}
+	}
+
+	// Fill remaining buckets.
+	j := 0
+	for i := range buckets {
+		if buckets[i].adj != AdjNil {
+			continue
+		}
+		for nhs[j].weight == 0 {
+			j++
+		}
+		buckets[i].adj = nhs[j].adj
+		nhs[j].weight--
+	}
+
+	norm = buckets
+	return
+}
+
 func (m *multipathMain) allocNextHopBlock(b *nextHopBlock, key nextHopVec) {
 	n := uint(len(key))
 	o := m.nextHopHeap.Get(n)
@@ -394,11 +521,28 @@ type multipathAdjacency struct {
 	// Unnormalized next hops are saved so that control plane has a record of exactly
 	// what the RIB told it.
 	unnormalizedNextHops nextHopBlock
+
+	// Normalized next hops are one per bucket for resilient hash blocks.
+	isResilient bool
 }
 
-func (m *Main) getMpAdj(unnorm nextHopVec, create bool) (madj *multipathAdjacency, madjIndex uint, ok bool) {
+// Get multipath adjacency for given next hops.  For resilient hashing buckets are assigned based on
+// those of old adjacency (if any).
+func (m *Main) getMpAdj(unnorm nextHopVec, old *multipathAdjacency, create bool) (madj *multipathAdjacency, madjIndex uint, ok bool) {
 	mp := &m.multipathMain
-	nAdj, norm := unnorm.normalizePow2(mp, &mp.cachedNextHopVec[1])
+	var (
+		nAdj uint
+		norm nextHopVec
+	)
+	if mp.resilientHash {
+		var oldBuckets nextHopVec
+		if old != nil && old.isResilient {
+			oldBuckets = nextHopVec(mp.getNextHopBlock(&old.normalizedNextHops))
+		}
+		nAdj, norm = unnorm.normalizeResilient(mp, oldBuckets, &mp.cachedNextHopVec[1])
+	} else {
+		nAdj, norm = unnorm.normalizePow2(mp, &mp.cachedNextHopVec[1])
+	}
 
 	// Use unnormalized next hops to see if we've seen a block equivalent to this one before.
 	var i uint
@@ -410,12 +554,14 @@ func (m *Main) getMpAdj(unnorm nextHopVec, create bool) (madj *multipathAdjacenc
 
 	// Copy next hops into power of 2 adjacency block one for each weight.
 	ai, as := m.NewAdj(nAdj)
+	i = 0
 	for nhi := range norm {
 		nh := &norm[nhi]
 		nextHopAdjacency := &m.adjacencyHeap.elts[nh.adj]
 		for w := NextHopWeight(0); w < nh.weight; w++ {
 			as[i] = *nextHopAdjacency
 			as[i].NAdj = uint16(nAdj)
+			m.adjUses[uint(ai)+i].alias = nh.adj
 			i++
 		}
 	}
@@ -425,6 +571,7 @@ func (m *Main) getMpAdj(unnorm nextHopVec, create bool) (madj *multipathAdjacenc
 	madj.adj = ai
 	madj.nAdj = uint32(nAdj)
 	madj.referenceCount = 0 // caller will set to 1
+	madj.isResilient = mp.resilientHash
 
 	mp.allocNextHopBlock(&madj.normalizedNextHops, norm)
 	mp.allocNextHopBlock(&madj.unnormalizedNextHops, unnorm)
@@ -440,8 +587,8 @@ func (m *Main) getMpAdj(unnorm nextHopVec, create bool) (madj *multipathAdjacenc
 	ok = true
 	return
 }
-func (m *Main) createMpAdj(unnorm nextHopVec) (*multipathAdjacency, uint, bool) {
-	return m.getMpAdj(unnorm, true)
+func (m *Main) createMpAdj(unnorm nextHopVec, old *multipathAdjacency) (*multipathAdjacency, uint, bool) {
+	return m.getMpAdj(unnorm, old, true)
 }
 
 func (m *adjacencyMain) mpAdjForAdj(a Adj, validate bool) (ma *multipathAdjacency, maIndex uint) {
@@ -514,7 +661,7 @@ func (m *Main) AddDelNextHop(oldAdj Adj, isDel bool, nextHopAdj Adj, nextHopWeig
 	}
 
 	if len(t) > 0 {
-		new, _, _ = m.createMpAdj(t)
+		new, _, _ = m.createMpAdj(t, old)
 		// Fetch again since create may have moved vector.
 		if old != nil {
 			old = &mm.mpAdjVec[oldMaIndex]
@@ -601,7 +748,9 @@ func (m *Main) delMultipathAdj(toDel Adj) {
 			t := mm.cachedNextHopVec[0]
 			t = mm.delNextHop(nhs, t, nhi)
 			mm.cachedNextHopVec[0] = t
-			newMa, newMaIndex, _ = m.createMpAdj(t)
+			newMa, newMaIndex, _ = m.createMpAdj(t, ma)
+			// Fetch again since create may have moved vector.
+			ma = &mm.mpAdjVec[maIndex]
 		}
 
 		m.Remaps[ma.adj] = AdjRemapNil
@@ -700,6 +849,9 @@ func (m *adjacencyMain) GetAdj(a Adj) (as []Adjacency) { return m.adjacencyHeap.
 func (m *adjacencyMain) NewAdjWithTemplate(n uint, template *Adjacency) (ai Adj, as []Adjacency) {
 	ai = Adj(m.adjacencyHeap.Get(n))
 	m.validateCounter(ai)
+	if l := uint(ai) + n; l > uint(len(m.adjUses)) {
+		m.adjUses = append(m.adjUses, make([]adjUse, l-uint(len(m.adjUses)))...)
+	}
 	as = m.GetAdj(ai)
 	for i := range as {
 		if template != nil {
@@ -708,11 +860,55 @@ func (m *adjacencyMain) NewAdjWithTemplate(n uint, template *Adjacency) (ai Adj,
 		as[i].Si = vnet.SiNil
 		as[i].NAdj = uint16(n)
 		m.clearCounter(ai + Adj(i))
+		m.adjUses[uint(ai)+uint(i)] = adjUse{alias: ai + Adj(i)}
 	}
 	return
 }
 func (m *adjacencyMain) NewAdj(n uint) (Adj, []Adjacency) { return m.NewAdjWithTemplate(n, nil) }
 
+// Returns single adjacency with given index; index may be within a multipath block.
+func (m *adjacencyMain) GetAdjacency(a Adj) *Adjacency { return &m.adjacencyHeap.elts[a] }
+
+// Adjacency found by input node lookup is carried with packet to next node.
+func SetRefAdj(r *vnet.Ref, a Adj) { r.Aux = uint32(a) }
+func GetRefAdj(r *vnet.Ref) Adj    { return Adj(r.Aux) }
+
+// MultipathAdj chooses adjacency within power of 2 sized multipath block starting at a using flow hash h.
+func (m *adjacencyMain) MultipathAdj(a Adj, h uint32) Adj {
+	if n := uint32(m.adjacencyHeap.elts[a].NAdj); n > 1 {
+		a += Adj(h & (n - 1))
+	}
+	return a
+}
+
+// ForeachNextHop calls fn for each next hop of a multipath adjacency with its weight.
+// Other adjacencies are their own single next hop with weight 1.
+func (m *Main) ForeachNextHop(a Adj, fn func(nh Adj, w NextHopWeight)) {
+	if ma, _ := m.mpAdjForAdj(a, false); ma != nil && ma.isValid() && ma.adj == a {
+		nhs := m.multipathMain.getNextHopBlock(&ma.unnormalizedNextHops)
+		for i := range nhs {
+			fn(nhs[i].adj, nhs[i].weight)
+		}
+		return
+	}
+	fn(a, 1)
+}
+
+func (m *adjacencyMain) SetAdjUsed(a Adj) {
+	if uint(a) < uint(len(m.adjUses)) {
+		m.adjUses[m.adjUses[a].alias].used = true
+	}
+}
+
+// GetAndClearAdjUsed returns whether adjacency has been used since last call.
+func (m *adjacencyMain) GetAndClearAdjUsed(a Adj) (used bool) {
+	if uint(a) < uint(len(m.adjUses)) {
+		u := &m.adjUses[a]
+		used, u.used = u.used, false
+	}
+	return
+}
+
 func (m *multipathMain) init() {
 	m.nextHopHash.Init(m, 32)
 }
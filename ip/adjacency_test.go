@@ -0,0 +1,70 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ip
+
+import (
+	"testing"
+)
+
+func testNextHops(adjs ...Adj) (v nextHopVec) {
+	for _, a := range adjs {
+		v = append(v, nextHop{adj: a, weight: 1})
+	}
+	return
+}
+
+// Adding or removing a single next hop must only move buckets to or from that next hop;
+// all other buckets keep their next hop and each next hop ends up with its share of buckets.
+func TestNormalizeResilient(t *testing.T) {
+	tests := []struct {
+		name       string
+		old, new   nextHopVec
+		nBuckets   uint
+		changedAdj Adj
+	}{
+		{name: "add", old: testNextHops(1, 2, 3), new: testNextHops(1, 2, 3, 4), nBuckets: 64, changedAdj: 4},
+		{name: "add to one", old: testNextHops(1), new: testNextHops(1, 2), nBuckets: 8, changedAdj: 2},
+		{name: "remove", old: testNextHops(1, 2, 3, 4), new: testNextHops(1, 2, 4), nBuckets: 64, changedAdj: 3},
+		{name: "remove first", old: testNextHops(1, 2, 3), new: testNextHops(2, 3), nBuckets: 16, changedAdj: 1},
+		{name: "remove to one", old: testNextHops(5, 6), new: testNextHops(6), nBuckets: 8, changedAdj: 5},
+	}
+	for _, x := range tests {
+		m := &multipathMain{resilientBuckets: x.nBuckets}
+		var result nextHopVec
+		nAdj, norm := x.old.normalizeResilient(m, nil, &result)
+		if nAdj != x.nBuckets || uint(len(norm)) != x.nBuckets {
+			t.Errorf("%s: got %d adjacencies %d buckets, want %d", x.name, nAdj, len(norm), x.nBuckets)
+			continue
+		}
+		old := append(nextHopVec(nil), norm...)
+
+		nAdj, norm = x.new.normalizeResilient(m, old, &result)
+		if nAdj != x.nBuckets || uint(len(norm)) != x.nBuckets {
+			t.Errorf("%s: got %d adjacencies %d buckets after change, want %d", x.name, nAdj, len(norm), x.nBuckets)
+			continue
+		}
+		counts := make(map[Adj]uint)
+		for i := range norm {
+			a, o := norm[i].adj, old[i].adj
+			counts[a]++
+			if a != o && a != x.changedAdj && o != x.changedAdj {
+				t.Errorf("%s: bucket %d moved from %d to %d", x.name, i, o, a)
+			}
+			if norm[i].weight != 1 {
+				t.Errorf("%s: bucket %d weight %d, want 1", x.name, i, norm[i].weight)
+			}
+		}
+		// Shares differ by at most one bucket.
+		share := x.nBuckets / uint(len(x.new))
+		for _, nh := range x.new {
+			if c := counts[nh.adj]; c != share && c != share+1 {
+				t.Errorf("%s: next hop %d has %d buckets, want %d or %d", x.name, nh.adj, c, share, share+1)
+			}
+		}
+		if c := counts[x.changedAdj]; c != 0 && x.new.Len() < x.old.Len() {
+			t.Errorf("%s: removed next hop %d still has %d buckets", x.name, x.changedAdj, c)
+		}
+	}
+}
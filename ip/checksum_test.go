@@ -0,0 +1,71 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ip
+
+import (
+	"testing"
+)
+
+// Full checksum of 16 bit words.
+func testChecksum(ws []uint16) (c Checksum) {
+	for _, w := range ws {
+		c = c.AddWithCarry(Checksum(w))
+	}
+	return
+}
+
+// Equal as ones complement numbers: 0 and 0xffff are both zero.
+func checksumEqual(a, b uint16) bool {
+	return a == b || a == 0xffff && b == 0 || a == 0 && b == 0xffff
+}
+
+// Stored checksums are complement of sum; incrementally updating a stored checksum as
+// word i changes by x must match complement of recomputed sum.
+func TestIncrementalChecksum(t *testing.T) {
+	tests := []struct {
+		ws []uint16
+		i  int
+		x  uint16
+	}{
+		{ws: []uint16{0x4500, 0x0030, 0x4422, 0x4000, 0x8006, 0, 0x8c7c, 0x19ac, 0xae24, 0x1e2b}, i: 4, x: 0x100},
+		{ws: []uint16{0xffff, 0xffff, 0x0100}, i: 2, x: 0x100},
+		{ws: []uint16{0x0000, 0x0100}, i: 1, x: 0x100},
+		{ws: []uint16{0x8000, 0x8000, 0x0100}, i: 2, x: 0x100},
+		{ws: []uint16{0x0001, 0xfffe}, i: 0, x: 0x1},
+	}
+	for ti, x := range tests {
+		stored := ^testChecksum(x.ws).Fold()
+
+		ws := append([]uint16{}, x.ws...)
+		ws[x.i] -= x.x
+		if got, want := uint16(Checksum(stored).SubEven(Checksum(x.x)).Fold()), uint16(^testChecksum(ws).Fold()); !checksumEqual(got, want) {
+			t.Errorf("%d: sub got 0x%x want 0x%x", ti, got, want)
+		}
+
+		ws[x.i] += 2 * x.x
+		if got, want := uint16(Checksum(stored).AddEven(Checksum(x.x)).Fold()), uint16(^testChecksum(ws).Fold()); !checksumEqual(got, want) {
+			t.Errorf("%d: add got 0x%x want 0x%x", ti, got, want)
+		}
+	}
+}
+
+func TestFold(t *testing.T) {
+	tests := []struct {
+		c    Checksum
+		want uint16
+	}{
+		{0, 0},
+		{0xffff, 0xffff},
+		{0x10000, 1},
+		{0x1fffe, 0xffff},
+		{0xffffffffffffffff, 0xffff},
+		{0x0001000100010001, 4},
+	}
+	for _, x := range tests {
+		if got := uint16(x.c.Fold()); got != x.want {
+			t.Errorf("fold 0x%x: got 0x%x want 0x%x", uint64(x.c), got, x.want)
+		}
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"github.com/platinasystems/vnet"
 	"github.com/platinasystems/vnet/ip"
 	"github.com/platinasystems/vnet/ip4"
+	"github.com/platinasystems/vnet/ip6"
+	"github.com/platinasystems/vnet/mpls"
 
 	"fmt"
 )
@@ -22,7 +24,7 @@ type Main struct {
 func Init(v *vnet.Vnet) {
 	m := &Main{}
 	packageIndex = v.AddPackage("ip-cli", m)
-	m.DependsOn("ip4", "ip6")
+	m.DependsOn("ethernet", "ip4", "ip6")
 }
 
 func GetMain(v *vnet.Vnet) *Main { return v.GetPackage(packageIndex).(*Main) }
@@ -32,10 +34,14 @@ func (m *Main) ip_route(c cli.Commander, w cli.Writer, in *cli.Input) (err error
 		is_del     bool
 		is_ip6     bool
 		ip4_prefix ip4.Prefix
+		ip6_prefix ip6.Prefix
 		count      uint
 		ip4_nhs    []ip4.NextHop
+		ip6_nhs    []ip6.NextHop
 		adjs       []ip.Adjacency
-		fib_index  ip.FibIndex
+		table_id   ip.FibId
+		has_table  bool
+		out_labels []mpls.Label
 	}
 	var x add_del
 
@@ -46,7 +52,11 @@ func (m *Main) ip_route(c cli.Commander, w cli.Writer, in *cli.Input) (err error
 		x.is_del = true
 	}
 
-	if !in.Parse("%v", &x.ip4_prefix) {
+	switch {
+	case in.Parse("%v", &x.ip4_prefix):
+	case in.Parse("%v", &x.ip6_prefix):
+		x.is_ip6 = true
+	default:
 		err = fmt.Errorf("looking for prefix, got `%s'", in)
 		return
 	}
@@ -56,7 +66,8 @@ loop:
 	for !in.End() {
 		switch {
 		case in.Parse("c%*ount %d", &x.count):
-		case in.Parse("t%*able %d", &x.fib_index):
+		case in.Parse("t%*able %d", &x.table_id):
+			x.has_table = true
 		default:
 			break loop
 		}
@@ -65,10 +76,17 @@ loop:
 	var (
 		adj ip.Adjacency
 		nh4 ip4.NextHop
+		nh6 ip6.NextHop
 	)
 	switch {
-	case in.Parse("via %v", &nh4, m.Vnet):
+	case !x.is_ip6 && in.Parse("via %v", &nh4, m.Vnet):
 		x.ip4_nhs = append(x.ip4_nhs, nh4)
+		var l mpls.Label
+		for in.Parse("out-label %v", &l) {
+			x.out_labels = append(x.out_labels, l)
+		}
+	case x.is_ip6 && in.Parse("via %v", &nh6, m.Vnet):
+		x.ip6_nhs = append(x.ip6_nhs, nh6)
 	case in.Parse("%v", &adj, m.Vnet):
 		x.adjs = append(x.adjs, adj)
 	default:
@@ -76,42 +94,89 @@ loop:
 		return
 	}
 
+	if len(x.out_labels) > 0 {
+		if _, ok := m.Vnet.PackageByName("mpls"); !ok {
+			err = fmt.Errorf("out-label requires mpls")
+			return
+		}
+	}
+
 	m4 := ip4.GetMain(m.Vnet)
+	m6 := ip6.GetMain(m.Vnet)
+	im := &m4.Main
+	fi, ok := m4.FibIndexForTable(x.table_id, !x.is_del)
+	if x.is_ip6 {
+		im = &m6.Main
+		fi, ok = m6.FibIndexForTable(x.table_id, !x.is_del)
+	}
+	if !ok {
+		err = fmt.Errorf("unknown table %d", x.table_id)
+		return
+	}
 	for i := uint(0); i < x.count; i++ {
-		p := x.ip4_prefix.Add(i)
-
-		for i := range x.ip4_nhs {
-			err = m4.AddDelRouteNextHop(&p, &x.ip4_nhs[i], x.is_del)
-			if err != nil {
-				return
+		var pi ip.Prefix
+		if x.is_ip6 {
+			p := x.ip6_prefix.Add(i)
+			pi = p.ToIpPrefix()
+			for i := range x.ip6_nhs {
+				if x.has_table {
+					err = m6.AddDelRouteNextHopTable(x.table_id, &p, &x.ip6_nhs[i], x.is_del)
+				} else {
+					err = m6.AddDelRouteNextHop(&p, &x.ip6_nhs[i], x.is_del)
+				}
+				if err != nil {
+					return
+				}
+			}
+		} else {
+			p := x.ip4_prefix.Add(i)
+			pi = p.ToIpPrefix()
+			for i := range x.ip4_nhs {
+				if len(x.out_labels) > 0 && x.has_table {
+					err = mpls.GetMain(m.Vnet).AddDelIp4RouteTable(x.table_id, &p, &x.ip4_nhs[i], x.out_labels, x.is_del)
+				} else if len(x.out_labels) > 0 {
+					err = mpls.GetMain(m.Vnet).AddDelIp4Route(&p, &x.ip4_nhs[i], x.out_labels, x.is_del)
+				} else if x.has_table {
+					err = m4.AddDelRouteNextHopTable(x.table_id, &p, &x.ip4_nhs[i], x.is_del)
+				} else {
+					err = m4.AddDelRouteNextHop(&p, &x.ip4_nhs[i], x.is_del)
+				}
+				if err != nil {
+					return
+				}
 			}
 		}
 
 		if len(x.adjs) > 0 {
-			pi := p.ToIpPrefix()
 			for i := range x.adjs {
+				if next := x.adjs[i].LookupNextIndex; next.IsSpecial() {
+					if err = im.AddDelSpecialRoute(&pi, fi, next, x.is_del); err != nil {
+						return
+					}
+					continue
+				}
 				var (
 					ai ip.Adj
 					as []ip.Adjacency
 					ok bool
 				)
 				if x.is_del {
-					ai, ok = m4.GetRouteFibIndex(&pi, x.fib_index)
+					ai, ok = im.GetRouteFibIndex(&pi, fi)
 					if !ok {
 						err = fmt.Errorf("%s not found", &pi)
 						return
 					}
 				} else {
-					ai, as = m4.NewAdj(1)
+					ai, as = im.NewAdj(1)
 					as[0] = x.adjs[i]
-					m4.CallAdjAddHooks(ai)
+					im.CallAdjAddHooks(ai)
 				}
-				if _, err = m4.AddDelRoute(&pi, x.fib_index, ai, x.is_del); err != nil {
+				if _, err = im.AddDelRoute(&pi, fi, ai, x.is_del); err != nil {
 					return
 				}
 				if x.is_del {
-					m4.CallAdjDelHooks(ai)
-					m4.DelAdj(ai)
+					im.CallAdjDelHooks(ai)
+					im.DelAdj(ai)
 				}
 			}
 		}
@@ -120,6 +185,76 @@ loop:
 	return
 }
 
+func (m *Main) setFlowHash(c cli.Commander, w cli.Writer, in *cli.Input) (err error) {
+	var (
+		id  ip.FibId
+		cfg ip.FlowHashConfig
+	)
+	in.Parse("t%*able %d", &id)
+	if !in.Parse("%v", &cfg) {
+		err = fmt.Errorf("looking for one or more of src dst sport dport proto, got `%s'", in)
+		return
+	}
+	if !in.End() {
+		err = cli.ParseError
+		return
+	}
+	m4, m6 := ip4.GetMain(m.Vnet), ip6.GetMain(m.Vnet)
+	fi, _ := m4.FibIndexForTable(id, true)
+	m4.SetFlowHashConfig(fi, cfg)
+	fi, _ = m6.FibIndexForTable(id, true)
+	m6.SetFlowHashConfig(fi, cfg)
+	return
+}
+
+func (m *Main) setInterfaceTable(c cli.Commander, w cli.Writer, in *cli.Input) (err error) {
+	var (
+		si vnet.Si
+		id ip.FibId
+	)
+	if !in.Parse("%v", &si, m.Vnet) {
+		err = fmt.Errorf("looking for INTERFACE, got `%s'", in)
+		return
+	}
+	if !in.Parse("%d", &id) {
+		err = fmt.Errorf("looking for TABLE-ID, got `%s'", in)
+		return
+	}
+	if !in.End() {
+		err = cli.ParseError
+		return
+	}
+	if err = ip4.GetMain(m.Vnet).SetInterfaceTable(si, id); err != nil {
+		return
+	}
+	err = ip6.GetMain(m.Vnet).SetInterfaceTable(si, id)
+	return
+}
+
+func (m *Main) setResilientHash(c cli.Commander, w cli.Writer, in *cli.Input) (err error) {
+	var (
+		enable   bool
+		nBuckets uint
+	)
+	switch {
+	case in.Parse("on"):
+		enable = true
+	case in.Parse("off"):
+		enable = false
+	default:
+		err = fmt.Errorf("looking for on or off, got `%s'", in)
+		return
+	}
+	in.Parse("b%*uckets %d", &nBuckets)
+	if !in.End() {
+		err = cli.ParseError
+		return
+	}
+	ip4.GetMain(m.Vnet).SetResilientHash(enable, nBuckets)
+	ip6.GetMain(m.Vnet).SetResilientHash(enable, nBuckets)
+	return
+}
+
 func (m *Main) Init() (err error) {
 	v := m.Vnet
 
@@ -129,6 +264,31 @@ func (m *Main) Init() (err error) {
 			ShortHelp: "add/delete ip4/ip6 routes",
 			Action:    m.ip_route,
 		},
+		cli.Command{
+			Name:      "set ip flow-hash",
+			ShortHelp: "set fields hashed to choose among equal cost paths",
+			Action:    m.setFlowHash,
+		},
+		cli.Command{
+			Name:      "set ip multipath resilient-hash",
+			ShortHelp: "enable/disable resilient hashing for new multipath adjacencies",
+			Action:    m.setResilientHash,
+		},
+		cli.Command{
+			Name:      "set interface ip table",
+			ShortHelp: "move interface into ip4/ip6 route table with given id",
+			Action:    m.setInterfaceTable,
+		},
+		cli.Command{
+			Name:      "set ip neighbor",
+			ShortHelp: "add/delete ip4/ip6 neighbors",
+			Action:    m.setIpNeighbor,
+		},
+		cli.Command{
+			Name:      "show ip neighbors",
+			ShortHelp: "show ip4/ip6 neighbors",
+			Action:    m.showIpNeighbors,
+		},
 	}
 	for i := range cmds {
 		v.CliAdd(&cmds[i])
@@ -0,0 +1,132 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cli
+
+import (
+	"github.com/platinasystems/elib/cli"
+	"github.com/platinasystems/vnet"
+	"github.com/platinasystems/vnet/ethernet"
+	"github.com/platinasystems/vnet/ip"
+	"github.com/platinasystems/vnet/ip4"
+	"github.com/platinasystems/vnet/ip6"
+
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+func (m *Main) setIpNeighbor(c cli.Commander, w cli.Writer, in *cli.Input) (err error) {
+	var (
+		nb    ethernet.IpNeighbor
+		a4    ip4.Address
+		a6    ip6.Address
+		im    *ip.Main
+		isDel bool
+	)
+	isDel = in.Parse("del")
+	if !in.Parse("%v", &nb.Si, m.Vnet) {
+		err = fmt.Errorf("looking for INTERFACE, got `%s'", in)
+		return
+	}
+	switch {
+	case in.Parse("%v", &a4):
+		nb.Ip = a4.ToIp()
+		im = &ip4.GetMain(m.Vnet).Main
+	case in.Parse("%v", &a6):
+		nb.Ip = a6.ToIp()
+		im = &ip6.GetMain(m.Vnet).Main
+	default:
+		err = fmt.Errorf("looking for IP4 or IP6 address, got `%s'", in)
+		return
+	}
+	if !in.Parse("%v", &nb.Ethernet) && !isDel {
+		err = fmt.Errorf("looking for ETHERNET-ADDRESS, got `%s'", in)
+		return
+	}
+	nb.Static = in.Parse("static")
+	if !in.End() {
+		err = cli.ParseError
+		return
+	}
+	em := ethernet.GetMain(m.Vnet)
+	// Dynamic updates leave static neighbors unchanged: refuse rather than silently ignore.
+	if !isDel && !nb.Static {
+		if x, _, ok := em.GetIpNeighbor(im, &nb.Ip, nb.Si); ok && x.Static {
+			err = fmt.Errorf("%s: static neighbor %s exists; delete it first", nb.Si.Name(m.Vnet), im.AddressStringer(&nb.Ip))
+			return
+		}
+	}
+	err = em.AddDelIpNeighbor(im, &nb, isDel)
+	return
+}
+
+type showIpNeighbor struct {
+	im    *ip.Main
+	n     ethernet.IpNeighbor
+	state ethernet.NeighborState
+	age   float64
+	adj   ip.Adj
+}
+
+type showIpNeighbors []showIpNeighbor
+
+func (x showIpNeighbors) Less(i, j int) bool {
+	if x[i].n.Si != x[j].n.Si {
+		return x[i].n.Si < x[j].n.Si
+	}
+	if x[i].im.Family != x[j].im.Family {
+		return x[i].im.Family < x[j].im.Family
+	}
+	return bytes.Compare(x[i].n.Ip[:], x[j].n.Ip[:]) < 0
+}
+
+func (x showIpNeighbors) Swap(i, j int) { x[i], x[j] = x[j], x[i] }
+func (x showIpNeighbors) Len() int      { return len(x) }
+
+func (m *Main) showIpNeighbors(c cli.Commander, w cli.Writer, in *cli.Input) (err error) {
+	var (
+		si      vnet.Si
+		matchSi bool
+	)
+	if !in.End() {
+		if !in.Parse("%v", &si, m.Vnet) {
+			err = fmt.Errorf("looking for INTERFACE, got `%s'", in)
+			return
+		}
+		matchSi = true
+	}
+	if !in.End() {
+		err = cli.ParseError
+		return
+	}
+
+	em := ethernet.GetMain(m.Vnet)
+	ns := showIpNeighbors{}
+	for _, im := range []*ip.Main{&ip4.GetMain(m.Vnet).Main, &ip6.GetMain(m.Vnet).Main} {
+		em.ForeachIpNeighbor(im, func(n *ethernet.IpNeighbor, s ethernet.NeighborState, age float64, ai ip.Adj) {
+			if matchSi && n.Si != si {
+				return
+			}
+			ns = append(ns, showIpNeighbor{im: im, n: *n, state: s, age: age, adj: ai})
+		})
+	}
+	sort.Sort(ns)
+
+	fmt.Fprintf(w, "%30s%20s%20s%10s%12s%10s%10s\n", "Address", "Ethernet", "Interface", "Type", "State", "Age", "Adjacency")
+	for i := range ns {
+		x := &ns[i]
+		t := "dynamic"
+		if x.n.Static {
+			t = "static"
+		}
+		adj := "none"
+		if x.adj != ip.AdjNil {
+			adj = fmt.Sprintf("%d", x.adj)
+		}
+		fmt.Fprintf(w, "%30s%20s%20s%10s%12s%10.1f%10s\n",
+			x.im.AddressStringer(&x.n.Ip), &x.n.Ethernet, x.n.Si.Name(m.Vnet), t, x.state, x.age, adj)
+	}
+	return
+}
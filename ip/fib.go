@@ -5,6 +5,7 @@
 package ip
 
 import (
+	"github.com/platinasystems/elib/dep"
 	"github.com/platinasystems/vnet"
 )
 
@@ -26,6 +27,28 @@ type fibMain struct {
 
 	// Hash table mapping interface route rewrite adjacency index by sw if index.
 	ifRouteAdjBySi map[vnet.Si]FibIndex
+
+	interfaceTableHooks InterfaceTableHookVec
+}
+
+// Hooks are called when interface moves between tables: with isDel before interface leaves its old table
+// and without isDel once it has joined its new one.  Used to move routes not owned by fib
+// (e.g. neighbor adjacencies) along with interface.
+type InterfaceTableHook func(m *Main, si vnet.Si, isDel bool) error
+
+//go:generate gentemplate -id InterfaceTableHook -d Package=ip -d DepsType=InterfaceTableHookVec -d Type=InterfaceTableHook -d Data=interfaceTableHooks github.com/platinasystems/elib/dep/dep.tmpl
+
+func (m *Main) RegisterInterfaceTableHook(f InterfaceTableHook, dep ...*dep.Dep) {
+	m.interfaceTableHooks.Add(f, dep...)
+}
+
+func (m *Main) CallInterfaceTableHooks(si vnet.Si, isDel bool) (err error) {
+	for i := range m.interfaceTableHooks.interfaceTableHooks {
+		if err = m.interfaceTableHooks.Get(i)(m, si, isDel); err != nil {
+			return
+		}
+	}
+	return
 }
 
 func (f *fibMain) fibIndexForSi(si vnet.Si, validate bool) FibIndex {
@@ -40,7 +63,30 @@ func (f *fibMain) FibIndexForSi(si vnet.Si) FibIndex {
 func (f *fibMain) ValidateFibIndexForSi(si vnet.Si) FibIndex {
 	return f.fibIndexForSi(si, true)
 }
-func (f *fibMain) FibIndexForId(id FibId) (i FibIndex, ok bool) { i, ok = f.fibIndexById[id]; return }
+
+// Fib index for packets received on given interface.
+// Interfaces without table assignment use default table.
+func (f *fibMain) LookupFibIndexForSi(si vnet.Si) (i FibIndex) {
+	if uint(si) < f.fibIndexBySi.Len() {
+		i = f.fibIndexBySi[si]
+	}
+	return
+}
+
+// Moves interface to given table.
+func (f *fibMain) SetFibIndexForSi(si vnet.Si, i FibIndex) {
+	f.fibIndexBySi.Validate(uint(si))
+	f.fibIndexBySi[si] = i
+}
+
+// Default table (id 0) always has index 0.
+func (f *fibMain) FibIndexForId(id FibId) (i FibIndex, ok bool) {
+	if id == 0 {
+		return 0, true
+	}
+	i, ok = f.fibIndexById[id]
+	return
+}
 func (f *fibMain) SetFibIndexForId(id FibId, i FibIndex) {
 	if f.fibIndexById == nil {
 		f.fibIndexById = make(map[FibId]FibIndex)
@@ -0,0 +1,134 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ip
+
+import (
+	"github.com/platinasystems/elib/parse"
+)
+
+// Packet fields used to compute flow hash for choosing among equal cost paths.
+type FlowHashConfig uint8
+
+const (
+	FlowHashSrc FlowHashConfig = 1 << iota
+	FlowHashDst
+	FlowHashSrcPort
+	FlowHashDstPort
+	FlowHashProtocol
+
+	FlowHashDefault = FlowHashSrc | FlowHashDst | FlowHashSrcPort | FlowHashDstPort | FlowHashProtocol
+)
+
+var flowHashConfigNames = [...]string{
+	0: "src",
+	1: "dst",
+	2: "sport",
+	3: "dport",
+	4: "proto",
+}
+
+func (c FlowHashConfig) String() (s string) {
+	for i := range flowHashConfigNames {
+		if c&(1<<uint(i)) != 0 {
+			if s != "" {
+				s += " "
+			}
+			s += flowHashConfigNames[i]
+		}
+	}
+	if s == "" {
+		s = "none"
+	}
+	return
+}
+
+// Parses one or more of src dst sport dport proto.
+func (c *FlowHashConfig) Parse(in *parse.Input) {
+	*c = 0
+	for !in.End() {
+		found := false
+		for i := range flowHashConfigNames {
+			if in.Parse(flowHashConfigNames[i]) {
+				*c |= 1 << uint(i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	if *c == 0 {
+		panic(parse.ErrInput)
+	}
+}
+
+// Per-table flow hash configuration; zero means default.
+type flowHashMain struct {
+	flowHashConfigByFibIndex []FlowHashConfig
+}
+
+func (m *flowHashMain) FlowHashConfigForFibIndex(fi FibIndex) (c FlowHashConfig) {
+	if uint(fi) < uint(len(m.flowHashConfigByFibIndex)) {
+		c = m.flowHashConfigByFibIndex[fi]
+	}
+	if c == 0 {
+		c = FlowHashDefault
+	}
+	return
+}
+
+func (m *flowHashMain) SetFlowHashConfig(fi FibIndex, c FlowHashConfig) {
+	if l := uint(fi) + 1; l > uint(len(m.flowHashConfigByFibIndex)) {
+		m.flowHashConfigByFibIndex = append(m.flowHashConfigByFibIndex, make([]FlowHashConfig, l-uint(len(m.flowHashConfigByFibIndex)))...)
+	}
+	m.flowHashConfigByFibIndex[fi] = c
+}
+
+// Fields of a packet's flow.  Ports are zero for protocols without ports.
+type Flow struct {
+	Src, Dst         []byte
+	Protocol         Protocol
+	SrcPort, DstPort uint16
+}
+
+const (
+	fnvOffset = 2166136261
+	fnvPrime  = 16777619
+)
+
+func fnvBytes(h uint32, b []byte) uint32 {
+	for i := range b {
+		h = (h ^ uint32(b[i])) * fnvPrime
+	}
+	return h
+}
+
+func fnvUint16(h uint32, x uint16) uint32 {
+	h = (h ^ uint32(x&0xff)) * fnvPrime
+	return (h ^ uint32(x>>8)) * fnvPrime
+}
+
+// Hash returns hash of flow fields selected by given config.
+func (f *Flow) Hash(c FlowHashConfig) uint32 {
+	h := uint32(fnvOffset)
+	if c&FlowHashSrc != 0 {
+		h = fnvBytes(h, f.Src)
+	}
+	if c&FlowHashDst != 0 {
+		h = fnvBytes(h, f.Dst)
+	}
+	if c&FlowHashProtocol != 0 {
+		h = (h ^ uint32(f.Protocol)) * fnvPrime
+	}
+	if c&FlowHashSrcPort != 0 {
+		h = fnvUint16(h, f.SrcPort)
+	}
+	if c&FlowHashDstPort != 0 {
+		h = fnvUint16(h, f.DstPort)
+	}
+	// Fold high bits into low bits used to index adjacency blocks.
+	return h ^ h>>16
+}
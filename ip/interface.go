@@ -40,7 +40,7 @@ type ifAddressMain struct {
 	ifAddressPool
 
 	// Maps ip4/ip6 address to pool index.
-	addrMap map[Address]IfAddr
+	addrMap map[ifAddrKey]IfAddr
 
 	// Head of doubly-linked list indexed by software interface.
 	headBySwIf IfAddrVec
@@ -55,10 +55,24 @@ func (m *ifAddressMain) init(v *vnet.Vnet) {
 	v.RegisterSwIfAddDelHook(m.swIfAddDel)
 }
 
-func (m *ifAddressMain) GetIfAddress(a []uint8) (ia *IfAddress) {
-	var k Address
-	copy(k[:], a)
-	if i, ok := m.addrMap[k]; ok {
+// Link local addresses are only unique on an interface and so are keyed by interface and address.
+type ifAddrKey struct {
+	si vnet.Si
+	a  Address
+}
+
+func (m *Main) ifAddrKey(si vnet.Si, a *Address) (k ifAddrKey) {
+	k.si, k.a = vnet.SiNil, *a
+	if m.Family == Ip6 && a[0] == 0xfe && a[1]&0xc0 == 0x80 {
+		k.si = si
+	}
+	return
+}
+
+func (m *Main) GetIfAddress(si vnet.Si, a []uint8) (ia *IfAddress) {
+	var x Address
+	copy(x[:], a)
+	if i, ok := m.addrMap[m.ifAddrKey(si, &x)]; ok {
 		ia = &m.ifAddrs[i]
 	}
 	return
@@ -79,14 +93,17 @@ func (m *ifAddressMain) ForeachIfAddress(si vnet.Si, f func(ia IfAddr, i *IfAddr
 	return nil
 }
 
-func (m *Main) IfAddrForPrefix(p *Prefix) (ai IfAddr, exists bool) {
-	ai, exists = m.addrMap[p.Address]
+// IfAddrForPrefix returns interface address with given address; link local addresses are looked up
+// on given interface.
+func (m *Main) IfAddrForPrefix(si vnet.Si, p *Prefix) (ai IfAddr, exists bool) {
+	ai, exists = m.addrMap[m.ifAddrKey(si, &p.Address)]
 	return
 }
 
 func (m *Main) AddDelInterfaceAddress(si vnet.Si, p *Prefix, isDel bool) (ai IfAddr, exists bool, err error) {
 	var a *IfAddress
-	if ai, exists = m.addrMap[p.Address]; exists {
+	k := m.ifAddrKey(si, &p.Address)
+	if ai, exists = m.addrMap[k]; exists {
 		a = m.GetIfAddr(ai)
 	}
 
@@ -107,7 +124,7 @@ func (m *Main) AddDelInterfaceAddress(si vnet.Si, p *Prefix, isDel bool) (ai IfA
 			next.prev = a.prev
 		}
 
-		delete(m.addrMap, p.Address)
+		delete(m.addrMap, k)
 		m.ifAddressPool.PutIndex(uint(ai))
 		ai = IfAddrNil
 	} else if a == nil {
@@ -115,9 +132,9 @@ func (m *Main) AddDelInterfaceAddress(si vnet.Si, p *Prefix, isDel bool) (ai IfA
 		a = m.GetIfAddr(ai)
 
 		if m.addrMap == nil {
-			m.addrMap = make(map[Address]IfAddr)
+			m.addrMap = make(map[ifAddrKey]IfAddr)
 		}
-		m.addrMap[p.Address] = ai
+		m.addrMap[k] = ai
 		a.Prefix = *p
 		a.Si = si
 		a.NeighborProbeAdj = AdjNil
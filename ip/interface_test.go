@@ -0,0 +1,40 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ip
+
+import (
+	"github.com/platinasystems/vnet"
+
+	"testing"
+)
+
+// Same link local address on two interfaces must have distinct keys; other addresses have a single key.
+func TestIfAddrKey(t *testing.T) {
+	linkLocal := Address{0: 0xfe, 1: 0x80, 15: 1}
+	global := Address{0: 0x20, 1: 0x01, 15: 1}
+	// Ip4 address which looks like ip6 link local (254.128.0.1).
+	ip4 := Address{0: 0xfe, 1: 0x80, 3: 1}
+	tests := []struct {
+		name     string
+		family   Family
+		a        Address
+		distinct bool
+	}{
+		{name: "ip6 link local", family: Ip6, a: linkLocal, distinct: true},
+		{name: "ip6 global", family: Ip6, a: global},
+		{name: "ip4", family: Ip4, a: ip4},
+	}
+	for _, x := range tests {
+		m := &Main{}
+		m.Family = x.family
+		k1, k2 := m.ifAddrKey(vnet.Si(1), &x.a), m.ifAddrKey(vnet.Si(2), &x.a)
+		if got := k1 != k2; got != x.distinct {
+			t.Errorf("%s: keys distinct %v, want %v", x.name, got, x.distinct)
+		}
+		if k1.a != x.a {
+			t.Errorf("%s: key address %v, want %v", x.name, k1.a, x.a)
+		}
+	}
+}
@@ -6,6 +6,8 @@ package ip
 
 import (
 	"github.com/platinasystems/vnet"
+
+	"fmt"
 )
 
 type AddressStringer func(a *Address) string
@@ -18,14 +20,31 @@ type FamilyConfig struct {
 	GetRoute         func(p *Prefix, si vnet.Si) (ai Adj, ok bool)
 	GetRouteFibIndex func(p *Prefix, fi FibIndex) (ai Adj, ok bool)
 	AddDelRoute      func(p *Prefix, fi FibIndex, newAdj Adj, isDel bool) (oldAdj Adj, err error)
+	// Optional: returns fib of given interface for link local addresses.
+	LinkLocalFibIndex func(si vnet.Si, a *Address) (fi FibIndex, ok bool)
 }
 
 type Main struct {
 	v *vnet.Vnet
 	FamilyConfig
 	fibMain
+	flowHashMain
 	adjacencyMain
 	ifAddressMain
+	neighborSolicitor NeighborSolicitor
+}
+
+// Starts resolution of neighbor with given address on given interface (e.g. sends arp request).
+type NeighborSolicitor func(m *Main, a *Address, si vnet.Si)
+
+// RegisterNeighborSolicitor sets function used to resolve neighbors; registered by arp/neighbor discovery.
+func (m *Main) RegisterNeighborSolicitor(f NeighborSolicitor) { m.neighborSolicitor = f }
+
+// SolicitNeighbor starts resolution of given neighbor address if a solicitor has been registered.
+func (m *Main) SolicitNeighbor(a *Address, si vnet.Si) {
+	if m.neighborSolicitor != nil {
+		m.neighborSolicitor(m, a, si)
+	}
 }
 
 func (m *Main) Init(v *vnet.Vnet, c FamilyConfig) {
@@ -34,3 +53,69 @@ func (m *Main) Init(v *vnet.Vnet, c FamilyConfig) {
 	m.adjacencyInit()
 	m.ifAddressMain.init(v)
 }
+
+// FibIndexForSiAddress returns fib for routes to given address (e.g. neighbor) on given interface.
+// Link local addresses are only unique on an interface and so have a fib per interface.
+func (m *Main) FibIndexForSiAddress(si vnet.Si, a *Address) FibIndex {
+	if m.LinkLocalFibIndex != nil {
+		if fi, ok := m.LinkLocalFibIndex(si, a); ok {
+			return fi
+		}
+	}
+	return m.FibIndexForSi(si)
+}
+
+// AddDelSpecialRoute adds/deletes route with an adjacency of its own which does not forward
+// (e.g. drop for blackhole routes; unreachable or prohibit for reject routes).
+// Special adjacency of route being replaced or deleted is freed; other routes are refused on delete
+// since their adjacencies may be shared.
+func (m *Main) AddDelSpecialRoute(p *Prefix, fi FibIndex, next LookupNext, isDel bool) (err error) {
+	var (
+		ai, oldAdj Adj
+		as         []Adjacency
+		ok         bool
+	)
+	if isDel {
+		if ai, ok = m.GetRouteFibIndex(p, fi); !ok {
+			err = fmt.Errorf("%s not found", m.AddressStringer(&p.Address))
+			return
+		}
+		if !m.isSpecialAdj(ai) {
+			err = fmt.Errorf("%s/%d: not a special route", m.AddressStringer(&p.Address), p.Len)
+			return
+		}
+	} else {
+		ai, as = m.NewAdj(1)
+		as[0].LookupNextIndex = next
+		as[0].IfAddr = IfAddrNil
+		m.CallAdjAddHooks(ai)
+	}
+	if oldAdj, err = m.AddDelRoute(p, fi, ai, isDel); err != nil {
+		if !isDel {
+			m.freeSpecialAdj(ai)
+		}
+		return
+	}
+	if isDel {
+		m.freeSpecialAdj(ai)
+	} else if oldAdj != AdjNil && oldAdj != ai && m.isSpecialAdj(oldAdj) {
+		m.freeSpecialAdj(oldAdj)
+	}
+	return
+}
+
+// IsSpecial returns whether routes with given next are special routes (see AddDelSpecialRoute).
+func (n LookupNext) IsSpecial() bool {
+	switch n {
+	case LookupNextDrop, LookupNextUnreachable, LookupNextProhibit:
+		return true
+	}
+	return false
+}
+
+func (m *Main) isSpecialAdj(ai Adj) bool { return m.GetAdjacency(ai).LookupNextIndex.IsSpecial() }
+
+func (m *Main) freeSpecialAdj(ai Adj) {
+	m.CallAdjDelHooks(ai)
+	m.DelAdj(ai)
+}
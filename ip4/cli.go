@@ -14,7 +14,7 @@ import (
 )
 
 type showIpFibRoute struct {
-	table  ip.FibIndex
+	table  ip.FibId
 	prefix Prefix
 	adj    ip.Adj
 }
@@ -33,10 +33,16 @@ func (x showIpFibRoutes) Len() int      { return len(x) }
 
 func (m *Main) showIpFib(c cli.Commander, w cli.Writer, in *cli.Input) (err error) {
 
-	detail := false
-	summary := false
+	var (
+		table      ip.FibId
+		detail     bool
+		summary    bool
+		matchTable bool
+	)
 	for !in.End() {
 		switch {
+		case in.Parse("t%*able %d", &table):
+			matchTable = true
 		case in.Parse("d%*etail"):
 			detail = true
 		case in.Parse("s%*ummary"):
@@ -48,10 +54,15 @@ func (m *Main) showIpFib(c cli.Commander, w cli.Writer, in *cli.Input) (err erro
 	}
 
 	if summary {
-		fmt.Fprintf(w, "%6s%12s\n", "Table", "Routes")
-		for fi := range m.fibs {
-			fib := m.fibs[fi]
-			fmt.Fprintf(w, "%6d%12d\n", fi, fib.Len())
+		if on, nBuckets := m.ResilientHash(); on {
+			fmt.Fprintf(w, "Resilient hash: %d buckets\n", nBuckets)
+		}
+		fmt.Fprintf(w, "%6s%12s%30s\n", "Table", "Routes", "Flow hash")
+		for fi, fib := range m.fibs {
+			if fib == nil || (matchTable && fib.id != table) {
+				continue
+			}
+			fmt.Fprintf(w, "%6d%12d%30s\n", fib.id, fib.Len(), m.FlowHashConfigForFibIndex(ip.FibIndex(fi)))
 		}
 		return
 	}
@@ -60,10 +71,12 @@ func (m *Main) showIpFib(c cli.Commander, w cli.Writer, in *cli.Input) (err erro
 	m.CallAdjSyncCounterHooks()
 
 	rs := []showIpFibRoute{}
-	for fi := range m.fibs {
-		fib := m.fibs[fi]
+	for _, fib := range m.fibs {
+		if fib == nil || (matchTable && fib.id != table) {
+			continue
+		}
 		fib.foreach(func(p *Prefix, a ip.Adj) {
-			rs = append(rs, showIpFibRoute{table: ip.FibIndex(fi), prefix: *p, adj: a})
+			rs = append(rs, showIpFibRoute{table: fib.id, prefix: *p, adj: a})
 		})
 	}
 	sort.Sort(showIpFibRoutes(rs))
@@ -76,6 +89,10 @@ func (m *Main) showIpFib(c cli.Commander, w cli.Writer, in *cli.Input) (err erro
 		for ai := range adjs {
 			initialSpace := "  "
 			line := fmt.Sprintf("%s%d: ", initialSpace, int(r.adj)+ai)
+			// Multipath adjacencies are chosen by flow hash modulo block size.
+			if len(adjs) > 1 {
+				line = fmt.Sprintf("%sbucket %d %d: ", initialSpace, ai, int(r.adj)+ai)
+			}
 			ss := adjs[ai].String(&m.Main)
 
 			m.Main.ForeachAdjCounter(r.adj+ip.Adj(ai), func(tag string, v vnet.CombinedCounter) {
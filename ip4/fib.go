@@ -147,11 +147,17 @@ func (m *mapFib) foreach(fn func(p *Prefix, a ip.Adj)) {
 type Fib struct {
 	index ip.FibIndex
 
+	// Route table id of this fib.
+	id ip.FibId
+
 	// Hash (Go map) fib for general accounting and to maintain mtrie (e.g. setLessSpecific).
 	mapFib
 
 	// Mtrie for fast lookups.
 	mtrie
+
+	// Routes with next hops resolved through covering routes.
+	recursiveFib
 }
 
 // Total number of routes in FIB.
@@ -277,7 +283,27 @@ func (f *Fib) Get(p *Prefix) (a ip.Adj, ok bool) {
 func (f *Fib) Add(m *Main, p *Prefix, r ip.Adj) (ip.Adj, bool) { return f.addDel(m, p, r, true) }
 func (f *Fib) Del(m *Main, p *Prefix) (ip.Adj, bool)           { return f.addDel(m, p, ip.AdjMiss, false) }
 func (f *Fib) Lookup(a *Address) (r ip.Adj) {
+	// Empty table: nothing ever added.
+	if len(f.mtrie.plys) == 0 {
+		return ip.AdjMiss
+	}
 	r = f.mtrie.lookup(a)
+	// Default route is not painted into mtrie.
+	if r == ip.AdjMiss {
+		r = f.mtrie.defaultLeaf.ResultIndex()
+	}
+	return
+}
+
+// Lookup destination address in table for packets received on given interface.
+func (m *Main) Lookup(si vnet.Si, a *Address) (r ip.Adj) {
+	r = ip.AdjMiss
+	fi := m.LookupFibIndexForSi(si)
+	if uint(fi) < m.fibs.Len() {
+		if f := m.fibs[fi]; f != nil {
+			r = f.Lookup(a)
+		}
+	}
 	return
 }
 
@@ -287,12 +313,12 @@ func (m *Main) setInterfaceAdjacency(a *ip.Adjacency, si vnet.Si, ia ip.IfAddr)
 	h := m.Vnet.HwIfer(hw.Hi())
 
 	next := ip.LookupNextRewrite
-	noder := &m.rewriteNode
+	var noder vnet.Noder = &m.rewriteNode
 	packetType := vnet.IP4
 
-	if _, ok := h.(vnet.Arper); ok {
+	if _, ok := h.(vnet.Arper); ok && m.gleanNode != nil {
 		next = ip.LookupNextGlean
-		noder = &m.arpNode
+		noder = m.gleanNode
 		packetType = vnet.ARP
 		a.IfAddr = ia
 	}
@@ -301,6 +327,20 @@ func (m *Main) setInterfaceAdjacency(a *ip.Adjacency, si vnet.Si, ia ip.IfAddr)
 	m.Vnet.SetRewrite(&a.Rewrite, si, noder, packetType, nil /* dstAdr meaning broadcast */)
 }
 
+// RewriteInterfaceAdjacencies re-makes rewrites of interface's glean and interface route adjacencies
+// (e.g. after ethernet address of interface has changed).
+func (m *Main) RewriteInterfaceAdjacencies(si vnet.Si) {
+	m.ForeachIfAddress(si, func(ia ip.IfAddr, ifa *ip.IfAddress) (err error) {
+		if ifa.NeighborProbeAdj != ip.AdjNil {
+			m.setInterfaceAdjacency(&m.GetAdj(ifa.NeighborProbeAdj)[0], si, ia)
+		}
+		return
+	})
+	if ai, ok := m.ifRouteAdjIndexBySi[si]; ok {
+		m.setInterfaceAdjacency(&m.GetAdj(ai)[0], si, ip.IfAddrNil)
+	}
+}
+
 type fibMain struct {
 	fibs FibVec
 	// Hooks to call on set/unset.
@@ -323,15 +363,62 @@ func (m *Main) fibByIndex(i ip.FibIndex, create bool) (f *Fib) {
 	return
 }
 
-func (m *Main) fibById(id ip.FibId, create bool) *Fib {
-	var (
-		i  ip.FibIndex
-		ok bool
-	)
-	if i, ok = m.FibIndexForId(id); !ok {
+func (m *Main) fibById(id ip.FibId, create bool) (f *Fib) {
+	i, ok := m.FibIndexForId(id)
+	if !ok {
+		if !create {
+			return
+		}
+		// Index 0 is reserved for default table.
+		m.fibByIndex(0, true)
 		i = ip.FibIndex(m.fibs.Len())
+		m.SetFibIndexForId(id, i)
+	}
+	if f = m.fibByIndex(i, create); f != nil {
+		f.id = id
+	}
+	return
+}
+
+// FibIndexForTable returns index of fib for route table with given id; creating fib if requested.
+func (m *Main) FibIndexForTable(id ip.FibId, create bool) (fi ip.FibIndex, ok bool) {
+	if f := m.fibById(id, create); f != nil {
+		fi, ok = f.index, true
+	}
+	return
+}
+
+// SetInterfaceTable moves interface into route table with given id.
+// Routes for interface's addresses and neighbors (via interface table hooks) are moved from old table to new one.
+func (m *Main) SetInterfaceTable(si vnet.Si, id ip.FibId) (err error) {
+	fi, _ := m.FibIndexForTable(id, true)
+	oldFi := m.ValidateFibIndexForSi(si)
+	if fi == oldFi {
+		return
+	}
+	if err = m.CallInterfaceTableHooks(si, true); err != nil {
+		return
+	}
+	isUp := m.Vnet.SwIf(si).IsAdminUp()
+	if isUp {
+		err = m.ForeachIfAddress(si, func(ia ip.IfAddr, ifa *ip.IfAddress) error {
+			return m.addDelInterfaceRoutes(ia, true)
+		})
+		if err != nil {
+			return
+		}
 	}
-	return m.fibByIndex(i, create)
+	m.SetFibIndexForSi(si, fi)
+	if isUp {
+		err = m.ForeachIfAddress(si, func(ia ip.IfAddr, ifa *ip.IfAddress) error {
+			return m.addDelInterfaceRoutes(ia, false)
+		})
+		if err != nil {
+			return
+		}
+	}
+	err = m.CallInterfaceTableHooks(si, false)
+	return
 }
 
 func (m *Main) fibBySi(si vnet.Si) *Fib { return m.fibs[m.FibIndexForSi(si)] }
@@ -383,8 +470,20 @@ func (x *NextHop) ParseWithArgs(in *parse.Input, args *parse.Args) {
 }
 
 func (m *Main) AddDelRouteNextHop(p *Prefix, nh *NextHop, isDel bool) (err error) {
-	f := m.fibBySi(nh.Si)
+	return m.addDelRouteNextHop(m.fibBySi(nh.Si), p, nh, isDel)
+}
 
+// As above but route is added/deleted in route table with given id instead of table of next hop interface.
+func (m *Main) AddDelRouteNextHopTable(id ip.FibId, p *Prefix, nh *NextHop, isDel bool) (err error) {
+	f := m.fibById(id, !isDel)
+	if f == nil {
+		err = fmt.Errorf("unknown table %d", id)
+		return
+	}
+	return m.addDelRouteNextHop(f, p, nh, isDel)
+}
+
+func (m *Main) addDelRouteNextHop(f *Fib, p *Prefix, nh *NextHop, isDel bool) (err error) {
 	var (
 		nhAdj, oldAdj, newAdj ip.Adj
 		adjs                  []ip.Adjacency
@@ -407,11 +506,9 @@ func (m *Main) AddDelRouteNextHop(p *Prefix, nh *NextHop, isDel bool) (err error
 			}
 			m.ifRouteAdjIndexBySi[nh.Si] = nhAdj
 		}
-	} else {
-		if nhAdj, ok = f.Get(&Prefix{Address: nh.Address, Len: 32}); !ok {
-			err = fmt.Errorf("next-hop %s/32 not found in fib", &nh.Address)
-			return
-		}
+	} else if nhAdj, ok = f.Get(&Prefix{Address: nh.Address, Len: 32}); !ok || f.isRecursiveNextHop(p, nh) {
+		// Next hop is not a neighbor: resolve it through covering route.
+		return m.addDelRecursiveNextHop(f, p, nh, isDel)
 	}
 
 	oldAdj, ok = f.Get(p)
@@ -444,7 +541,7 @@ func (f *Fib) deleteMatchingRoutes(m *Main, key *Prefix) {
 	f.maybeRemapAdjacencies(m)
 }
 
-func (m *Main) addDelInterfaceRoutes(ia ip.IfAddr, isDel bool) {
+func (m *Main) addDelInterfaceRoutes(ia ip.IfAddr, isDel bool) (err error) {
 	ifa := m.GetIfAddr(ia)
 	si := ifa.Si
 	sw := m.Vnet.SwIf(si)
@@ -462,7 +559,9 @@ func (m *Main) addDelInterfaceRoutes(ia ip.IfAddr, isDel bool) {
 			m.CallAdjAddHooks(ai)
 			addDelAdj = ai
 		}
-		fib.addDel(m, &p, addDelAdj, isDel)
+		if _, ok := fib.addDel(m, &p, addDelAdj, isDel); !ok && isDel {
+			return fmt.Errorf("%s: interface route %s not found", si.Name(m.Vnet), &p)
+		}
 		ifa.NeighborProbeAdj = addDelAdj
 	}
 
@@ -479,12 +578,15 @@ func (m *Main) addDelInterfaceRoutes(ia ip.IfAddr, isDel bool) {
 			addDelAdj = ai
 		}
 		p.Len = 32
-		fib.addDel(m, &p, addDelAdj, isDel)
+		if _, ok := fib.addDel(m, &p, addDelAdj, isDel); !ok && isDel {
+			return fmt.Errorf("%s: local route %s not found", si.Name(m.Vnet), &p)
+		}
 	}
 
 	if isDel {
 		fib.deleteMatchingRoutes(m, &p)
 	}
+	return
 }
 
 func (m *Main) AddDelInterfaceAddress(si vnet.Si, addr *Prefix, isDel bool) (err error) {
@@ -512,10 +614,12 @@ func (m *Main) AddDelInterfaceAddress(si vnet.Si, addr *Prefix, isDel bool) (err
 
 	// If interface is admin up, delete interface routes *before* removing address.
 	if isUp && isDel {
-		ia, exists = m.Main.IfAddrForPrefix(&pa)
+		ia, exists = m.Main.IfAddrForPrefix(si, &pa)
 		// For non-existing prefixes error will be signalled by AddDelInterfaceAddress below.
 		if exists {
-			m.addDelInterfaceRoutes(ia, isDel)
+			if err = m.addDelInterfaceRoutes(ia, isDel); err != nil {
+				return
+			}
 		}
 	}
 
@@ -526,7 +630,9 @@ func (m *Main) AddDelInterfaceAddress(si vnet.Si, addr *Prefix, isDel bool) (err
 
 	// If interface is up remove interface routes.
 	if isUp && !isDel {
-		m.addDelInterfaceRoutes(ia, isDel)
+		if err = m.addDelInterfaceRoutes(ia, isDel); err != nil {
+			return
+		}
 	}
 
 	// Do callbacks when new address is created or old one is deleted.
@@ -541,10 +647,9 @@ func (m *Main) AddDelInterfaceAddress(si vnet.Si, addr *Prefix, isDel bool) (err
 
 func (m *Main) swIfAdminUpDown(v *vnet.Vnet, si vnet.Si, isUp bool) (err error) {
 	m.validateDefaultFibForSi(si)
-	m.ForeachIfAddress(si, func(ia ip.IfAddr, ifa *ip.IfAddress) (err error) {
+	err = m.ForeachIfAddress(si, func(ia ip.IfAddr, ifa *ip.IfAddress) error {
 		isDel := !isUp
-		m.addDelInterfaceRoutes(ia, isDel)
-		return
+		return m.addDelInterfaceRoutes(ia, isDel)
 	})
 	return
 }
@@ -0,0 +1,188 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ip4
+
+import (
+	"github.com/platinasystems/vnet"
+	"github.com/platinasystems/vnet/ip"
+)
+
+// Max number of fragments made from a single packet.
+const maxFragments = 64
+
+func (h *Header) fragmentOffset() uint { return 8 * uint(h.GetHeaderFlags()&fragmentOffsetMask) }
+
+func (h *Header) setFragment(offset uint, moreFragments bool) {
+	f := h.GetHeaderFlags() &^ (MoreFragments | fragmentOffsetMask)
+	f |= HeaderFlags(offset / 8)
+	if moreFragments {
+		f |= MoreFragments
+	}
+	h.Flags_and_fragment_offset = f.FromHost()
+}
+
+// Set checksum of header which may have options.
+func (h *Header) setChecksum() {
+	h.Checksum = 0
+	h.Checksum = h.checksumWithOptions(h.HeaderLen())
+}
+
+// Copy bytes starting at given offset of (possibly chained) packet.
+func copyFromChain(dst []byte, r *vnet.Ref, offset uint) {
+	s := *r
+	for len(dst) > 0 {
+		b := s.DataSlice()
+		if offset < uint(len(b)) {
+			dst = dst[copy(dst, b[offset:]):]
+			offset = 0
+		} else {
+			offset -= uint(len(b))
+		}
+		h := s.NextRef()
+		if h == nil {
+			break
+		}
+		s.RefHeader = *h
+	}
+}
+
+// Ip options whose type has copied flag set are copied into all fragments; others only into first fragment.
+const optionCopied = 1 << 7
+
+// Max header length with options.
+const maxHeaderBytes = 60
+
+// Writes header for non-first fragment given header with options of packet being fragmented.
+// Options without copied flag are removed (RFC 791).  Returns length of written header.
+func nonFirstFragmentHeader(dst, src []byte) (hl uint) {
+	hl = uint(copy(dst, src[:HeaderBytes]))
+	for i := uint(HeaderBytes); i < uint(len(src)); {
+		t := src[i]
+		// End of option list.
+		if t == 0 {
+			break
+		}
+		// No-operation: single byte and not copied.
+		if t == 1 {
+			i++
+			continue
+		}
+		if i+1 >= uint(len(src)) {
+			break
+		}
+		l := uint(src[i+1])
+		if l < 2 || i+l > uint(len(src)) {
+			break
+		}
+		if t&optionCopied != 0 {
+			hl += uint(copy(dst[hl:], src[i:i+l]))
+		}
+		i += l
+	}
+	// Pad with end of option list to multiple of 4 bytes.
+	for hl%4 != 0 {
+		dst[hl] = 0
+		hl++
+	}
+	dst[0] = 0x40 | byte(hl/4)
+	return
+}
+
+// Splits packet into fragments each fitting in output interface mtu and a single buffer.
+// Fragments are rewritten and added to output; original packet is freed.
+// At most room refs may be added to output so that vector length is not exceeded.
+// Returns number of refs added.
+func (n *rewriteNode) fragment(r *vnet.Ref, in *vnet.RefIn, o *vnet.RefOut, room uint) (nOut uint) {
+	m := n.m
+	h := GetHeader(r)
+	a := m.GetAdjacency(ip.GetRefAdj(r))
+	rw := &a.Rewrite
+	pool := in.BufferPool
+
+	hl := h.HeaderLen()
+	l := uint(h.Length.ToHost())
+	maxData := uint(rw.MaxL3PacketSize)
+	if maxData > pool.Size {
+		maxData = pool.Size
+	}
+	if maxData < hl+8 {
+		maxData = 0
+	} else {
+		maxData = (maxData - hl) &^ 7
+	}
+
+	nFrags := uint(0)
+	if maxData > 0 {
+		nFrags = (l - hl + maxData - 1) / maxData
+	}
+	if nFrags == 0 || nFrags > maxFragments || nFrags > room {
+		n.SetError(r, rewrite_error_fragment_drop)
+		o.Outs[rewrite_next_drop].BufferPool = pool
+		no := o.Outs[rewrite_next_drop].AddLen(n.Vnet)
+		o.Outs[rewrite_next_drop].Refs[no] = *r
+		return 1
+	}
+
+	h.DecrementTtl()
+	offset, moreFragments := h.fragmentOffset(), h.GetHeaderFlags()&MoreFragments != 0
+	var hdr [maxHeaderBytes]byte
+	copyFromChain(hdr[:hl], r, 0)
+	frags := vnet.RefVec(n.frags[:nFrags])
+	pool.AllocRefs(frags)
+	for i := range frags {
+		f := &frags[i]
+		fo := uint(i) * maxData
+		dl := l - hl - fo
+		if dl > maxData {
+			dl = maxData
+		}
+		f.SetDataLen(hl + dl)
+		b := f.DataSlice()
+		fhl := hl
+		if i > 0 && hl > HeaderBytes {
+			fhl = nonFirstFragmentHeader(b, hdr[:hl])
+			f.SetDataLen(fhl + dl)
+			b = f.DataSlice()
+		} else {
+			copy(b, hdr[:hl])
+		}
+		copyFromChain(b[fhl:], r, hl+fo)
+
+		fh := GetHeader(f)
+		fh.Length = vnet.Uint16(fhl + dl).FromHost()
+		fh.setFragment(offset+fo, moreFragments || uint(i)+1 < nFrags)
+		fh.setChecksum()
+
+		vnet.PerformRewrite(f, rw)
+		f.Si = rw.Si
+		x := uint(rw.NextIndex)
+		o.Outs[x].BufferPool = pool
+		no := o.Outs[x].AddLen(n.Vnet)
+		o.Outs[x].Refs[no] = *f
+	}
+	pool.FreeRefs(r, 1, true)
+	n.CountError(rewrite_error_fragments_sent, nFrags)
+	return nFrags
+}
+
+// Next for packets which need fragmentation; not an actual next node.
+const rewrite_next_fragment = ^uint(0)
+
+func (n *rewriteNode) NodeInput(in *vnet.RefIn, o *vnet.RefOut) {
+	nOut := uint(0)
+	for i := uint(0); i < in.Len(); i++ {
+		r := &in.Refs[i]
+		x := n.rewriteNext(r)
+		if x == rewrite_next_fragment {
+			// Leave room for remaining packets.
+			nOut += n.fragment(r, in, o, vnet.MaxVectorLen-nOut-(in.Len()-i-1))
+			continue
+		}
+		o.Outs[x].BufferPool = in.BufferPool
+		no := o.Outs[x].AddLen(n.Vnet)
+		o.Outs[x].Refs[no] = *r
+		nOut++
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ip4
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNonFirstFragmentHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []byte
+		want    []byte
+	}{
+		{"no copied options", []byte{7, 7, 4, 0, 0, 0, 0, 1}, nil},
+		// Security (130) is copied; record route (7) and nops are not.
+		{"copied option kept", []byte{1, 130, 4, 0xaa, 0xbb, 7, 3, 4}, []byte{130, 4, 0xaa, 0xbb}},
+		{"padded", []byte{130, 3, 0xaa, 7, 3, 4, 0, 0}, []byte{130, 3, 0xaa, 0}},
+		{"end of list", []byte{0, 130, 4, 0xaa, 0xbb, 0, 0, 0}, nil},
+		{"bad length", []byte{130, 1, 0, 0}, nil},
+	}
+	for _, x := range tests {
+		src := make([]byte, HeaderBytes, HeaderBytes+len(x.options))
+		src[0] = 0x40 | byte((HeaderBytes+len(x.options))/4)
+		src[8] = 64
+		src = append(src, x.options...)
+		var dst [maxHeaderBytes]byte
+		hl := nonFirstFragmentHeader(dst[:], src)
+		if got, want := hl, uint(HeaderBytes+len(x.want)); got != want {
+			t.Errorf("%s: header length %d, want %d", x.name, got, want)
+			continue
+		}
+		if got, want := dst[0], byte(0x40|hl/4); got != want {
+			t.Errorf("%s: version and header length %#x, want %#x", x.name, got, want)
+		}
+		if !bytes.Equal(dst[1:HeaderBytes], src[1:HeaderBytes]) {
+			t.Errorf("%s: fixed header not copied", x.name)
+		}
+		if got := dst[HeaderBytes:hl]; len(x.want) > 0 && !bytes.Equal(got, x.want) {
+			t.Errorf("%s: options % x, want % x", x.name, got, x.want)
+		}
+	}
+}
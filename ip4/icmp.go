@@ -0,0 +1,392 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ip4
+
+import (
+	"github.com/platinasystems/elib"
+	"github.com/platinasystems/elib/cpu"
+	"github.com/platinasystems/vnet"
+	"github.com/platinasystems/vnet/ip"
+
+	"unsafe"
+)
+
+type IcmpType uint8
+
+const (
+	IcmpEchoReply              IcmpType = 0
+	IcmpDestinationUnreachable IcmpType = 3
+	IcmpEchoRequest            IcmpType = 8
+	IcmpTimeExceeded           IcmpType = 11
+)
+
+var icmpTypeStrings = [...]string{
+	IcmpEchoReply:              "echo-reply",
+	IcmpDestinationUnreachable: "destination-unreachable",
+	IcmpEchoRequest:            "echo-request",
+	IcmpTimeExceeded:           "time-exceeded",
+}
+
+func (x IcmpType) String() string { return elib.StringerWithFormat(icmpTypeStrings[:], int(x), "%d") }
+
+// Destination unreachable codes.
+const (
+	IcmpNetUnreachable      = 0
+	IcmpHostUnreachable     = 1
+	IcmpFragmentationNeeded = 4
+	IcmpAdminProhibited     = 13
+)
+
+type IcmpHeader struct {
+	Type     IcmpType
+	Code     uint8
+	Checksum vnet.Uint16
+	// Identifier and sequence number for echo; next hop mtu in low 16 bits for
+	// fragmentation needed; unused otherwise.
+	Data vnet.Uint32
+}
+
+const IcmpHeaderBytes = 8
+
+func GetIcmpHeader(r *vnet.Ref, h *Header) *IcmpHeader {
+	return (*IcmpHeader)(elib.PointerAdd(r.Data(), uintptr(h.HeaderLen())))
+}
+
+// Internet checksum of n bytes starting at p.
+func icmpChecksum(p unsafe.Pointer, n uint) vnet.Uint16 {
+	c := ip.Checksum(0)
+	i := uint(0)
+	for ; i+2 <= n; i += 2 {
+		c = c.AddWithCarry(ip.Checksum(*(*uint16)(elib.PointerAdd(p, uintptr(i)))))
+	}
+	if i < n {
+		var b [2]uint8
+		b[0] = *(*uint8)(elib.PointerAdd(p, uintptr(i)))
+		c = c.AddWithCarry(ip.Checksum(*(*uint16)(unsafe.Pointer(&b[0]))))
+	}
+	return ^c.Fold()
+}
+
+// As above but for n bytes starting at offset of possibly chained packet (e.g. reassembled from fragments).
+func icmpChainChecksum(r *vnet.Ref, offset, n uint) vnet.Uint16 {
+	if r.NextValidFlag() == 0 {
+		return icmpChecksum(elib.PointerAdd(r.Data(), uintptr(offset)), n)
+	}
+	var (
+		c     ip.Checksum
+		odd   [2]uint8
+		isOdd bool
+	)
+	s := *r
+	for n > 0 {
+		b := s.DataSlice()
+		if offset < uint(len(b)) {
+			b, offset = b[offset:], 0
+		} else {
+			b, offset = nil, offset-uint(len(b))
+		}
+		if uint(len(b)) > n {
+			b = b[:n]
+		}
+		n -= uint(len(b))
+		i := 0
+		// Odd byte at end of previous buffer pairs with first byte of this one.
+		if isOdd && len(b) > 0 {
+			odd[1], isOdd, i = b[0], false, 1
+			c = c.AddWithCarry(ip.Checksum(*(*uint16)(unsafe.Pointer(&odd[0]))))
+		}
+		for ; i+2 <= len(b); i += 2 {
+			c = c.AddWithCarry(ip.Checksum(*(*uint16)(unsafe.Pointer(&b[i]))))
+		}
+		if i < len(b) {
+			odd[0], isOdd = b[i], true
+		}
+		h := s.NextRef()
+		if h == nil {
+			break
+		}
+		s.RefHeader = *h
+	}
+	if isOdd {
+		odd[1] = 0
+		c = c.AddWithCarry(ip.Checksum(*(*uint16)(unsafe.Pointer(&odd[0]))))
+	}
+	return ^c.Fold()
+}
+
+const fragmentOffsetMask HeaderFlags = 1<<13 - 1
+
+func (h *Header) isFragment() bool {
+	return h.GetHeaderFlags()&(MoreFragments|fragmentOffsetMask) != 0
+}
+
+func (a *Address) isMulticastOrBroadcast() bool {
+	return a[0] >= 224 || a.AsUint32() == 0xffffffff
+}
+
+// Time to live for packets sent by us.
+const defaultTtl = 64
+
+type icmpNodeMain struct {
+	localNode localNode
+	errorNode errorNode
+}
+
+func (m *Main) icmpInit(v *vnet.Vnet) {
+	l := &m.localNode
+	l.m = m
+	l.Next = []string{
+		local_next_drop:       "error",
+		local_next_punt:       "punt",
+		local_next_reply:      "ip4-input-valid-checksum",
+		local_next_reassemble: "ip4-reassemble",
+	}
+	l.Errors = []string{
+		local_error_bad_checksum: "bad icmp checksum",
+		local_error_echo_replies: "echo replies sent",
+	}
+	v.RegisterInOutNode(l, "ip4-local")
+
+	e := &m.errorNode
+	e.m = m
+	e.Next = []string{
+		error_next_drop: "error",
+		error_next_send: "ip4-input-valid-checksum",
+	}
+	e.Errors = []string{
+		error_error_not_sent:                 "icmp error not sent",
+		error_error_rate_limited:             "icmp error rate limited",
+		error_error_no_address:               "no interface address for icmp error",
+		error_error_destination_unreachables: "destination unreachables sent",
+		error_error_time_exceededs:           "time exceededs sent",
+		error_error_fragmentation_neededs:    "fragmentation neededs sent",
+	}
+	v.RegisterInOutNode(e, "ip4-icmp-error")
+}
+
+const (
+	local_next_drop = iota
+	local_next_punt
+	local_next_reply
+	local_next_reassemble
+)
+
+const (
+	local_error_none = iota
+	local_error_bad_checksum
+	local_error_echo_replies
+)
+
+// Node receiving packets for local adjacencies.
+// Fragments are reassembled; icmp echo requests (including reassembled ones) are answered; all other packets are punted.
+type localNode struct {
+	vnet.InOutNode
+	m *Main
+}
+
+func (n *localNode) localNext(r *vnet.Ref) (next uint) {
+	h := GetHeader(r)
+	if h.isFragment() {
+		return local_next_reassemble
+	}
+	l := uint(h.Length.ToHost())
+	isEcho := h.Protocol == ip.ICMP && h.Ip_version_and_header_length == 0x45 &&
+		l >= HeaderBytes+IcmpHeaderBytes && r.DataLen() >= HeaderBytes+IcmpHeaderBytes &&
+		GetIcmpHeader(r, h).Type == IcmpEchoRequest
+	if !isEcho {
+		n.Vnet.RestoreL2Header(r)
+		return local_next_punt
+	}
+
+	ih := GetIcmpHeader(r, h)
+	icmpLen := l - HeaderBytes
+	if icmpChainChecksum(r, HeaderBytes, icmpLen) != 0 {
+		n.SetError(r, local_error_bad_checksum)
+		return local_next_drop
+	}
+
+	// Turn request into reply in place and send it back through ip4-input for forwarding.
+	ih.Type = IcmpEchoReply
+	ih.Checksum = 0
+	ih.Checksum = icmpChainChecksum(r, HeaderBytes, icmpLen)
+	h.Src, h.Dst = h.Dst, h.Src
+	h.Ttl = defaultTtl
+	h.Checksum = h.ComputeChecksum()
+	// Reassembled packets have exact length; others may have padding.
+	if r.NextValidFlag() == 0 {
+		r.SetDataLen(l)
+	}
+	n.CountError(local_error_echo_replies, 1)
+	return local_next_reply
+}
+
+func (n *localNode) NodeInput(in *vnet.RefIn, o *vnet.RefOut) {
+	for i := uint(0); i < in.Len(); i++ {
+		r := &in.Refs[i]
+		x := n.localNext(r)
+		o.Outs[x].BufferPool = in.BufferPool
+		no := o.Outs[x].AddLen(n.Vnet)
+		o.Outs[x].Refs[no] = *r
+	}
+}
+
+const (
+	error_next_drop = iota
+	error_next_send
+)
+
+const (
+	error_error_none = iota
+	error_error_not_sent
+	error_error_rate_limited
+	error_error_no_address
+	error_error_destination_unreachables
+	error_error_time_exceededs
+	error_error_fragmentation_neededs
+)
+
+// Max number of icmp errors sent per second.
+const icmpErrorsPerSecond = 100
+
+// Node sending icmp errors for packets which can not be forwarded.
+// Packet is replaced by icmp error quoting its header and first 8 bytes of payload.
+// Error is determined by looking up packet again (as in ip4-rewrite).
+type errorNode struct {
+	vnet.InOutNode
+	m *Main
+
+	// Number of errors sent since rate time.
+	rateTime  cpu.Time
+	rateCount uint
+}
+
+func (n *errorNode) isRateLimited() bool {
+	now := cpu.TimeNow()
+	if n.Vnet.TimeDiff(now, n.rateTime) > 1 {
+		n.rateTime = now
+		n.rateCount = 0
+	}
+	n.rateCount++
+	return n.rateCount > icmpErrorsPerSecond
+}
+
+// No errors are sent for non-initial fragments, multicast or broadcast packets or icmp errors (RFC 1812 section 4.3.2.7).
+func (n *errorNode) isErrorAllowed(r *vnet.Ref, h *Header) bool {
+	if h.GetHeaderFlags()&fragmentOffsetMask != 0 || h.Src.IsZero() || h.Src.isMulticastOrBroadcast() || h.Dst.isMulticastOrBroadcast() {
+		return false
+	}
+	if h.Protocol == ip.ICMP {
+		l := uint(h.Length.ToHost())
+		if l < h.HeaderLen()+IcmpHeaderBytes {
+			return false
+		}
+		t := GetIcmpHeader(r, h).Type
+		return t == IcmpEchoRequest || t == IcmpEchoReply
+	}
+	return true
+}
+
+// Source address for errors: first address of receiving interface.
+func (n *errorNode) sourceAddress(si vnet.Si) (src Address, ok bool) {
+	n.m.ForeachIfAddress(si, func(_ ip.IfAddr, ia *ip.IfAddress) (err error) {
+		if !ok {
+			copy(src[:], ia.Prefix.Address[:AddressBytes])
+			ok = true
+		}
+		return
+	})
+	return
+}
+
+func (n *errorNode) errorNext(r *vnet.Ref, p *vnet.BufferPool) (next uint) {
+	next = error_next_drop
+	m := n.m
+	h := GetHeader(r)
+
+	var (
+		t    IcmpType
+		code uint8
+		mtu  uint
+		e    uint
+	)
+	// Adjacency found by ip4-input.
+	a := m.GetAdjacency(ip.GetRefAdj(r))
+	switch {
+	case a.LookupNextIndex == ip.LookupNextMiss:
+		t, code, e = IcmpDestinationUnreachable, IcmpNetUnreachable, error_error_destination_unreachables
+	case a.LookupNextIndex == ip.LookupNextUnreachable:
+		t, code, e = IcmpDestinationUnreachable, IcmpHostUnreachable, error_error_destination_unreachables
+	case a.LookupNextIndex == ip.LookupNextProhibit:
+		t, code, e = IcmpDestinationUnreachable, IcmpAdminProhibited, error_error_destination_unreachables
+	case a.LookupNextIndex == ip.LookupNextDrop:
+		n.SetError(r, error_error_not_sent)
+		return
+	case h.Ttl <= 1:
+		t, code, e = IcmpTimeExceeded, 0, error_error_time_exceededs
+	case a.LookupNextIndex == ip.LookupNextRewrite && uint(h.Length.ToHost()) > uint(a.Rewrite.MaxL3PacketSize):
+		t, code, e = IcmpDestinationUnreachable, IcmpFragmentationNeeded, error_error_fragmentation_neededs
+		mtu = uint(a.Rewrite.MaxL3PacketSize)
+	default:
+		n.SetError(r, error_error_not_sent)
+		return
+	}
+
+	if !n.isErrorAllowed(r, h) {
+		n.SetError(r, error_error_not_sent)
+		return
+	}
+	src, ok := n.sourceAddress(r.Si)
+	if !ok {
+		n.SetError(r, error_error_no_address)
+		return
+	}
+	if n.isRateLimited() {
+		n.SetError(r, error_error_rate_limited)
+		return
+	}
+
+	// Error replaces packet so tail buffers of chained packets are freed.
+	p.Unchain(r)
+	h = GetHeader(r)
+
+	// Quote header and first 8 bytes of payload following new ip and icmp headers.
+	dst := h.Src
+	q := h.HeaderLen() + 8
+	if l := uint(h.Length.ToHost()); q > l {
+		q = l
+	}
+	const o = HeaderBytes + IcmpHeaderBytes
+	r.SetDataLen(o + q)
+	b := r.DataSlice()
+	copy(b[o:], b[:q])
+
+	ih := (*IcmpHeader)(elib.PointerAdd(r.Data(), HeaderBytes))
+	*ih = IcmpHeader{Type: t, Code: code}
+	ih.Data = vnet.Uint32(mtu).FromHost()
+	ih.Checksum = icmpChecksum(unsafe.Pointer(ih), IcmpHeaderBytes+q)
+
+	*h = Header{
+		Ip_version_and_header_length: 0x45,
+		Length:                       vnet.Uint16(o + q).FromHost(),
+		Ttl:                          defaultTtl,
+		Protocol:                     ip.ICMP,
+		Src:                          src,
+		Dst:                          dst,
+	}
+	h.Checksum = h.ComputeChecksum()
+
+	n.CountError(e, 1)
+	return error_next_send
+}
+
+func (n *errorNode) NodeInput(in *vnet.RefIn, o *vnet.RefOut) {
+	for i := uint(0); i < in.Len(); i++ {
+		r := &in.Refs[i]
+		x := n.errorNext(r, in.BufferPool)
+		o.Outs[x].BufferPool = in.BufferPool
+		no := o.Outs[x].AddLen(n.Vnet)
+		o.Outs[x].Refs[no] = *r
+	}
+}
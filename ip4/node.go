@@ -5,43 +5,247 @@
 package ip4
 
 import (
+	"github.com/platinasystems/elib/loop"
 	"github.com/platinasystems/vnet"
+	"github.com/platinasystems/vnet/ip"
 )
 
 func GetHeader(r *vnet.Ref) *Header { return (*Header)(r.Data()) }
 
 type nodeMain struct {
 	inputNode              inputNode
-	inputValidChecksumNode inputValidChecksumNode
-	rewriteNode            inputNode
-	arpNode                inputNode
+	inputValidChecksumNode inputNode
+	rewriteNode            rewriteNode
+	icmpNodeMain
+	reassembleNode reassembleNode
+
+	// Node to receive glean adjacency packets; registered by arp package.
+	gleanNode vnet.Noder
+}
+
+// RegisterGleanNode sets node used to resolve addresses for glean adjacencies.
+func (m *Main) RegisterGleanNode(n vnet.Noder, nodeName string) {
+	m.gleanNode = n
+	m.inputNode.gleanNodeName = nodeName
+	m.inputValidChecksumNode.gleanNodeName = nodeName
 }
 
 func (m *Main) nodeInit(v *vnet.Vnet) {
+	m.inputNode.m = m
 	m.inputNode.Next = []string{
-		input_next_drop: "error",
-		input_next_punt: "punt",
+		input_next_drop:       "error",
+		input_next_punt:       "punt",
+		input_next_rewrite:    "ip4-rewrite",
+		input_next_local:      "ip4-local",
+		input_next_icmp_error: "ip4-icmp-error",
 	}
+	m.inputNode.Errors = []string{
+		input_error_version:       "version not 4",
+		input_error_header_length: "header length < 20 bytes or exceeds buffer",
+		input_error_length:        "length does not match buffer",
+		input_error_checksum:      "bad header checksum",
+		input_error_ttl_expired:   "ttl expired",
+		input_error_miss:          "no matching route",
+		input_error_drop:          "drop adjacency",
+		input_error_reject:        "reject adjacency",
+		input_error_no_glean_node: "no arp",
+	}
+	m.inputValidChecksumNode = m.inputNode
+	m.inputValidChecksumNode.checksumIsValid = true
 	v.RegisterInOutNode(&m.inputNode, "ip4-input")
-	m.inputValidChecksumNode.Next = m.inputNode.Next
 	v.RegisterInOutNode(&m.inputValidChecksumNode, "ip4-input-valid-checksum")
-	v.RegisterInOutNode(&m.arpNode, "ip4-arp")
+
+	m.rewriteNode.m = m
+	// Output interface nexts are added by vnet.SetRewrite.
+	m.rewriteNode.Next = []string{
+		rewrite_next_drop:       "error",
+		rewrite_next_icmp_error: "ip4-icmp-error",
+	}
+	m.rewriteNode.Errors = []string{
+		rewrite_error_not_rewrite:    "adjacency is not a rewrite",
+		rewrite_error_mtu_exceeded:   "mtu exceeded",
+		rewrite_error_fragmented:     "fragmented",
+		rewrite_error_fragments_sent: "fragments sent",
+		rewrite_error_fragment_drop:  "fragmentation failed",
+	}
 	v.RegisterInOutNode(&m.rewriteNode, "ip4-rewrite")
+
+	m.icmpInit(v)
+	m.reassembleInit(v)
 }
 
 const (
 	input_next_drop = iota
 	input_next_punt
+	input_next_rewrite
+	input_next_local
+	input_next_icmp_error
+)
+
+const (
+	input_error_none = iota
+	input_error_version
+	input_error_header_length
+	input_error_length
+	input_error_checksum
+	input_error_ttl_expired
+	input_error_miss
+	input_error_drop
+	input_error_reject
+	input_error_no_glean_node
+)
+
+// Next node for each adjacency lookup next.
+var inputNextForLookupNext = [...]uint{
+	ip.LookupNextMiss:        input_next_icmp_error,
+	ip.LookupNextDrop:        input_next_drop,
+	ip.LookupNextPunt:        input_next_punt,
+	ip.LookupNextLocal:       input_next_local,
+	ip.LookupNextGlean:       input_next_rewrite, // replaced by glean next resolved in LoopInit
+	ip.LookupNextRewrite:     input_next_rewrite,
+	ip.LookupNextUnreachable: input_next_icmp_error,
+	ip.LookupNextProhibit:    input_next_icmp_error,
+}
+
+type inputNode struct {
+	vnet.InOutNode
+	m *Main
+	// Set for packets whose checksum has been verified by hardware.
+	checksumIsValid bool
+
+	gleanNodeName string
+	gleanNext     uint
+}
+
+// Resolve glean node name after all packages have registered their nodes.
+func (n *inputNode) LoopInit(l *loop.Loop) {
+	if n.gleanNodeName != "" {
+		if next, err := l.AddNamedNext(n, n.gleanNodeName); err == nil {
+			n.gleanNext = next
+		}
+	}
+}
+
+func (n *inputNode) validate(r *vnet.Ref, h *Header) (e uint) {
+	e = input_error_none
+	if r.DataLen() < HeaderBytes {
+		return input_error_length
+	}
+	// Fast path: version 4 with no options.
+	if h.Ip_version_and_header_length != 0x45 {
+		if h.Ip_version_and_header_length>>4 != 4 {
+			return input_error_version
+		}
+		if hl := h.HeaderLen(); hl < HeaderBytes || hl > r.DataLen() {
+			return input_error_header_length
+		}
+	}
+	l := uint(h.Length.ToHost())
+	if l < h.HeaderLen() || (r.NextValidFlag() == 0 && l > r.DataLen()) {
+		return input_error_length
+	}
+	if !n.checksumIsValid && !h.IsChecksumValid() {
+		return input_error_checksum
+	}
+	return
+}
+
+func (n *inputNode) inputNext(r *vnet.Ref) (next uint) {
+	m := n.m
+	h := GetHeader(r)
+	if e := n.validate(r, h); e != input_error_none {
+		n.SetError(r, e)
+		return input_next_drop
+	}
+
+	ai := m.LookupFlow(r.Si, h)
+	a := m.GetAdjacency(ai)
+	ip.SetRefAdj(r, ai)
+	next = inputNextForLookupNext[a.LookupNextIndex]
+	switch a.LookupNextIndex {
+	case ip.LookupNextMiss:
+		n.CountError(input_error_miss, 1)
+	case ip.LookupNextDrop:
+		// Blackhole: drop silently.
+		n.SetError(r, input_error_drop)
+	case ip.LookupNextUnreachable, ip.LookupNextProhibit:
+		n.CountError(input_error_reject, 1)
+	case ip.LookupNextGlean, ip.LookupNextRewrite:
+		// Packets to be forwarded must have ttl left to decrement.
+		if h.Ttl <= 1 {
+			n.CountError(input_error_ttl_expired, 1)
+			return input_next_icmp_error
+		}
+		if a.LookupNextIndex == ip.LookupNextGlean {
+			if next = n.gleanNext; next == 0 {
+				n.SetError(r, input_error_no_glean_node)
+				next = input_next_drop
+			}
+		}
+	case ip.LookupNextPunt:
+		n.Vnet.RestoreL2Header(r)
+	}
+	return
+}
+
+func (n *inputNode) NodeInput(in *vnet.RefIn, o *vnet.RefOut) {
+	for i := uint(0); i < in.Len(); i++ {
+		r := &in.Refs[i]
+		x := n.inputNext(r)
+		o.Outs[x].BufferPool = in.BufferPool
+		no := o.Outs[x].AddLen(n.Vnet)
+		o.Outs[x].Refs[no] = *r
+	}
+}
+
+const (
+	rewrite_next_drop = iota
+	rewrite_next_icmp_error
 )
 
-type inputNode struct{ vnet.InOutNode }
+const (
+	rewrite_error_none = iota
+	rewrite_error_not_rewrite
+	rewrite_error_mtu_exceeded
+	rewrite_error_fragmented
+	rewrite_error_fragments_sent
+	rewrite_error_fragment_drop
+)
 
-func (node *inputNode) NodeInput(in *vnet.RefIn, out *vnet.RefOut) {
-	node.Redirect(in, out, input_next_punt)
+type rewriteNode struct {
+	vnet.InOutNode
+	m *Main
+	// Fragments being made from a single packet.
+	frags [maxFragments]vnet.Ref
 }
 
-type inputValidChecksumNode struct{ vnet.InOutNode }
+func (n *rewriteNode) rewriteNext(r *vnet.Ref) (next uint) {
+	m := n.m
+	h := GetHeader(r)
+
+	ai := ip.GetRefAdj(r)
+	a := m.GetAdjacency(ai)
+	if a.LookupNextIndex != ip.LookupNextRewrite {
+		n.SetError(r, rewrite_error_not_rewrite)
+		return rewrite_next_drop
+	}
+	// Keeps neighbor of adjacency from aging out.
+	m.SetAdjUsed(ai)
+	rw := &a.Rewrite
+
+	// Packets which are too large for output interface get icmp fragmentation needed
+	// when they may not be fragmented; others are fragmented.
+	if mtu := uint(rw.MaxL3PacketSize); mtu != 0 && uint(h.Length.ToHost()) > mtu {
+		if h.GetHeaderFlags()&DontFragment != 0 {
+			n.CountError(rewrite_error_mtu_exceeded, 1)
+			return rewrite_next_icmp_error
+		}
+		n.CountError(rewrite_error_fragmented, 1)
+		return rewrite_next_fragment
+	}
 
-func (node *inputValidChecksumNode) NodeInput(in *vnet.RefIn, out *vnet.RefOut) {
-	node.Redirect(in, out, input_next_punt)
+	h.DecrementTtl()
+	vnet.PerformRewrite(r, rw)
+	r.Si = rw.Si
+	return uint(rw.NextIndex)
 }
@@ -33,6 +33,7 @@ type Main struct {
 func (m *Main) Init() (err error) {
 	v := m.Vnet
 	v.RegisterSwIfAdminUpDownHook(m.swIfAdminUpDown)
+	m.RegisterFibAddDelHook(m.recursiveFibAddDel)
 	cf := ip.FamilyConfig{
 		Family:           ip.Ip4,
 		AddressStringer:  ipAddressStringer,
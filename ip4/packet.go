@@ -5,6 +5,7 @@
 package ip4
 
 import (
+	"github.com/platinasystems/elib"
 	"github.com/platinasystems/vnet"
 	"github.com/platinasystems/vnet/ip"
 
@@ -100,6 +101,36 @@ func (h *Header) checksum() vnet.Uint16 {
 	return ^c.Fold()
 }
 
+// Checksum of header with options; header length given in bytes (multiple of 4).
+func (h *Header) checksumWithOptions(n uint) vnet.Uint16 {
+	c := ip.Checksum(0)
+	p := unsafe.Pointer(h)
+	for i := uintptr(0); i < uintptr(n); i += 4 {
+		c = c.AddWithCarry(ip.Checksum(*(*uint32)(elib.PointerAdd(p, i))))
+	}
+	return ^c.Fold()
+}
+
+// Number of bytes in header including options.
+func (h *Header) HeaderLen() uint { return 4 * uint(h.Ip_version_and_header_length&0xf) }
+
+// True if header (including options) has a valid checksum.
+func (h *Header) IsChecksumValid() bool {
+	if h.Ip_version_and_header_length == 0x45 {
+		return h.checksum() == 0
+	}
+	return h.checksumWithOptions(h.HeaderLen()) == 0
+}
+
+// Decrement ttl and incrementally update header checksum to match.
+func (h *Header) DecrementTtl() {
+	h.Ttl--
+	// Ttl is the high byte of the 16 bit word at an even header offset;
+	// decrementing it decreases word by 0x100 (network byte order).
+	c := ip.Checksum(h.Checksum).SubEven(ip.Checksum(vnet.Uint16(0x100).FromHost()))
+	h.Checksum = c.Fold()
+}
+
 func (h *Header) ComputeChecksum() vnet.Uint16 {
 	var tmp Header = *h
 	tmp.Checksum = 0
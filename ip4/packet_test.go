@@ -0,0 +1,52 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ip4
+
+import (
+	"github.com/platinasystems/vnet/ip"
+
+	"testing"
+)
+
+func testHeader(ttl uint8, id uint16) (h Header) {
+	h = Header{
+		Ip_version_and_header_length: 0x45,
+		Ttl:                          ttl,
+		Protocol:                     ip.UDP,
+		Src:                          Address{10, 0, 0, 1},
+		Dst:                          Address{192, 168, 1, 2},
+	}
+	h.Length.Set(HeaderBytes + 8)
+	h.Fragment_id.Set(uint(id))
+	h.Checksum = h.ComputeChecksum()
+	return
+}
+
+func TestDecrementTtl(t *testing.T) {
+	for _, ttl := range []uint8{1, 2, 64, 128, 255} {
+		// Sweep fragment id so that checksums take all values including 0 and 0xffff.
+		for id := 0; id < 1<<16; id++ {
+			h := testHeader(ttl, uint16(id))
+			h.DecrementTtl()
+			if h.Ttl != ttl-1 {
+				t.Fatalf("ttl %d id %d: got ttl %d", ttl, id, h.Ttl)
+			}
+			if !h.IsChecksumValid() {
+				t.Fatalf("ttl %d id %d: bad checksum 0x%x want 0x%x", ttl, id, h.Checksum.ToHost(), h.ComputeChecksum().ToHost())
+			}
+		}
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	h := testHeader(64, 0x1234)
+	if !h.IsChecksumValid() {
+		t.Errorf("computed checksum not valid")
+	}
+	h.Tos ^= 1
+	if h.IsChecksumValid() {
+		t.Errorf("checksum valid after header change")
+	}
+}
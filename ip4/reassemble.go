@@ -0,0 +1,249 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ip4
+
+import (
+	"github.com/platinasystems/elib/cpu"
+	"github.com/platinasystems/vnet"
+	"github.com/platinasystems/vnet/ip"
+
+	"fmt"
+	"sort"
+)
+
+// Limits on reassembly: number of packets being reassembled and fragments per packet.
+const (
+	maxReassemblies          = 256
+	maxReassemblyFragments   = 64
+	reassemblyTimeout        = 5 // seconds
+	reassemblyTimerInterval  = 1 // seconds
+	maxReassembledPacketSize = 1<<16 - 1
+)
+
+type reassemblyKey struct {
+	Src, Dst Address
+	Id       vnet.Uint16
+	Protocol ip.Protocol
+}
+
+type reassemblyFragment struct {
+	ref vnet.Ref
+	// Payload byte offset and length.
+	offset, len uint
+}
+
+type reassemblyFragments []reassemblyFragment
+
+func (x reassemblyFragments) Less(i, j int) bool { return x[i].offset < x[j].offset }
+func (x reassemblyFragments) Swap(i, j int)      { x[i], x[j] = x[j], x[i] }
+func (x reassemblyFragments) Len() int           { return len(x) }
+
+type reassembly struct {
+	// Time first fragment was received.
+	time  cpu.Time
+	pool  *vnet.BufferPool
+	frags reassemblyFragments
+	// Payload length once last fragment has been received; zero otherwise.
+	payloadLen uint
+}
+
+func (r *reassembly) free() {
+	for i := range r.frags {
+		r.pool.FreeRefs(&r.frags[i].ref, 1, true)
+	}
+	r.frags = r.frags[:0]
+}
+
+func (r *reassembly) overlaps(offset, n uint) bool {
+	for i := range r.frags {
+		f := &r.frags[i]
+		if offset < f.offset+f.len && f.offset < offset+n {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *reassembly) isComplete() bool {
+	if r.payloadLen == 0 {
+		return false
+	}
+	o := uint(0)
+	for i := range r.frags {
+		if r.frags[i].offset != o {
+			return false
+		}
+		o += r.frags[i].len
+	}
+	return o == r.payloadLen
+}
+
+const (
+	reassemble_next_drop = iota
+	reassemble_next_local
+)
+
+const (
+	reassemble_error_none = iota
+	reassemble_error_bad_fragment
+	reassemble_error_overlap
+	reassemble_error_too_many_fragments
+	reassemble_error_table_full
+	reassemble_error_timeout
+	reassemble_error_reassembled
+)
+
+// Node reassembling fragmented packets addressed to us.
+// Fragments are held until packet is complete; reassembled packet is a chain
+// of fragment buffers with first fragment's header and is sent back to ip4-local.
+type reassembleNode struct {
+	vnet.InOutNode
+	m            *Main
+	reassemblies map[reassemblyKey]*reassembly
+	timer        reassemblyTimer
+}
+
+func (m *Main) reassembleInit(v *vnet.Vnet) {
+	n := &m.reassembleNode
+	n.m = m
+	n.timer.n = n
+	n.Next = []string{
+		reassemble_next_drop:  "error",
+		reassemble_next_local: "ip4-local",
+	}
+	n.Errors = []string{
+		reassemble_error_bad_fragment:       "bad fragment",
+		reassemble_error_overlap:            "overlapping fragment",
+		reassemble_error_too_many_fragments: "too many fragments",
+		reassemble_error_table_full:         "reassembly table full",
+		reassemble_error_timeout:            "reassembly timeout",
+		reassemble_error_reassembled:        "packets reassembled",
+	}
+	v.RegisterInOutNode(n, "ip4-reassemble")
+}
+
+func (n *reassembleNode) drop(k *reassemblyKey, a *reassembly, e uint) {
+	n.CountError(e, uint(len(a.frags)))
+	a.free()
+	delete(n.reassemblies, *k)
+}
+
+// Adds fragment to reassembly.  Returns true with reassembled packet in r when packet is complete.
+// Fragment is kept by reassembly unless next is set to drop.
+func (n *reassembleNode) add(r *vnet.Ref, pool *vnet.BufferPool) (next uint, done bool) {
+	next = reassemble_next_drop
+	h := GetHeader(r)
+	hl := h.HeaderLen()
+	l := uint(h.Length.ToHost())
+	offset := h.fragmentOffset()
+	isLast := h.GetHeaderFlags()&MoreFragments == 0
+	plen := l - hl
+
+	// Only single buffer fragments are accepted; all but last fragment must be a multiple of 8 bytes.
+	if r.NextValidFlag() != 0 || plen == 0 || (!isLast && plen%8 != 0) || hl+offset+plen > maxReassembledPacketSize {
+		n.SetError(r, reassemble_error_bad_fragment)
+		return
+	}
+
+	k := reassemblyKey{Src: h.Src, Dst: h.Dst, Id: h.Fragment_id, Protocol: h.Protocol}
+	a, ok := n.reassemblies[k]
+	if !ok {
+		if len(n.reassemblies) >= maxReassemblies {
+			n.SetError(r, reassemble_error_table_full)
+			return
+		}
+		if n.reassemblies == nil {
+			n.reassemblies = make(map[reassemblyKey]*reassembly)
+		}
+		a = &reassembly{time: cpu.TimeNow(), pool: pool}
+		n.reassemblies[k] = a
+		n.startTimer()
+	}
+
+	if a.overlaps(offset, plen) || (isLast && a.payloadLen != 0) {
+		n.SetError(r, reassemble_error_overlap)
+		n.drop(&k, a, reassemble_error_overlap)
+		return
+	}
+	if a.frags.Len() >= maxReassemblyFragments {
+		n.SetError(r, reassemble_error_too_many_fragments)
+		n.drop(&k, a, reassemble_error_too_many_fragments)
+		return
+	}
+	if isLast {
+		a.payloadLen = offset + plen
+	}
+
+	// Trim fragment to payload; first fragment keeps its header.
+	if offset == 0 {
+		r.SetDataLen(hl + plen)
+	} else {
+		r.Advance(int(hl))
+		r.SetDataLen(plen)
+	}
+	a.frags = append(a.frags, reassemblyFragment{ref: *r, offset: offset, len: plen})
+	sort.Sort(a.frags)
+	if !a.isComplete() {
+		return
+	}
+
+	var c vnet.RefChain
+	for i := range a.frags {
+		c.Append(&a.frags[i].ref)
+	}
+	*r = c.Done()
+	h = GetHeader(r)
+	h.Length = vnet.Uint16(h.HeaderLen() + a.payloadLen).FromHost()
+	h.setFragment(0, false)
+	h.setChecksum()
+	a.frags = a.frags[:0]
+	delete(n.reassemblies, k)
+	n.CountError(reassemble_error_reassembled, 1)
+	next, done = reassemble_next_local, true
+	return
+}
+
+func (n *reassembleNode) NodeInput(in *vnet.RefIn, o *vnet.RefOut) {
+	for i := uint(0); i < in.Len(); i++ {
+		r := &in.Refs[i]
+		x, done := n.add(r, in.BufferPool)
+		if x == reassemble_next_drop || done {
+			o.Outs[x].BufferPool = in.BufferPool
+			no := o.Outs[x].AddLen(n.Vnet)
+			o.Outs[x].Refs[no] = *r
+		}
+	}
+}
+
+// Timer event to free reassemblies which have not completed in time.
+type reassemblyTimer struct {
+	vnet.Event
+	n       *reassembleNode
+	running bool
+}
+
+func (e *reassemblyTimer) String() string {
+	return fmt.Sprintf("ip4 reassembly timer %d packets", len(e.n.reassemblies))
+}
+
+func (n *reassembleNode) startTimer() {
+	if !n.timer.running {
+		n.timer.running = true
+		n.AddTimedEvent(&n.timer, reassemblyTimerInterval)
+	}
+}
+
+func (e *reassemblyTimer) EventAction() {
+	n := e.n
+	now := cpu.TimeNow()
+	for k, a := range n.reassemblies {
+		if n.Vnet.TimeDiff(now, a.time) > reassemblyTimeout {
+			n.drop(&k, a, reassemble_error_timeout)
+		}
+	}
+	if e.running = len(n.reassemblies) > 0; e.running {
+		e.AddTimedEvent(e, reassemblyTimerInterval)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ip6
+
+import (
+	"github.com/platinasystems/elib/cli"
+	"github.com/platinasystems/vnet"
+	"github.com/platinasystems/vnet/ip"
+
+	"fmt"
+	"sort"
+)
+
+type showIpFibRoute struct {
+	table  ip.FibId
+	prefix Prefix
+	adj    ip.Adj
+}
+
+type showIpFibRoutes []showIpFibRoute
+
+func (x showIpFibRoutes) Less(i, j int) bool {
+	if cmp := int(x[i].table) - int(x[j].table); cmp != 0 {
+		return cmp < 0
+	}
+	return x[i].prefix.LessThan(&x[j].prefix)
+}
+
+func (x showIpFibRoutes) Swap(i, j int) { x[i], x[j] = x[j], x[i] }
+func (x showIpFibRoutes) Len() int      { return len(x) }
+
+func (m *Main) showIpFib(c cli.Commander, w cli.Writer, in *cli.Input) (err error) {
+
+	var (
+		table       ip.FibId
+		key         Prefix
+		detail      bool
+		summary     bool
+		matchTable  bool
+		matchPrefix bool
+	)
+	for !in.End() {
+		switch {
+		case in.Parse("t%*able %d", &table):
+			matchTable = true
+		case in.Parse("d%*etail"):
+			detail = true
+		case in.Parse("s%*ummary"):
+			summary = true
+		case in.Parse("%v", &key):
+			matchPrefix = true
+		default:
+			err = cli.ParseError
+			return
+		}
+	}
+
+	if summary {
+		if on, nBuckets := m.ResilientHash(); on {
+			fmt.Fprintf(w, "Resilient hash: %d buckets\n", nBuckets)
+		}
+		fmt.Fprintf(w, "%6s%12s%30s\n", "Table", "Routes", "Flow hash")
+		for fi, fib := range m.fibs {
+			if fib == nil || (matchTable && fib.id != table) {
+				continue
+			}
+			fmt.Fprintf(w, "%6d%12d%30s\n", fib.id, fib.Len(), m.FlowHashConfigForFibIndex(ip.FibIndex(fi)))
+		}
+		return
+	}
+
+	// Sync adjacency stats with hardware.
+	m.CallAdjSyncCounterHooks()
+
+	rs := []showIpFibRoute{}
+	for _, fib := range m.fibs {
+		if fib == nil || (matchTable && fib.id != table) {
+			continue
+		}
+		fib.foreach(func(p *Prefix, a ip.Adj) {
+			// Prefix given shows it and all more specific routes.
+			if matchPrefix && (p.Len < key.Len || !p.Address.MatchesPrefix(&key)) {
+				return
+			}
+			rs = append(rs, showIpFibRoute{table: fib.id, prefix: *p, adj: a})
+		})
+	}
+	sort.Sort(showIpFibRoutes(rs))
+
+	fmt.Fprintf(w, "%6s%40s%20s\n", "Table", "Destination", "Adjacency")
+	for ri := range rs {
+		r := &rs[ri]
+		lines := []string{}
+		adjs := m.GetAdj(r.adj)
+		for ai := range adjs {
+			initialSpace := "  "
+			line := fmt.Sprintf("%s%d: ", initialSpace, int(r.adj)+ai)
+			// Multipath adjacencies are chosen by flow hash modulo block size.
+			if len(adjs) > 1 {
+				line = fmt.Sprintf("%sbucket %d %d: ", initialSpace, ai, int(r.adj)+ai)
+			}
+			ss := adjs[ai].String(&m.Main)
+
+			m.Main.ForeachAdjCounter(r.adj+ip.Adj(ai), func(tag string, v vnet.CombinedCounter) {
+				if v.Packets != 0 || detail {
+					ss = append(ss, fmt.Sprintf("%s%spackets %16d", initialSpace, tag, v.Packets))
+					ss = append(ss, fmt.Sprintf("%s%sbytes   %16d", initialSpace, tag, v.Bytes))
+				}
+			})
+
+			for _, s := range ss {
+				lines = append(lines, line+s)
+				line = initialSpace
+			}
+		}
+		for i := range lines {
+			if i == 0 {
+				fmt.Fprintf(w, "%6d%40s%s\n", r.table, &r.prefix, lines[i])
+			} else {
+				fmt.Fprintf(w, "%6s%40s%s\n", "", "", lines[i])
+			}
+		}
+	}
+
+	return
+}
+
+func (m *Main) cliInit(v *vnet.Vnet) {
+	cmds := [...]cli.Command{
+		cli.Command{
+			Name:      "show ip6 fib",
+			ShortHelp: "show ip6 forwarding table",
+			Action:    m.showIpFib,
+		},
+	}
+	for i := range cmds {
+		v.CliAdd(&cmds[i])
+	}
+}
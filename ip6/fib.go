@@ -4,6 +4,16 @@
 
 package ip6
 
+import (
+	"github.com/platinasystems/elib/dep"
+	"github.com/platinasystems/elib/parse"
+	"github.com/platinasystems/vnet"
+	"github.com/platinasystems/vnet/ip"
+
+	"bytes"
+	"fmt"
+)
+
 type Prefix struct {
 	Address
 	Len uint32
@@ -14,3 +24,648 @@ func (a *Address) toPrefix() (p Prefix) {
 	p.Address = *a
 	return
 }
+
+func (a *Address) IsEqual(b *Address) bool { return *a == *b }
+func (a *Address) IsZero() bool            { return *a == (Address{}) }
+
+func (p *Prefix) IsEqual(q *Prefix) bool { return p.Len == q.Len && p.Address.IsEqual(&q.Address) }
+
+func (a *Address) Diff(b *Address) int { return bytes.Compare(a[:], b[:]) }
+
+func (p *Prefix) LessThan(q *Prefix) bool {
+	if cmp := p.Address.Diff(&q.Address); cmp != 0 {
+		return cmp < 0
+	}
+	return p.Len < q.Len
+}
+
+// Add adds offset to prefix.  For example, 2001:db8::/64 + 1 = 2001:db8:0:1::/64.
+func (p *Prefix) Add(offset uint) (q Prefix) {
+	q = *p
+	if p.Len == 0 {
+		return
+	}
+	// Add offset shifted to last bit of prefix with carry from low to high bytes.
+	shift := 128 - uint(p.Len)
+	carry := uint64(offset) << (shift % 8)
+	for i := 15 - int(shift/8); i >= 0 && carry != 0; i-- {
+		carry += uint64(q.Address[i])
+		q.Address[i] = uint8(carry)
+		carry >>= 8
+	}
+	return
+}
+
+// True if given destination matches prefix.
+func (dst *Address) MatchesPrefix(p *Prefix) bool {
+	m := &mapFibMasks[p.Len]
+	for i := range dst {
+		if (dst[i]^p.Address[i])&m[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func FromIp6Prefix(i *ip.Prefix) (p Prefix) {
+	copy(p.Address[:], i.Address[:AddressBytes])
+	p.Len = i.Len
+	return
+}
+func (p *Prefix) ToIpPrefix() (i ip.Prefix) {
+	copy(i.Address[:], p.Address[:])
+	i.Len = p.Len
+	return
+}
+
+type mapFib struct {
+	// Maps for /0 through /128; key is address with prefix mask applied.
+	maps [1 + 128]map[Address]ip.Adj
+
+	// Prefix lengths with non-empty maps from longest to shortest.
+	// Lookups only need to search these lengths.
+	lens []uint8
+}
+
+var mapFibMasks [129]Address
+
+func init() {
+	for l := range mapFibMasks {
+		m := &mapFibMasks[l]
+		for i := 0; i < l/8; i++ {
+			m[i] = 0xff
+		}
+		if l%8 != 0 {
+			m[l/8] = 0xff << uint(8-l%8)
+		}
+	}
+}
+
+func (p *Prefix) MaskAsAddress() Address { return mapFibMasks[p.Len] }
+func (p *Prefix) mapFibKey() (k Address) {
+	m := &mapFibMasks[p.Len]
+	for i := range k {
+		k[i] = p.Address[i] & m[i]
+	}
+	return
+}
+
+func (m *mapFib) updateLens() {
+	m.lens = m.lens[:0]
+	for l := len(m.maps) - 1; l >= 0; l-- {
+		if len(m.maps[l]) > 0 {
+			m.lens = append(m.lens, uint8(l))
+		}
+	}
+}
+
+func (m *mapFib) set(p *Prefix, r ip.Adj) (oldAdj ip.Adj, ok bool) {
+	l := p.Len
+	if m.maps[l] == nil {
+		m.maps[l] = make(map[Address]ip.Adj)
+	}
+	k := p.mapFibKey()
+	if oldAdj, ok = m.maps[l][k]; !ok {
+		oldAdj = ip.AdjNil
+	}
+	ok = true // set never fails
+	m.maps[l][k] = r
+	if len(m.maps[l]) == 1 {
+		m.updateLens()
+	}
+	return
+}
+
+func (m *mapFib) unset(p *Prefix) (oldAdj ip.Adj, ok bool) {
+	k := p.mapFibKey()
+	if oldAdj, ok = m.maps[p.Len][k]; ok {
+		delete(m.maps[p.Len], k)
+		if len(m.maps[p.Len]) == 0 {
+			m.updateLens()
+		}
+	} else {
+		oldAdj = ip.AdjNil
+	}
+	return
+}
+
+func (m *mapFib) get(p *Prefix) (r ip.Adj, ok bool) {
+	r, ok = m.maps[p.Len][p.mapFibKey()]
+	return
+}
+
+// Longest prefix match: search non-empty prefix lengths from longest to shortest.
+func (m *mapFib) lookup(a *Address) ip.Adj {
+	p := a.toPrefix()
+	for _, l := range m.lens {
+		p.Len = uint32(l)
+		if r, ok := m.maps[l][p.mapFibKey()]; ok {
+			return r
+		}
+	}
+	return ip.AdjMiss
+}
+
+// Calls function for each more specific prefix matching given key.
+func (m *mapFib) foreachMatchingPrefix(key *Prefix, fn func(p *Prefix, a ip.Adj)) {
+	for l := key.Len + 1; l < uint32(len(m.maps)); l++ {
+		for k, a := range m.maps[l] {
+			p := Prefix{Address: k, Len: l}
+			if k.MatchesPrefix(key) {
+				fn(&p, a)
+			}
+		}
+	}
+}
+
+func (m *mapFib) foreach(fn func(p *Prefix, a ip.Adj)) {
+	for _, l := range m.lens {
+		for k, a := range m.maps[l] {
+			p := Prefix{Address: k, Len: uint32(l)}
+			fn(&p, a)
+		}
+	}
+}
+
+type Fib struct {
+	index ip.FibIndex
+
+	// Route table id of this fib.
+	id ip.FibId
+
+	// Hash (Go map) fib per prefix length.
+	mapFib
+
+	// Routes with next hops resolved through covering routes.
+	recursiveFib
+}
+
+// Total number of routes in FIB.
+func (f *Fib) Len() (n uint) {
+	for i := range f.mapFib.maps {
+		n += uint(len(f.mapFib.maps[i]))
+	}
+	return
+}
+
+type FibAddDelHook func(i ip.FibIndex, p *Prefix, r ip.Adj, isDel bool)
+
+//go:generate gentemplate -id FibAddDelHook -d Package=ip6 -d DepsType=FibAddDelHookVec -d Type=FibAddDelHook -d Data=hooks github.com/platinasystems/elib/dep/dep.tmpl
+
+func (f *Fib) addDel(main *Main, p *Prefix, r ip.Adj, isDel bool) (oldAdj ip.Adj, ok bool) {
+	// Call hooks before unset.
+	if isDel {
+		for i := range main.fibAddDelHooks.hooks {
+			main.fibAddDelHooks.Get(i)(f.index, p, r, isDel)
+		}
+	}
+
+	if isDel {
+		oldAdj, ok = f.mapFib.unset(p)
+	} else {
+		oldAdj, ok = f.mapFib.set(p, r)
+	}
+
+	// Call hooks after add.
+	if !isDel {
+		for i := range main.fibAddDelHooks.hooks {
+			main.fibAddDelHooks.Get(i)(f.index, p, r, isDel)
+		}
+	}
+
+	return
+}
+
+func (f *Fib) maybeRemapAdjacencies(m *Main) {
+	if m.NRemaps == 0 {
+		return
+	}
+	for l := range f.maps {
+		for dst, adj := range f.maps[l] {
+			if newAdj, ok := m.Remaps[adj].GetAndInvalidate(); ok {
+				if newAdj == ip.AdjNil {
+					delete(f.maps[l], dst)
+				} else {
+					f.maps[l][dst] = newAdj
+				}
+				p := &Prefix{Address: dst, Len: uint32(l)}
+				for i := range m.fibAddDelHooks.hooks {
+					m.fibAddDelHooks.Get(i)(f.index, p, newAdj, newAdj == ip.AdjNil /* isDel */)
+				}
+			}
+		}
+	}
+	f.updateLens()
+	m.NRemaps = 0
+}
+
+func (f *Fib) Get(p *Prefix) (a ip.Adj, ok bool) {
+	if a, ok = f.get(p); !ok {
+		a = ip.AdjNil
+	}
+	return
+}
+
+func (f *Fib) Add(m *Main, p *Prefix, r ip.Adj) (ip.Adj, bool) { return f.addDel(m, p, r, false) }
+func (f *Fib) Del(m *Main, p *Prefix) (ip.Adj, bool)           { return f.addDel(m, p, ip.AdjMiss, true) }
+func (f *Fib) Lookup(a *Address) ip.Adj                        { return f.mapFib.lookup(a) }
+
+// Lookup destination address in table for packets received on given interface.
+func (m *Main) Lookup(si vnet.Si, a *Address) (r ip.Adj) {
+	r = ip.AdjMiss
+	fi := m.LookupFibIndexForSi(si)
+	if a.IsLinkLocal() {
+		var ok bool
+		if fi, ok = m.linkLocalFibIndexBySi[si]; !ok {
+			return
+		}
+	}
+	if uint(fi) < m.fibs.Len() {
+		if f := m.fibs[fi]; f != nil {
+			r = f.Lookup(a)
+		}
+	}
+	return
+}
+
+func (m *Main) setInterfaceAdjacency(a *ip.Adjacency, si vnet.Si, ia ip.IfAddr) {
+	sw := m.Vnet.SwIf(si)
+	hw := m.Vnet.SupHwIf(sw)
+	h := m.Vnet.HwIfer(hw.Hi())
+
+	next := ip.LookupNextRewrite
+	var noder vnet.Noder = &m.rewriteNode
+
+	// Neighbor discovery packets are ip6 so packet type is the same for both cases.
+	if _, ok := h.(vnet.Arper); ok && m.gleanNode != nil {
+		next = ip.LookupNextGlean
+		noder = m.gleanNode
+		a.IfAddr = ia
+	}
+
+	a.LookupNextIndex = next
+	m.Vnet.SetRewrite(&a.Rewrite, si, noder, vnet.IP6, nil /* dstAdr meaning broadcast */)
+}
+
+// RewriteInterfaceAdjacencies re-makes rewrites of interface's glean and interface route adjacencies
+// (e.g. after ethernet address of interface has changed).
+func (m *Main) RewriteInterfaceAdjacencies(si vnet.Si) {
+	m.ForeachIfAddress(si, func(ia ip.IfAddr, ifa *ip.IfAddress) (err error) {
+		if ifa.NeighborProbeAdj != ip.AdjNil {
+			m.setInterfaceAdjacency(&m.GetAdj(ifa.NeighborProbeAdj)[0], si, ia)
+		}
+		return
+	})
+	if ai, ok := m.ifRouteAdjIndexBySi[si]; ok {
+		m.setInterfaceAdjacency(&m.GetAdj(ai)[0], si, ip.IfAddrNil)
+	}
+}
+
+type fibMain struct {
+	fibs FibVec
+	// Hooks to call on set/unset.
+	fibAddDelHooks      FibAddDelHookVec
+	ifRouteAdjIndexBySi map[vnet.Si]ip.Adj
+	// Fib for link local addresses indexed by interface.
+	linkLocalFibIndexBySi map[vnet.Si]ip.FibIndex
+}
+
+//go:generate gentemplate -d Package=ip6 -id Fib -d VecType=FibVec -d Type=*Fib github.com/platinasystems/elib/vec.tmpl
+
+func (m *fibMain) RegisterFibAddDelHook(f FibAddDelHook, dep ...*dep.Dep) {
+	m.fibAddDelHooks.Add(f, dep...)
+}
+
+func (m *Main) fibByIndex(i ip.FibIndex, create bool) (f *Fib) {
+	m.fibs.Validate(uint(i))
+	if create && m.fibs[i] == nil {
+		m.fibs[i] = &Fib{index: i}
+	}
+	f = m.fibs[i]
+	return
+}
+
+func (m *Main) fibById(id ip.FibId, create bool) (f *Fib) {
+	i, ok := m.FibIndexForId(id)
+	if !ok {
+		if !create {
+			return
+		}
+		// Index 0 is reserved for default table.
+		m.fibByIndex(0, true)
+		i = ip.FibIndex(m.fibs.Len())
+		m.SetFibIndexForId(id, i)
+	}
+	if f = m.fibByIndex(i, create); f != nil {
+		f.id = id
+	}
+	return
+}
+
+// FibIndexForTable returns index of fib for route table with given id; creating fib if requested.
+func (m *Main) FibIndexForTable(id ip.FibId, create bool) (fi ip.FibIndex, ok bool) {
+	if f := m.fibById(id, create); f != nil {
+		fi, ok = f.index, true
+	}
+	return
+}
+
+// SetInterfaceTable moves interface into route table with given id.
+// Routes for interface's addresses and neighbors (via interface table hooks) are moved from old table to new one.
+func (m *Main) SetInterfaceTable(si vnet.Si, id ip.FibId) (err error) {
+	fi, _ := m.FibIndexForTable(id, true)
+	oldFi := m.ValidateFibIndexForSi(si)
+	if fi == oldFi {
+		return
+	}
+	if err = m.CallInterfaceTableHooks(si, true); err != nil {
+		return
+	}
+	isUp := m.Vnet.SwIf(si).IsAdminUp()
+	if isUp {
+		err = m.ForeachIfAddress(si, func(ia ip.IfAddr, ifa *ip.IfAddress) error {
+			return m.addDelInterfaceRoutes(ia, true)
+		})
+		if err != nil {
+			return
+		}
+	}
+	m.SetFibIndexForSi(si, fi)
+	if isUp {
+		err = m.ForeachIfAddress(si, func(ia ip.IfAddr, ifa *ip.IfAddress) error {
+			return m.addDelInterfaceRoutes(ia, false)
+		})
+		if err != nil {
+			return
+		}
+	}
+	err = m.CallInterfaceTableHooks(si, false)
+	return
+}
+
+// Link local prefixes (interface routes, neighbors) are installed in a fib of their interface since
+// the same fe80::/64 is present on every interface.
+func (m *Main) linkLocalFib(si vnet.Si, create bool) (f *Fib) {
+	i, ok := m.linkLocalFibIndexBySi[si]
+	if !ok {
+		if !create {
+			return
+		}
+		m.fibByIndex(0, true)
+		i = ip.FibIndex(m.fibs.Len())
+		if m.linkLocalFibIndexBySi == nil {
+			m.linkLocalFibIndexBySi = make(map[vnet.Si]ip.FibIndex)
+		}
+		m.linkLocalFibIndexBySi[si] = i
+	}
+	return m.fibByIndex(i, create)
+}
+
+func (m *Main) linkLocalFibIndex(si vnet.Si, a *ip.Address) (fi ip.FibIndex, ok bool) {
+	if IpAddress(a).IsLinkLocal() {
+		fi, ok = m.linkLocalFib(si, true).index, true
+	}
+	return
+}
+
+// Fib for routes to given address on given interface.
+func (m *Main) fibForAddress(si vnet.Si, a *Address) *Fib {
+	if a.IsLinkLocal() {
+		return m.linkLocalFib(si, true)
+	}
+	return m.fibBySi(si)
+}
+
+func (m *Main) fibBySi(si vnet.Si) *Fib { return m.fibByIndex(m.FibIndexForSi(si), true) }
+
+func (m *Main) validateDefaultFibForSi(si vnet.Si) {
+	i := m.ValidateFibIndexForSi(si)
+	m.fibByIndex(i, true)
+}
+
+func (m *Main) getRoute(p *ip.Prefix, si vnet.Si) (ai ip.Adj, ok bool) {
+	f := m.fibBySi(si)
+	q := FromIp6Prefix(p)
+	ai, ok = f.Get(&q)
+	return
+}
+
+func (m *Main) getRouteFibIndex(p *ip.Prefix, fi ip.FibIndex) (ai ip.Adj, ok bool) {
+	f := m.fibByIndex(fi, false)
+	if f == nil {
+		ai = ip.AdjNil
+		return
+	}
+	q := FromIp6Prefix(p)
+	ai, ok = f.Get(&q)
+	return
+}
+
+func (m *Main) addDelRoute(p *ip.Prefix, fi ip.FibIndex, newAdj ip.Adj, isDel bool) (oldAdj ip.Adj, err error) {
+	createFib := !isDel
+	f := m.fibByIndex(fi, createFib)
+	q := FromIp6Prefix(p)
+	if f == nil {
+		err = fmt.Errorf("prefix %s not found", &q)
+		return
+	}
+	var ok bool
+	oldAdj, ok = f.addDel(m, &q, newAdj, isDel)
+	if !ok {
+		err = fmt.Errorf("prefix %s not found", &q)
+	}
+	return
+}
+
+type NextHop struct {
+	Address Address
+	Si      vnet.Si
+	Weight  ip.NextHopWeight
+}
+
+func (x *NextHop) ParseWithArgs(in *parse.Input, args *parse.Args) {
+	v := args.Get().(*vnet.Vnet)
+	if !in.Parse("%v %v", &x.Si, v, &x.Address) {
+		panic(parse.ErrInput)
+	}
+	x.Weight = 1
+	in.Parse("weight %d", &x.Weight)
+}
+
+func (m *Main) AddDelRouteNextHop(p *Prefix, nh *NextHop, isDel bool) (err error) {
+	return m.addDelRouteNextHop(m.fibBySi(nh.Si), p, nh, isDel)
+}
+
+// As above but route is added/deleted in route table with given id instead of table of next hop interface.
+func (m *Main) AddDelRouteNextHopTable(id ip.FibId, p *Prefix, nh *NextHop, isDel bool) (err error) {
+	f := m.fibById(id, !isDel)
+	if f == nil {
+		err = fmt.Errorf("unknown table %d", id)
+		return
+	}
+	return m.addDelRouteNextHop(f, p, nh, isDel)
+}
+
+func (m *Main) addDelRouteNextHop(f *Fib, p *Prefix, nh *NextHop, isDel bool) (err error) {
+	var (
+		nhAdj, oldAdj, newAdj ip.Adj
+		adjs                  []ip.Adjacency
+		ok                    bool
+	)
+
+	if !isDel && p.Len == 128 && p.Address.IsEqual(&nh.Address) {
+		err = fmt.Errorf("prefix %s matches next-hop %s", p, &nh.Address)
+		return
+	}
+
+	// Zero address means interface next hop.
+	if nh.Address.IsZero() && !isDel {
+		if nhAdj, ok = m.ifRouteAdjIndexBySi[nh.Si]; !ok {
+			nhAdj, adjs = m.NewAdj(1)
+			m.setInterfaceAdjacency(&adjs[0], nh.Si, ip.IfAddrNil)
+			m.CallAdjAddHooks(nhAdj)
+			if m.ifRouteAdjIndexBySi == nil {
+				m.ifRouteAdjIndexBySi = make(map[vnet.Si]ip.Adj)
+			}
+			m.ifRouteAdjIndexBySi[nh.Si] = nhAdj
+		}
+	} else if nhAdj, ok = m.nextHopFib(f, nh).Get(&Prefix{Address: nh.Address, Len: 128}); !ok || f.isRecursiveNextHop(p, nh) {
+		// Next hop is not a known neighbor: resolve it through covering route.
+		return m.addDelRecursiveNextHop(f, p, nh, isDel)
+	}
+
+	oldAdj, ok = f.Get(p)
+	if isDel && !ok {
+		err = fmt.Errorf("unknown destination %s", p)
+		return
+	}
+
+	if newAdj, ok = m.AddDelNextHop(oldAdj, isDel, nhAdj, nh.Weight); !ok {
+		err = fmt.Errorf("requested next-hop %s not found in multipath", &nh.Address)
+		return
+	}
+
+	if oldAdj != newAdj {
+		// Only remove from fib on delete of final adjacency.
+		isFibDel := isDel
+		if isFibDel && newAdj != ip.AdjNil {
+			isFibDel = false
+		}
+		f.addDel(m, p, newAdj, isFibDel)
+	}
+
+	return
+}
+
+func (f *Fib) deleteMatchingRoutes(m *Main, key *Prefix) {
+	f.foreachMatchingPrefix(key, func(p *Prefix, a ip.Adj) {
+		f.Del(m, p)
+	})
+	f.maybeRemapAdjacencies(m)
+}
+
+func (m *Main) addDelInterfaceRoutes(ia ip.IfAddr, isDel bool) (err error) {
+	ifa := m.GetIfAddr(ia)
+	si := ifa.Si
+	sw := m.Vnet.SwIf(si)
+	hw := m.Vnet.SupHwIf(sw)
+	p := FromIp6Prefix(&ifa.Prefix)
+	fib := m.fibForAddress(si, &p.Address)
+
+	// Add interface's prefix as route tied to glean adjacency (neighbor discovery for Ethernet).
+	if p.Len < 128 {
+		addDelAdj := ip.AdjNil
+		if !isDel {
+			ai, as := m.NewAdj(1)
+			m.setInterfaceAdjacency(&as[0], si, ia)
+			m.CallAdjAddHooks(ai)
+			addDelAdj = ai
+		}
+		if _, ok := fib.addDel(m, &p, addDelAdj, isDel); !ok && isDel {
+			return fmt.Errorf("%s: interface route %s not found", si.Name(m.Vnet), &p)
+		}
+		ifa.NeighborProbeAdj = addDelAdj
+	}
+
+	// Add interface address as /128 local route.
+	{
+		addDelAdj := ip.AdjNil
+		if !isDel {
+			ai, as := m.NewAdj(1)
+			as[0].LookupNextIndex = ip.LookupNextLocal
+			as[0].IfAddr = ia
+			as[0].Si = si
+			as[0].SetMaxPacketSize(hw)
+			m.CallAdjAddHooks(ai)
+			addDelAdj = ai
+		}
+		p.Len = 128
+		if _, ok := fib.addDel(m, &p, addDelAdj, isDel); !ok && isDel {
+			return fmt.Errorf("%s: local route %s not found", si.Name(m.Vnet), &p)
+		}
+	}
+
+	if isDel {
+		p.Len = ifa.Prefix.Len
+		fib.deleteMatchingRoutes(m, &p)
+	}
+	return
+}
+
+func (m *Main) AddDelInterfaceAddress(si vnet.Si, addr *Prefix, isDel bool) (err error) {
+	if !isDel {
+		err = m.ForeachIfAddress(si, func(ia ip.IfAddr, ifa *ip.IfAddress) (err error) {
+			p := FromIp6Prefix(&ifa.Prefix)
+			if !p.IsEqual(addr) && (addr.Address.MatchesPrefix(&p) || p.Address.MatchesPrefix(addr)) {
+				err = fmt.Errorf("%s: add %s conflicts with existing address %s", si.Name(m.Vnet), addr, &p)
+			}
+			return
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	var (
+		ia     ip.IfAddr
+		exists bool
+	)
+
+	sw := m.Vnet.SwIf(si)
+	isUp := sw.IsAdminUp()
+	pa := addr.ToIpPrefix()
+
+	// If interface is admin up, delete interface routes *before* removing address.
+	if isUp && isDel {
+		ia, exists = m.Main.IfAddrForPrefix(si, &pa)
+		// For non-existing prefixes error will be signalled by AddDelInterfaceAddress below.
+		if exists {
+			if err = m.addDelInterfaceRoutes(ia, isDel); err != nil {
+				return
+			}
+		}
+	}
+
+	// Delete interface address.  Return error if deleting non-existent address.
+	if ia, exists, err = m.Main.AddDelInterfaceAddress(si, &pa, isDel); err != nil {
+		return
+	}
+
+	// If interface is up add interface routes.
+	if isUp && !isDel {
+		if err = m.addDelInterfaceRoutes(ia, isDel); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (m *Main) swIfAdminUpDown(v *vnet.Vnet, si vnet.Si, isUp bool) (err error) {
+	m.validateDefaultFibForSi(si)
+	err = m.ForeachIfAddress(si, func(ia ip.IfAddr, ifa *ip.IfAddress) error {
+		isDel := !isUp
+		return m.addDelInterfaceRoutes(ia, isDel)
+	})
+	return
+}
@@ -0,0 +1,52 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ip6
+
+import (
+	"testing"
+)
+
+func TestPrefixAdd(t *testing.T) {
+	tests := []struct {
+		p      Prefix
+		offset uint
+		want   Address
+	}{
+		// 2001:db8::/64 + 1 = 2001:db8:0:1::/64
+		{p: Prefix{Address{0x20, 0x01, 0x0d, 0xb8}, 64}, offset: 1,
+			want: Address{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 1}},
+		// Carry across bytes: 2001:db8:0:ffff::/64 + 1 = 2001:db8:1::/64
+		{p: Prefix{Address{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0xff, 0xff}, 64}, offset: 1,
+			want: Address{0x20, 0x01, 0x0d, 0xb8, 0, 1}},
+		// Offset wider than a byte: 2001:db8::/64 + 0x10001 = 2001:db8:1:1::/64
+		{p: Prefix{Address{0x20, 0x01, 0x0d, 0xb8}, 64}, offset: 0x10001,
+			want: Address{0x20, 0x01, 0x0d, 0xb8, 0, 1, 0, 1}},
+		// Length not a multiple of 8: 2001:db8::/61 + 1 = 2001:db8:0:8::/61
+		{p: Prefix{Address{0x20, 0x01, 0x0d, 0xb8}, 61}, offset: 1,
+			want: Address{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 8}},
+		// Host prefixes: ::ff/128 + 1 = ::100/128
+		{p: Prefix{Address{15: 0xff}, 128}, offset: 1,
+			want: Address{14: 1}},
+		// Single bit prefix: ::/1 + 1 = 8000::/1
+		{p: Prefix{Address{}, 1}, offset: 1,
+			want: Address{0x80}},
+		// Carry out of address is dropped.
+		{p: Prefix{Address{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, 128}, offset: 1,
+			want: Address{}},
+		// Zero length prefix is unchanged.
+		{p: Prefix{Address{0x20, 0x01}, 0}, offset: 1,
+			want: Address{0x20, 0x01}},
+		// Zero offset is unchanged.
+		{p: Prefix{Address{0x20, 0x01, 0x0d, 0xb8}, 48}, offset: 0,
+			want: Address{0x20, 0x01, 0x0d, 0xb8}},
+	}
+	for _, x := range tests {
+		q := x.p.Add(x.offset)
+		if q.Address != x.want || q.Len != x.p.Len {
+			t.Errorf("%v/%d + %d: got %v/%d, want %v/%d", x.p.Address[:], x.p.Len, x.offset,
+				q.Address[:], q.Len, x.want[:], x.p.Len)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ip6
+
+import (
+	"github.com/platinasystems/elib"
+	"github.com/platinasystems/vnet"
+	"github.com/platinasystems/vnet/ip"
+
+	"unsafe"
+)
+
+// Flow of packet: addresses, protocol and tcp/udp ports.
+// Ports are not used for packets with extension headers.
+func (h *Header) flow() (f ip.Flow) {
+	f.Src, f.Dst, f.Protocol = h.Src[:], h.Dst[:], ip.Protocol(h.Protocol)
+	hasPorts := (f.Protocol == ip.TCP || f.Protocol == ip.UDP) && vnet.Uint16(h.Payload_length).ToHost() >= 4
+	if hasPorts {
+		p := (*[2]vnet.Uint16)(elib.PointerAdd(unsafe.Pointer(h), HeaderBytes))
+		f.SrcPort, f.DstPort = p[0].ToHost(), p[1].ToHost()
+	}
+	return
+}
+
+// LookupFlow looks up packet's destination in table for packets received on given interface.
+// Adjacency within multipath blocks is chosen by flow hash of table.
+func (m *Main) LookupFlow(si vnet.Si, h *Header) (ai ip.Adj) {
+	ai = m.Lookup(si, &h.Dst)
+	if m.GetAdjacency(ai).NAdj > 1 {
+		f := h.flow()
+		ai = m.MultipathAdj(ai, f.Hash(m.FlowHashConfigForFibIndex(m.LookupFibIndexForSi(si))))
+	}
+	return
+}
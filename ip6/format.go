@@ -0,0 +1,41 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ip6
+
+import (
+	"github.com/platinasystems/elib/parse"
+
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+func (a *Address) Parse(in *parse.Input) {
+	if !a.parse(in.Token()) {
+		panic(parse.ErrInput)
+	}
+}
+
+func (a *Address) parse(s string) (ok bool) {
+	ip := net.ParseIP(s)
+	if ok = ip != nil && ip.To4() == nil; ok {
+		copy(a[:], ip)
+	}
+	return
+}
+
+func (p *Prefix) String() string { return fmt.Sprintf("%s/%d", &p.Address, p.Len) }
+func (p *Prefix) Parse(in *parse.Input) {
+	s := strings.SplitN(in.Token(), "/", 2)
+	if len(s) != 2 || !p.Address.parse(s[0]) {
+		panic(parse.ErrInput)
+	}
+	l, err := strconv.ParseUint(s[1], 10, 32)
+	if err != nil || l > 128 {
+		panic(parse.ErrInput)
+	}
+	p.Len = uint32(l)
+}
@@ -0,0 +1,237 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ip6
+
+import (
+	"github.com/platinasystems/elib"
+	"github.com/platinasystems/elib/cpu"
+	"github.com/platinasystems/vnet"
+	"github.com/platinasystems/vnet/ip"
+
+	"unsafe"
+)
+
+type IcmpType uint8
+
+// Error message types; informational messages have types >= 128.
+const (
+	IcmpDestinationUnreachable IcmpType = 1
+	IcmpEchoRequest            IcmpType = 128
+)
+
+var icmpTypeStrings = [...]string{
+	IcmpDestinationUnreachable: "destination-unreachable",
+	IcmpEchoRequest:            "echo-request",
+}
+
+func (x IcmpType) String() string { return elib.StringerWithFormat(icmpTypeStrings[:], int(x), "%d") }
+
+// Destination unreachable codes.
+const (
+	IcmpNoRoute         = 0
+	IcmpAdminProhibited = 1
+)
+
+type IcmpHeader struct {
+	Type     IcmpType
+	Code     uint8
+	Checksum vnet.Uint16
+	// Unused for destination unreachable.
+	Data vnet.Uint32
+}
+
+const IcmpHeaderBytes = 8
+
+// Errors are no longer than minimum ip6 mtu (RFC 4443 section 2.4).
+const icmpErrorMaxBytes = 1280
+
+// Hop limit for packets sent by us.
+const defaultHopLimit = 64
+
+// Icmp6 checksum including ip6 pseudo header for message of n bytes following ip6 header.
+func icmpChecksum(h *Header, n uint) vnet.Uint16 {
+	var pseudo struct {
+		len      vnet.Uint32
+		protocol vnet.Uint32
+	}
+	pseudo.len = vnet.Uint32(n).FromHost()
+	pseudo.protocol = vnet.Uint32(ip.ICMP6).FromHost()
+	c := ip.Checksum(0)
+	c = checksumAdd(c, unsafe.Pointer(&h.Src[0]), 2*AddressBytes)
+	c = checksumAdd(c, unsafe.Pointer(&pseudo), unsafe.Sizeof(pseudo))
+	c = checksumAdd(c, elib.PointerAdd(unsafe.Pointer(h), HeaderBytes), uintptr(n))
+	return ^c.Fold()
+}
+
+func checksumAdd(c ip.Checksum, p unsafe.Pointer, n uintptr) ip.Checksum {
+	i := uintptr(0)
+	for ; i+2 <= n; i += 2 {
+		c = c.AddWithCarry(ip.Checksum(*(*uint16)(elib.PointerAdd(p, i))))
+	}
+	if i < n {
+		var b [2]uint8
+		b[0] = *(*uint8)(elib.PointerAdd(p, i))
+		c = c.AddWithCarry(ip.Checksum(*(*uint16)(unsafe.Pointer(&b[0]))))
+	}
+	return c
+}
+
+func (m *Main) icmpInit(v *vnet.Vnet) {
+	e := &m.errorNode
+	e.m = m
+	e.Next = []string{
+		error_next_drop: "error",
+		error_next_send: "ip6-input",
+	}
+	e.Errors = []string{
+		error_error_not_sent:                 "icmp6 error not sent",
+		error_error_rate_limited:             "icmp6 error rate limited",
+		error_error_no_address:               "no interface address for icmp6 error",
+		error_error_destination_unreachables: "destination unreachables sent",
+	}
+	v.RegisterInOutNode(e, "ip6-icmp-error")
+}
+
+const (
+	error_next_drop = iota
+	error_next_send
+)
+
+const (
+	error_error_none = iota
+	error_error_not_sent
+	error_error_rate_limited
+	error_error_no_address
+	error_error_destination_unreachables
+)
+
+// Max number of icmp6 errors sent per second.
+const icmpErrorsPerSecond = 100
+
+// Node sending icmp6 errors for packets to unreachable and prohibit routes.
+// Packet is replaced by error quoting as much of packet as fits in minimum mtu.
+type errorNode struct {
+	vnet.InOutNode
+	m *Main
+
+	// Number of errors sent since rate time.
+	rateTime  cpu.Time
+	rateCount uint
+}
+
+func (n *errorNode) isRateLimited() bool {
+	now := cpu.TimeNow()
+	if n.Vnet.TimeDiff(now, n.rateTime) > 1 {
+		n.rateTime = now
+		n.rateCount = 0
+	}
+	n.rateCount++
+	return n.rateCount > icmpErrorsPerSecond
+}
+
+// No errors are sent for multicast destinations, unspecified or multicast sources or icmp6 errors (RFC 4443 section 2.4).
+func (n *errorNode) isErrorAllowed(r *vnet.Ref, h *Header) bool {
+	if h.Dst.IsMulticast() || h.Src.IsMulticast() || h.Src == (Address{}) {
+		return false
+	}
+	if ip.Protocol(h.Protocol) == ip.ICMP6 {
+		l := uint(vnet.Uint16(h.Payload_length).ToHost())
+		if l < IcmpHeaderBytes || r.DataLen() < HeaderBytes+IcmpHeaderBytes {
+			return false
+		}
+		ih := (*IcmpHeader)(elib.PointerAdd(r.Data(), HeaderBytes))
+		return ih.Type >= IcmpEchoRequest
+	}
+	return true
+}
+
+// Source address for errors: address of receiving interface; global addresses are preferred
+// unless error goes to a link local address.
+func (n *errorNode) sourceAddress(si vnet.Si, dst *Address) (src Address, ok bool) {
+	n.m.ForeachIfAddress(si, func(_ ip.IfAddr, ia *ip.IfAddress) (err error) {
+		var a Address
+		copy(a[:], ia.Prefix.Address[:AddressBytes])
+		if !ok || !a.IsLinkLocal() && !dst.IsLinkLocal() {
+			src, ok = a, true
+		}
+		return
+	})
+	return
+}
+
+func (n *errorNode) errorNext(r *vnet.Ref, p *vnet.BufferPool) (next uint) {
+	next = error_next_drop
+	m := n.m
+	h := GetHeader(r)
+
+	var code uint8
+	// Adjacency found by ip6-input.
+	switch m.GetAdjacency(ip.GetRefAdj(r)).LookupNextIndex {
+	case ip.LookupNextUnreachable:
+		code = IcmpNoRoute
+	case ip.LookupNextProhibit:
+		code = IcmpAdminProhibited
+	default:
+		n.SetError(r, error_error_not_sent)
+		return
+	}
+
+	if !n.isErrorAllowed(r, h) {
+		n.SetError(r, error_error_not_sent)
+		return
+	}
+	dst := h.Src
+	src, ok := n.sourceAddress(r.Si, &dst)
+	if !ok {
+		n.SetError(r, error_error_no_address)
+		return
+	}
+	if n.isRateLimited() {
+		n.SetError(r, error_error_rate_limited)
+		return
+	}
+
+	// Error replaces packet so tail buffers of chained packets are freed.
+	p.Unchain(r)
+	h = GetHeader(r)
+
+	// Quote as much of packet as fits following new ip6 and icmp6 headers.
+	const o = HeaderBytes + IcmpHeaderBytes
+	q := HeaderBytes + uint(vnet.Uint16(h.Payload_length).ToHost())
+	if q > r.DataLen() {
+		q = r.DataLen()
+	}
+	if q > icmpErrorMaxBytes-o {
+		q = icmpErrorMaxBytes - o
+	}
+	r.SetDataLen(o + q)
+	b := r.DataSlice()
+	copy(b[o:], b[:q])
+
+	*h = Header{
+		Ip_version_traffic_class_and_flow_label: uint32(vnet.Uint32(6 << 28).FromHost()),
+		Payload_length:                          uint16(vnet.Uint16(IcmpHeaderBytes + q).FromHost()),
+		Protocol:                                uint8(ip.ICMP6),
+		Ttl:                                     defaultHopLimit,
+		Src:                                     src,
+		Dst:                                     dst,
+	}
+	ih := (*IcmpHeader)(elib.PointerAdd(r.Data(), HeaderBytes))
+	*ih = IcmpHeader{Type: IcmpDestinationUnreachable, Code: code}
+	ih.Checksum = icmpChecksum(h, IcmpHeaderBytes+q)
+
+	n.CountError(error_error_destination_unreachables, 1)
+	return error_next_send
+}
+
+func (n *errorNode) NodeInput(in *vnet.RefIn, o *vnet.RefOut) {
+	for i := uint(0); i < in.Len(); i++ {
+		r := &in.Refs[i]
+		x := n.errorNext(r, in.BufferPool)
+		o.Outs[x].BufferPool = in.BufferPool
+		no := o.Outs[x].AddLen(n.Vnet)
+		o.Outs[x].Refs[no] = *r
+	}
+}
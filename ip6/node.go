@@ -5,32 +5,247 @@
 package ip6
 
 import (
+	"github.com/platinasystems/elib/loop"
 	"github.com/platinasystems/vnet"
+	"github.com/platinasystems/vnet/ip"
 )
 
 func GetHeader(r *vnet.Ref) *Header { return (*Header)(r.Data()) }
 
 type nodeMain struct {
 	inputNode   inputNode
-	rewriteNode vnet.Node
-	arpNode     vnet.Node
+	rewriteNode rewriteNode
+	errorNode   errorNode
+
+	// Node to receive glean adjacency packets; registered by nd package.
+	gleanNode vnet.Noder
+}
+
+// RegisterGleanNode sets node used to resolve addresses for glean adjacencies.
+func (m *Main) RegisterGleanNode(n vnet.Noder, nodeName string) {
+	m.gleanNode = n
+	m.inputNode.gleanNodeName = nodeName
+}
+
+// RegisterProtocol sends local and multicast packets of given protocol to named node.
+// Packets of other protocols are punted.
+func (m *Main) RegisterProtocol(p ip.Protocol, nodeName string) {
+	n := &m.inputNode
+	if n.nodeNameByProtocol == nil {
+		n.nodeNameByProtocol = make(map[ip.Protocol]string)
+	}
+	n.nodeNameByProtocol[p] = nodeName
 }
 
 func (m *Main) nodeInit(v *vnet.Vnet) {
+	m.inputNode.m = m
 	m.inputNode.Next = []string{
-		input_next_drop: "error",
-		input_next_punt: "punt",
+		input_next_drop:       "error",
+		input_next_punt:       "punt",
+		input_next_rewrite:    "ip6-rewrite",
+		input_next_icmp_error: "ip6-icmp-error",
+	}
+	m.inputNode.Errors = []string{
+		input_error_version:       "version not 6",
+		input_error_length:        "length does not match buffer",
+		input_error_hop_limit:     "hop limit exceeded",
+		input_error_miss:          "no matching route",
+		input_error_drop:          "drop adjacency",
+		input_error_no_glean_node: "no neighbor discovery",
 	}
 	v.RegisterInOutNode(&m.inputNode, "ip6-input")
+
+	m.rewriteNode.m = m
+	// Output interface nexts are added by vnet.SetRewrite.
+	m.rewriteNode.Next = []string{
+		rewrite_next_drop: "error",
+		rewrite_next_punt: "punt",
+	}
+	m.rewriteNode.Errors = []string{
+		rewrite_error_not_rewrite:  "adjacency is not a rewrite",
+		rewrite_error_mtu_exceeded: "mtu exceeded",
+	}
+	v.RegisterInOutNode(&m.rewriteNode, "ip6-rewrite")
 }
 
 const (
 	input_next_drop = iota
 	input_next_punt
+	input_next_rewrite
+	input_next_icmp_error
 )
 
-type inputNode struct{ vnet.InOutNode }
+const (
+	input_error_none = iota
+	input_error_version
+	input_error_length
+	input_error_hop_limit
+	input_error_miss
+	input_error_drop
+	input_error_no_glean_node
+)
+
+type inputNode struct {
+	vnet.InOutNode
+	m *Main
+
+	// Next index indexed by protocol for local and multicast packets.
+	// Zero means protocol is not handled and packet will be punted.
+	nextByProtocol [256]uint8
 
-func (node *inputNode) NodeInput(in *vnet.RefIn, out *vnet.RefOut) {
-	node.Redirect(in, out, input_next_punt)
+	// Node names to resolve for each registered protocol.
+	nodeNameByProtocol map[ip.Protocol]string
+
+	gleanNodeName string
+	gleanNext     uint
+}
+
+// Resolve node names after all packages have registered their nodes.
+func (n *inputNode) LoopInit(l *loop.Loop) {
+	for p, name := range n.nodeNameByProtocol {
+		if next, err := l.AddNamedNext(n, name); err == nil {
+			n.nextByProtocol[p] = uint8(next)
+		}
+	}
+	if n.gleanNodeName != "" {
+		if next, err := l.AddNamedNext(n, n.gleanNodeName); err == nil {
+			n.gleanNext = next
+		}
+	}
+}
+
+func (n *inputNode) validate(r *vnet.Ref, h *Header) (e uint) {
+	e = input_error_none
+	if r.DataLen() < HeaderBytes {
+		return input_error_length
+	}
+	if h.Version() != 6 {
+		return input_error_version
+	}
+	l := HeaderBytes + uint(vnet.Uint16(h.Payload_length).ToHost())
+	if r.NextValidFlag() == 0 && l > r.DataLen() {
+		return input_error_length
+	}
+	return
+}
+
+// Local or multicast packets: registered protocol node or punt.
+func (n *inputNode) localNext(r *vnet.Ref, h *Header) (next uint) {
+	if next = uint(n.nextByProtocol[h.Protocol]); next == 0 {
+		next = input_next_punt
+		n.Vnet.RestoreL2Header(r)
+	}
+	return
+}
+
+func (n *inputNode) inputNext(r *vnet.Ref) (next uint) {
+	m := n.m
+	h := GetHeader(r)
+	if e := n.validate(r, h); e != input_error_none {
+		n.SetError(r, e)
+		return input_next_drop
+	}
+
+	if h.Dst.IsMulticast() {
+		return n.localNext(r, h)
+	}
+
+	ai := m.LookupFlow(r.Si, h)
+	a := m.GetAdjacency(ai)
+	ip.SetRefAdj(r, ai)
+	switch a.LookupNextIndex {
+	case ip.LookupNextMiss:
+		n.SetError(r, input_error_miss)
+		next = input_next_drop
+	case ip.LookupNextDrop:
+		n.SetError(r, input_error_drop)
+		next = input_next_drop
+	case ip.LookupNextUnreachable, ip.LookupNextProhibit:
+		next = input_next_icmp_error
+	case ip.LookupNextLocal:
+		next = n.localNext(r, h)
+	case ip.LookupNextGlean, ip.LookupNextRewrite:
+		// Packets to be forwarded must have hop limit left to decrement.
+		if h.Ttl <= 1 {
+			n.SetError(r, input_error_hop_limit)
+			return input_next_drop
+		}
+		next = input_next_rewrite
+		if a.LookupNextIndex == ip.LookupNextGlean {
+			if next = n.gleanNext; next == 0 {
+				n.SetError(r, input_error_no_glean_node)
+				next = input_next_drop
+			}
+		}
+	default:
+		next = input_next_punt
+		n.Vnet.RestoreL2Header(r)
+	}
+	return
+}
+
+func (n *inputNode) NodeInput(in *vnet.RefIn, o *vnet.RefOut) {
+	for i := uint(0); i < in.Len(); i++ {
+		r := &in.Refs[i]
+		x := n.inputNext(r)
+		o.Outs[x].BufferPool = in.BufferPool
+		no := o.Outs[x].AddLen(n.Vnet)
+		o.Outs[x].Refs[no] = *r
+	}
+}
+
+const (
+	rewrite_next_drop = iota
+	rewrite_next_punt
+)
+
+const (
+	rewrite_error_none = iota
+	rewrite_error_not_rewrite
+	rewrite_error_mtu_exceeded
+)
+
+type rewriteNode struct {
+	vnet.InOutNode
+	m *Main
+}
+
+func (n *rewriteNode) rewriteNext(r *vnet.Ref) (next uint) {
+	m := n.m
+	h := GetHeader(r)
+
+	ai := ip.GetRefAdj(r)
+	a := m.GetAdjacency(ai)
+	if a.LookupNextIndex != ip.LookupNextRewrite {
+		n.SetError(r, rewrite_error_not_rewrite)
+		return rewrite_next_drop
+	}
+	// Keeps neighbor of adjacency from aging out.
+	m.SetAdjUsed(ai)
+	rw := &a.Rewrite
+
+	// Punt packets which are too large for output interface.
+	// Kernel will send icmp6 packet too big.
+	l := HeaderBytes + uint(vnet.Uint16(h.Payload_length).ToHost())
+	if mtu := uint(rw.MaxL3PacketSize); mtu != 0 && l > mtu {
+		n.CountError(rewrite_error_mtu_exceeded, 1)
+		n.Vnet.RestoreL2Header(r)
+		return rewrite_next_punt
+	}
+
+	// No header checksum for ip6.
+	h.Ttl--
+	vnet.PerformRewrite(r, rw)
+	r.Si = rw.Si
+	return uint(rw.NextIndex)
+}
+
+func (n *rewriteNode) NodeInput(in *vnet.RefIn, o *vnet.RefOut) {
+	for i := uint(0); i < in.Len(); i++ {
+		r := &in.Refs[i]
+		x := n.rewriteNext(r)
+		o.Outs[x].BufferPool = in.BufferPool
+		no := o.Outs[x].AddLen(n.Vnet)
+		o.Outs[x].Refs[no] = *r
+	}
 }
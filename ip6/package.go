@@ -23,19 +23,28 @@ func ipAddressStringer(a *ip.Address) string { return IpAddress(a).String() }
 type Main struct {
 	vnet.Package
 	ip.Main
+	fibMain
 	nodeMain
 }
 
 func (m *Main) Init() (err error) {
 	v := m.Vnet
+	v.RegisterSwIfAdminUpDownHook(m.swIfAdminUpDown)
+	m.RegisterFibAddDelHook(m.recursiveFibAddDel)
 	cf := ip.FamilyConfig{
-		Family:          ip.Ip6,
-		AddressStringer: ipAddressStringer,
-		RewriteNode:     &m.rewriteNode,
-		PacketType:      vnet.IP6,
+		Family:            ip.Ip6,
+		AddressStringer:   ipAddressStringer,
+		RewriteNode:       &m.rewriteNode,
+		PacketType:        vnet.IP6,
+		GetRoute:          m.getRoute,
+		GetRouteFibIndex:  m.getRouteFibIndex,
+		AddDelRoute:       m.addDelRoute,
+		LinkLocalFibIndex: m.linkLocalFibIndex,
 	}
 	m.Main.Init(v, cf)
 	m.nodeInit(v)
+	m.icmpInit(v)
+	m.cliInit(v)
 
 	return
 }
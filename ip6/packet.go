@@ -54,7 +54,18 @@ func (a *Address) FromUint32(i int, x uint32) {
 	a[4*i+3] = byte(x)
 }
 
+func (a *Address) IsMulticast() bool { return a[0] == 0xff }
+
+// Link local unicast addresses: fe80::/10.
+func (a *Address) IsLinkLocal() bool { return a[0] == 0xfe && a[1]&0xc0 == 0x80 }
+
+func (h *Header) Version() uint { return uint(*(*uint8)(unsafe.Pointer(h)) >> 4) }
+
 func IpAddress(a *ip.Address) *Address { return (*Address)(unsafe.Pointer(&a[0])) }
+func (a *Address) ToIp() (v ip.Address) {
+	copy(v[:], a[:])
+	return
+}
 
 func (h *Header) Len() int              { return HeaderBytes }
 func (h *Header) Write(b *bytes.Buffer) { binary.Write(b, binary.BigEndian, h) }
@@ -0,0 +1,335 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ip6
+
+import (
+	"github.com/platinasystems/vnet/ip"
+
+	"fmt"
+)
+
+// Recursive next hops are next hops which are not neighbors (no /128 in fib).
+// They are resolved by longest prefix match through a covering route and
+// re-resolved whenever a route covering the next hop address is added, changed or deleted.
+// Next hops on connected subnets are resolved once their neighbor is known; resolution of neighbor
+// is started when covering route is an interface (glean) route.
+// Link local next hops resolve in fib of their interface; all others in fib of route.
+
+// Maximum depth of chains of recursive routes; deeper chains are treated as loops.
+const recursiveMaxDepth = 8
+
+type recursiveNextHop struct {
+	NextHop
+
+	// Covering route this next hop resolves through; ::/0 when there is none.
+	via Prefix
+
+	// Adjacency of covering route or AdjNil when next hop is unresolved.
+	adj ip.Adj
+}
+
+type recursiveRoute struct {
+	nhs []recursiveNextHop
+
+	// Next hop adjacencies and weights currently installed in route's multipath adjacency.
+	weights map[ip.Adj]ip.NextHopWeight
+}
+
+// Route with recursive next hops: prefix and fib it is installed in.
+type recursiveRouteKey struct {
+	fi ip.FibIndex
+	p  Prefix
+}
+
+type recursiveFib struct {
+	// Routes with recursive next hops indexed by masked prefix.
+	recursiveRoutes map[Prefix]*recursiveRoute
+
+	// Number of next hops of each recursive route indexed by covering route of next hops (in this fib).
+	// Only routes with next hops through routes covering a changed prefix need to be re-resolved.
+	recursiveRoutesByVia map[Prefix]map[recursiveRouteKey]uint
+
+	// Prefixes being deleted; these are skipped when resolving next hops.
+	deleting []Prefix
+}
+
+func (p *Prefix) masked() (q Prefix) {
+	q.Len = p.Len
+	q.Address = p.mapFibKey()
+	return
+}
+
+// Longest prefix match for next hop address returning matching prefix.
+func (f *Fib) lookupCovering(a *Address) (p Prefix, r ip.Adj, ok bool) {
+	p = a.toPrefix()
+	for _, l := range f.lens {
+		p.SetLen(uint(l))
+		p.Address = p.mapFibKey()
+		if r, ok = f.maps[l][p.Address]; ok && !f.isDeleting(&p) {
+			return
+		}
+	}
+	ok = false
+	return
+}
+
+func (f *recursiveFib) addDelVia(key *recursiveRouteKey, via *Prefix, isDel bool) {
+	rs := f.recursiveRoutesByVia[*via]
+	if isDel {
+		if rs[*key] > 1 {
+			rs[*key]--
+		} else {
+			delete(rs, *key)
+		}
+		if len(rs) == 0 {
+			delete(f.recursiveRoutesByVia, *via)
+		}
+		return
+	}
+	if rs == nil {
+		if f.recursiveRoutesByVia == nil {
+			f.recursiveRoutesByVia = make(map[Prefix]map[recursiveRouteKey]uint)
+		}
+		rs = make(map[recursiveRouteKey]uint)
+		f.recursiveRoutesByVia[*via] = rs
+	}
+	rs[*key]++
+}
+
+func (f *recursiveFib) setVia(key *recursiveRouteKey, nh *recursiveNextHop, via *Prefix) {
+	if nh.via.IsEqual(via) {
+		return
+	}
+	f.addDelVia(key, &nh.via, true)
+	nh.via = *via
+	f.addDelVia(key, &nh.via, false)
+}
+
+func (f *Fib) isDeleting(p *Prefix) bool {
+	for i := range f.deleting {
+		if f.deleting[i].IsEqual(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fib where next hop of route in fib f is resolved.
+func (m *Main) nextHopFib(f *Fib, nh *NextHop) *Fib {
+	if nh.Address.IsLinkLocal() {
+		return m.linkLocalFib(nh.Si, true)
+	}
+	return f
+}
+
+// True if route for prefix p resolves through route for prefix q; either directly or via other recursive routes.
+func (f *Fib) resolvesThrough(p, q *Prefix, depth int) bool {
+	if p.IsEqual(q) || depth >= recursiveMaxDepth {
+		return true
+	}
+	r := f.recursiveRoutes[*p]
+	if r == nil {
+		return false
+	}
+	for i := range r.nhs {
+		nh := &r.nhs[i]
+		if nh.adj != ip.AdjNil && !nh.Address.IsLinkLocal() && f.resolvesThrough(&nh.via, q, depth+1) {
+			return true
+		}
+	}
+	return false
+}
+
+// Only next hops which rewrite packets (e.g. known neighbors) can resolve a recursive next hop.
+// Glean adjacencies would resolve packet's destination instead of next hop.
+func (m *Main) isResolvingAdj(a ip.Adj) (ok bool) {
+	m.ForeachNextHop(a, func(nh ip.Adj, w ip.NextHopWeight) {
+		if m.GetAdjacency(nh).LookupNextIndex == ip.LookupNextRewrite {
+			ok = true
+		}
+	})
+	return
+}
+
+// Start resolving neighbors for next hops whose covering route is an interface route.
+func (m *Main) solicitNextHop(nh *recursiveNextHop, a ip.Adj) {
+	m.ForeachNextHop(a, func(nhAdj ip.Adj, w ip.NextHopWeight) {
+		if adj := m.GetAdjacency(nhAdj); adj.LookupNextIndex == ip.LookupNextGlean {
+			ia := nh.Address.ToIp()
+			m.SolicitNeighbor(&ia, adj.Si)
+		}
+	})
+}
+
+// Resolve next hop of route with given prefix.  Returns true if resolution would form a loop.
+func (m *Main) resolve(f *Fib, key *Prefix, nh *recursiveNextHop) (loop bool) {
+	nh.adj = ip.AdjNil
+	nf := m.nextHopFib(f, &nh.NextHop)
+	via, a, ok := nf.lookupCovering(&nh.Address)
+	if !ok {
+		via = Prefix{}
+	}
+	nf.setVia(&recursiveRouteKey{fi: f.index, p: *key}, nh, &via)
+	if !ok {
+		return
+	}
+	if nf == f {
+		if loop = f.resolvesThrough(&via, key, 0); loop {
+			return
+		}
+	}
+	if m.isResolvingAdj(a) {
+		nh.adj = a
+	} else {
+		m.solicitNextHop(nh, a)
+	}
+	return
+}
+
+// Update route's multipath adjacency from its resolved next hops.
+func (m *Main) updateRecursiveRoute(f *Fib, key *Prefix, r *recursiveRoute) {
+	want := make(map[ip.Adj]ip.NextHopWeight)
+	for i := range r.nhs {
+		nh := &r.nhs[i]
+		if nh.adj == ip.AdjNil {
+			continue
+		}
+		m.ForeachNextHop(nh.adj, func(a ip.Adj, w ip.NextHopWeight) {
+			if m.GetAdjacency(a).LookupNextIndex == ip.LookupNextRewrite {
+				want[a] += w * nh.Weight
+			}
+		})
+	}
+
+	oldAdj, ok := f.Get(key)
+	if !ok {
+		// Route has been removed from fib (e.g. interface address deleted).
+		oldAdj = ip.AdjNil
+		r.weights = nil
+	}
+
+	// Add next hops before deleting others so that route always has a next hop while there is one.
+	adj := oldAdj
+	for a, w := range want {
+		if r.weights[a] != w {
+			if newAdj, ok := m.AddDelNextHop(adj, false, a, w); ok {
+				adj = newAdj
+			}
+		}
+	}
+	for a := range r.weights {
+		if _, ok := want[a]; !ok {
+			if newAdj, ok := m.AddDelNextHop(adj, true, a, 0); ok {
+				adj = newAdj
+			}
+		}
+	}
+	r.weights = want
+
+	if adj != oldAdj {
+		f.addDel(m, key, adj, adj == ip.AdjNil)
+	}
+}
+
+func (f *Fib) findRecursiveNextHop(p *Prefix, nh *NextHop) (r *recursiveRoute, i int) {
+	i = -1
+	if r = f.recursiveRoutes[p.masked()]; r != nil {
+		for j := range r.nhs {
+			if r.nhs[j].Address.IsEqual(&nh.Address) && r.nhs[j].Si == nh.Si {
+				i = j
+				break
+			}
+		}
+	}
+	return
+}
+
+func (f *Fib) isRecursiveNextHop(p *Prefix, nh *NextHop) bool {
+	_, i := f.findRecursiveNextHop(p, nh)
+	return i >= 0
+}
+
+func (m *Main) addDelRecursiveNextHop(f *Fib, p *Prefix, nh *NextHop, isDel bool) (err error) {
+	key := p.masked()
+	r, i := f.findRecursiveNextHop(&key, nh)
+	nf := m.nextHopFib(f, nh)
+	rk := recursiveRouteKey{fi: f.index, p: key}
+
+	if isDel {
+		if i < 0 {
+			err = fmt.Errorf("requested next-hop %s not found for %s", &nh.Address, p)
+			return
+		}
+	} else {
+		if r == nil {
+			r = &recursiveRoute{}
+			if f.recursiveRoutes == nil {
+				f.recursiveRoutes = make(map[Prefix]*recursiveRoute)
+			}
+			f.recursiveRoutes[key] = r
+		}
+		if i < 0 {
+			i = len(r.nhs)
+			r.nhs = append(r.nhs, recursiveNextHop{NextHop: *nh})
+			nf.addDelVia(&rk, &r.nhs[i].via, false)
+		}
+		x := &r.nhs[i]
+		x.Weight = nh.Weight
+		if loop := m.resolve(f, &key, x); loop {
+			err = fmt.Errorf("next-hop %s for %s: recursive route loop", &nh.Address, p)
+			isDel = true
+		}
+	}
+
+	if isDel {
+		nf.addDelVia(&rk, &r.nhs[i].via, true)
+		copy(r.nhs[i:], r.nhs[i+1:])
+		r.nhs = r.nhs[:len(r.nhs)-1]
+	}
+	m.updateRecursiveRoute(f, &key, r)
+	if len(r.nhs) == 0 {
+		delete(f.recursiveRoutes, key)
+	}
+	return
+}
+
+// Fib add/delete hook: re-resolve recursive next hops covered by changed prefix.
+// Routes may be in other fibs than the changed one when their next hops are link local.
+func (m *Main) recursiveFibAddDel(fi ip.FibIndex, p *Prefix, adj ip.Adj, isDel bool) {
+	f := m.fibs[fi]
+	if len(f.recursiveRoutesByVia) == 0 {
+		return
+	}
+	// Hook is called before prefix is deleted from fib.
+	if isDel {
+		f.deleting = append(f.deleting, *p)
+		defer func() { f.deleting = f.deleting[:len(f.deleting)-1] }()
+	}
+	// Next hops covered by prefix resolve through a route covering prefix (or through no route).
+	keys := make(map[recursiveRouteKey]bool)
+	for l := int(p.Len); l >= 0; l-- {
+		via := *p
+		via.SetLen(uint(l))
+		via = via.masked()
+		for key := range f.recursiveRoutesByVia[via] {
+			keys[key] = true
+		}
+	}
+	for key := range keys {
+		rf := m.fibs[key.fi]
+		r := rf.recursiveRoutes[key.p]
+		changed := false
+		for i := range r.nhs {
+			nh := &r.nhs[i]
+			if nh.Address.MatchesPrefix(p) && m.nextHopFib(rf, &nh.NextHop) == f {
+				m.resolve(rf, &key.p, nh)
+				changed = true
+			}
+		}
+		if changed {
+			m.updateRecursiveRoute(rf, &key.p, r)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mpls
+
+import (
+	"github.com/platinasystems/elib/cli"
+	"github.com/platinasystems/vnet"
+
+	"fmt"
+	"sort"
+)
+
+func (m *Main) mplsLabel(c cli.Commander, w cli.Writer, in *cli.Input) (err error) {
+	var (
+		l     Label
+		e     Entry
+		isDel bool
+	)
+	switch {
+	case in.Parse("add"):
+		isDel = false
+	case in.Parse("del"):
+		isDel = true
+	}
+	if !in.Parse("%v", &l) {
+		err = fmt.Errorf("looking for label, got `%s'", in)
+		return
+	}
+	if !isDel {
+		switch {
+		case in.Parse("pop"):
+			e.Action = Pop
+		case in.Parse("swap %v via %v", &e.OutLabel, &e.NextHop, m.Vnet):
+			e.Action = Swap
+		default:
+			err = fmt.Errorf("looking for pop or swap LABEL via NEXT-HOP, got `%s'", in)
+			return
+		}
+	}
+	if !in.End() {
+		err = cli.ParseError
+		return
+	}
+	err = m.AddDelLabel(l, &e, isDel)
+	return
+}
+
+type showMplsFibEntries []Label
+
+func (x showMplsFibEntries) Less(i, j int) bool { return x[i] < x[j] }
+func (x showMplsFibEntries) Swap(i, j int)      { x[i], x[j] = x[j], x[i] }
+func (x showMplsFibEntries) Len() int           { return len(x) }
+
+func (m *Main) showMplsFib(c cli.Commander, w cli.Writer, in *cli.Input) (err error) {
+	ls := []Label{}
+	for l := range m.entries {
+		ls = append(ls, l)
+	}
+	sort.Sort(showMplsFibEntries(ls))
+
+	fmt.Fprintf(w, "%10s%40s\n", "Label", "Action")
+	for _, l := range ls {
+		fmt.Fprintf(w, "%10s%40s\n", l, m.entries[l].String(m.Vnet))
+	}
+	return
+}
+
+func (m *Main) cliInit(v *vnet.Vnet) {
+	cmds := [...]cli.Command{
+		cli.Command{
+			Name:      "mpls label",
+			ShortHelp: "add/delete mpls label fib entries",
+			Action:    m.mplsLabel,
+		},
+		cli.Command{
+			Name:      "show mpls fib",
+			ShortHelp: "show mpls label forwarding table",
+			Action:    m.showMplsFib,
+		},
+	}
+	for i := range cmds {
+		v.CliAdd(&cmds[i])
+	}
+}
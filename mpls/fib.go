@@ -0,0 +1,337 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mpls
+
+import (
+	"github.com/platinasystems/elib"
+	"github.com/platinasystems/elib/parse"
+	"github.com/platinasystems/vnet"
+	"github.com/platinasystems/vnet/ethernet"
+	"github.com/platinasystems/vnet/ip"
+	"github.com/platinasystems/vnet/ip4"
+
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+// Action performed for incoming label.
+type Action uint8
+
+const (
+	// Remove label and continue with next label or ip payload.
+	Pop Action = iota
+	// Replace label with out label and forward to next hop.
+	// Swap to implicit null label pops label and forwards payload to next hop (penultimate hop popping).
+	Swap
+)
+
+var actionStrings = [...]string{
+	Pop:  "pop",
+	Swap: "swap",
+}
+
+func (x Action) String() string { return elib.StringerHex(actionStrings[:], int(x)) }
+
+func (l Label) String() string {
+	switch l {
+	case ImplicitNullLabel:
+		return "implicit-null"
+	case Ip4ExplicitNullLabel:
+		return "ip4-explicit-null"
+	case Ip6ExplicitNullLabel:
+		return "ip6-explicit-null"
+	}
+	return fmt.Sprintf("%d", uint32(l))
+}
+
+func (l *Label) Parse(in *parse.Input) {
+	var x uint32
+	switch {
+	case in.Parse("implicit-null"):
+		*l = ImplicitNullLabel
+	case in.Parse("ip4-explicit-null"):
+		*l = Ip4ExplicitNullLabel
+	case in.Parse("ip6-explicit-null"):
+		*l = Ip6ExplicitNullLabel
+	case in.Parse("%d", &x) && Label(x) <= MaxLabel:
+		*l = Label(x)
+	default:
+		panic(parse.ErrInput)
+	}
+}
+
+// Payload following label stack; selects rewrite for swapped packets.
+type payload uint8
+
+const (
+	payload_mpls payload = iota
+	payload_ip4
+	payload_ip6
+	n_payload
+)
+
+var payloadPacketTypes = [n_payload]vnet.PacketType{
+	payload_mpls: vnet.MPLS_UNICAST,
+	payload_ip4:  vnet.IP4,
+	payload_ip6:  vnet.IP6,
+}
+
+type Entry struct {
+	Action
+	OutLabel Label
+	// Next hop for swap.
+	NextHop ip4.NextHop
+
+	// Rewrites by payload: all payloads are mpls unless out label is implicit null.
+	rewrites [n_payload]vnet.Rewrite
+	// Set when next hop's neighbor is known; rewrites are valid.
+	isResolved bool
+}
+
+func (e *Entry) isPhp() bool { return e.Action == Swap && e.OutLabel == ImplicitNullLabel }
+
+func (e *Entry) String(v *vnet.Vnet) (s string) {
+	s = e.Action.String()
+	if e.Action == Swap {
+		s += fmt.Sprintf(" %s via %s %s", e.OutLabel, e.NextHop.Si.Name(v), &e.NextHop.Address)
+	}
+	return
+}
+
+type fibMain struct {
+	entries map[Label]*Entry
+	// Ip4 routes pushing labels indexed by fib and prefix.
+	ip4Routes map[ip4RouteKey]*ip4Route
+}
+
+var (
+	ErrReservedLabel = errors.New("reserved label")
+	ErrUnknownLabel  = errors.New("unknown label")
+)
+
+// Returns ethernet address of resolved ip4 next hop.
+func (m *Main) nextHopEthernet(nh *ip4.NextHop) (ea ethernet.Address, err error) {
+	a := nh.Address.ToIp()
+	n, s, ok := m.em.GetIpNeighbor(&m.im.Main, &a, nh.Si)
+	if !ok || s == ethernet.NeighborIncomplete || s == ethernet.NeighborFailed {
+		err = fmt.Errorf("next hop %s %s not resolved", nh.Si.Name(m.Vnet), &nh.Address)
+		return
+	}
+	ea = n.Ethernet
+	return
+}
+
+func (e *Entry) setRewrites(m *Main, ea *ethernet.Address) {
+	for p := range e.rewrites {
+		if p != int(payload_mpls) && !e.isPhp() {
+			continue
+		}
+		m.Vnet.SetRewrite(&e.rewrites[p], e.NextHop.Si, &m.inputNode, payloadPacketTypes[p], ea[:])
+	}
+	e.isResolved = true
+}
+
+// AddDelLabel adds or deletes label fib entry for incoming label.
+func (m *Main) AddDelLabel(l Label, e *Entry, isDel bool) (err error) {
+	if l <= ExtensionLabel || l > MaxLabel {
+		err = ErrReservedLabel
+		return
+	}
+	if isDel {
+		if _, ok := m.entries[l]; !ok {
+			err = ErrUnknownLabel
+			return
+		}
+		delete(m.entries, l)
+		return
+	}
+
+	x := *e
+	if x.Action == Swap {
+		var ea ethernet.Address
+		if ea, err = m.nextHopEthernet(&x.NextHop); err != nil {
+			return
+		}
+		x.setRewrites(m, &ea)
+	}
+	if m.entries == nil {
+		m.entries = make(map[Label]*Entry)
+	}
+	m.entries[l] = &x
+	return
+}
+
+func (m *Main) getEntry(l Label) (e *Entry, ok bool) {
+	e, ok = m.entries[l]
+	return
+}
+
+// Time to live of pushed labels.  Ip time to live is not copied into label stack.
+const pushTTL = 255
+
+// Max number of labels pushed onto ip packets.
+const MaxPushLabels = 4
+
+type ip4RouteKey struct {
+	fi     ip.FibIndex
+	prefix ip4.Prefix
+}
+
+// Ip4 route via next hop pushing label stack.  Adjacency is owned by route.
+type ip4Route struct {
+	nextHop ip4.NextHop
+	labels  []Label
+	adj     ip.Adj
+}
+
+// Labels to push given route's out labels; implicit null means no label is pushed.
+func pushLabels(labels []Label) (ls []Label, err error) {
+	for _, l := range labels {
+		if l != ImplicitNullLabel {
+			ls = append(ls, l)
+		}
+	}
+	if len(ls) > MaxPushLabels {
+		err = fmt.Errorf("too many labels %d > %d", len(ls), MaxPushLabels)
+	}
+	return
+}
+
+// AddDelIp4Route adds or deletes ip4 route in table of next hop interface via next hop pushing given label stack.
+// Labels are ordered from top of stack to bottom.
+func (m *Main) AddDelIp4Route(p *ip4.Prefix, nh *ip4.NextHop, labels []Label, isDel bool) (err error) {
+	var ls []Label
+	if ls, err = pushLabels(labels); err != nil {
+		return
+	}
+	if len(ls) == 0 {
+		return m.im.AddDelRouteNextHop(p, nh, isDel)
+	}
+	return m.addDelIp4Route(m.im.FibIndexForSi(nh.Si), p, nh, ls, isDel)
+}
+
+// As above but route is added/deleted in route table with given id.
+func (m *Main) AddDelIp4RouteTable(id ip.FibId, p *ip4.Prefix, nh *ip4.NextHop, labels []Label, isDel bool) (err error) {
+	var ls []Label
+	if ls, err = pushLabels(labels); err != nil {
+		return
+	}
+	if len(ls) == 0 {
+		return m.im.AddDelRouteNextHopTable(id, p, nh, isDel)
+	}
+	fi, ok := m.im.FibIndexForTable(id, !isDel)
+	if !ok {
+		err = fmt.Errorf("unknown table %d", id)
+		return
+	}
+	return m.addDelIp4Route(fi, p, nh, ls, isDel)
+}
+
+func (m *Main) addDelIp4Route(fi ip.FibIndex, p *ip4.Prefix, nh *ip4.NextHop, ls []Label, isDel bool) (err error) {
+	im := &m.im.Main
+	pi := p.ToIpPrefix()
+	k := ip4RouteKey{fi: fi, prefix: *p}
+	old, isKnown := m.ip4Routes[k]
+
+	if isDel {
+		if !isKnown || old.nextHop.Si != nh.Si || !old.nextHop.Address.IsEqual(&nh.Address) {
+			err = fmt.Errorf("%s via %s %s not found", p, nh.Si.Name(m.Vnet), &nh.Address)
+			return
+		}
+		if _, err = m.im.AddDelRoute(&pi, fi, old.adj, isDel); err != nil {
+			return
+		}
+		im.CallAdjDelHooks(old.adj)
+		im.DelAdj(old.adj)
+		delete(m.ip4Routes, k)
+		return
+	}
+
+	// Adjacencies of other routes may be shared (e.g. neighbor adjacencies) so they are never replaced here.
+	if _, ok := m.im.GetRouteFibIndex(&pi, fi); ok && !isKnown {
+		err = fmt.Errorf("%s: route exists; delete it first", p)
+		return
+	}
+
+	var ea ethernet.Address
+	if ea, err = m.nextHopEthernet(nh); err != nil {
+		return
+	}
+	r := &ip4Route{nextHop: *nh, labels: ls}
+	var as []ip.Adjacency
+	r.adj, as = im.NewAdj(1)
+	a := &as[0]
+	a.NAdj = 1
+	a.IfAddr = ip.IfAddrNil
+	r.setRewrite(m, a, &ea)
+	im.CallAdjAddHooks(r.adj)
+
+	var oldAdj ip.Adj
+	if oldAdj, err = m.im.AddDelRoute(&pi, fi, r.adj, isDel); err != nil {
+		im.CallAdjDelHooks(r.adj)
+		im.DelAdj(r.adj)
+		return
+	}
+	// Free adjacency of route being replaced.
+	if isKnown && oldAdj == old.adj {
+		im.CallAdjDelHooks(oldAdj)
+		im.DelAdj(oldAdj)
+	}
+	if m.ip4Routes == nil {
+		m.ip4Routes = make(map[ip4RouteKey]*ip4Route)
+	}
+	m.ip4Routes[k] = r
+	return
+}
+
+// Rewrite for next hop's ethernet address followed by label stack.
+func (r *ip4Route) setRewrite(m *Main, a *ip.Adjacency, ea *ethernet.Address) {
+	a.LookupNextIndex = ip.LookupNextRewrite
+	m.Vnet.SetRewrite(&a.Rewrite, r.nextHop.Si, m.im.RewriteNode, vnet.MPLS_UNICAST, ea[:])
+	for i, l := range r.labels {
+		var h Header
+		h.Set(l, 0, i+1 == len(r.labels), pushTTL)
+		a.Rewrite.AddData(unsafe.Pointer(&h[0]), HeaderBytes)
+	}
+	// Zero means no mtu check.
+	if n := uint16(len(r.labels) * HeaderBytes); a.Rewrite.MaxL3PacketSize > n {
+		a.Rewrite.MaxL3PacketSize -= n
+	} else if a.Rewrite.MaxL3PacketSize != 0 {
+		a.Rewrite.MaxL3PacketSize = 1
+	}
+}
+
+// Keeps rewrites of label routes and swap entries in sync with next hop neighbors.
+// Routes whose neighbor is deleted (e.g. aged out) drop packets until neighbor is learned again.
+func (m *Main) ip4NeighborAddDel(im *ip.Main, n *ethernet.IpNeighbor, isDel bool) {
+	if im != &m.im.Main {
+		return
+	}
+	var a ip4.Address
+	copy(a[:], n.Ip[:ip4.AddressBytes])
+	matches := func(nh *ip4.NextHop) bool { return nh.Si == n.Si && nh.Address.IsEqual(&a) }
+	for _, r := range m.ip4Routes {
+		if !matches(&r.nextHop) {
+			continue
+		}
+		as := im.GetAdj(r.adj)
+		if isDel {
+			as[0].LookupNextIndex = ip.LookupNextDrop
+		} else {
+			r.setRewrite(m, &as[0], &n.Ethernet)
+		}
+	}
+	for _, e := range m.entries {
+		if e.Action != Swap || !matches(&e.NextHop) {
+			continue
+		}
+		if isDel {
+			e.isResolved = false
+		} else {
+			e.setRewrites(m, &n.Ethernet)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mpls
+
+import (
+	"github.com/platinasystems/vnet"
+	"github.com/platinasystems/vnet/ethernet"
+)
+
+type nodeMain struct {
+	inputNode inputNode
+}
+
+func (m *Main) nodeInit(v *vnet.Vnet) {
+	n := &m.inputNode
+	n.m = m
+	n.Next = []string{
+		input_next_drop: "error",
+		input_next_ip4:  "ip4-input",
+		input_next_ip6:  "ip6-input",
+	}
+	n.Errors = []string{
+		input_error_too_short:       "packet too short",
+		input_error_unknown_label:   "unknown label",
+		input_error_ttl_expired:     "ttl expired",
+		input_error_stack_too_deep:  "label stack too deep",
+		input_error_unknown_payload: "unknown payload",
+		input_error_unresolved:      "next hop not resolved",
+	}
+	v.RegisterInOutNode(n, "mpls-input")
+	m.em.RegisterType(ethernet.MPLS_UNICAST, "mpls-input")
+}
+
+const (
+	input_next_drop = iota
+	input_next_ip4
+	input_next_ip6
+)
+
+const (
+	input_error_none = iota
+	input_error_too_short
+	input_error_unknown_label
+	input_error_ttl_expired
+	input_error_stack_too_deep
+	input_error_unknown_payload
+	input_error_unresolved
+)
+
+// Max number of labels popped from a single packet.
+const maxPopLabels = 8
+
+// Explicit null labels are always popped.
+var explicitNullEntry = Entry{Action: Pop}
+
+type inputNode struct {
+	vnet.InOutNode
+	m *Main
+}
+
+// Payload following bottom of stack given ip version of first byte.
+func payloadForPacket(r *vnet.Ref) (p payload, ok bool) {
+	if r.DataLen() == 0 {
+		return
+	}
+	switch *(*uint8)(r.Data()) >> 4 {
+	case 4:
+		p, ok = payload_ip4, true
+	case 6:
+		p, ok = payload_ip6, true
+	}
+	return
+}
+
+func (n *inputNode) inputNext(r *vnet.Ref) (next uint) {
+	next = input_next_drop
+	for i := 0; i < maxPopLabels; i++ {
+		if r.DataLen() < HeaderBytes {
+			n.SetError(r, input_error_too_short)
+			return
+		}
+		h := GetHeader(r)
+		l, bos, ttl := h.GetLabel(), h.IsBottomOfStack(), h.GetTTL()
+
+		e, ok := &explicitNullEntry, true
+		if l != Ip4ExplicitNullLabel && l != Ip6ExplicitNullLabel {
+			e, ok = n.m.getEntry(l)
+		}
+		if !ok {
+			n.SetError(r, input_error_unknown_label)
+			return
+		}
+
+		if e.Action == Pop {
+			r.Advance(HeaderBytes)
+			if !bos {
+				continue
+			}
+			p, ok := payloadForPacket(r)
+			switch {
+			case !ok:
+				n.SetError(r, input_error_unknown_payload)
+			case p == payload_ip4:
+				next = input_next_ip4
+			default:
+				next = input_next_ip6
+			}
+			return
+		}
+
+		if ttl <= 1 {
+			n.SetError(r, input_error_ttl_expired)
+			return
+		}
+		if !e.isResolved {
+			n.SetError(r, input_error_unresolved)
+			return
+		}
+		p := payload_mpls
+		if e.isPhp() {
+			r.Advance(HeaderBytes)
+			if bos {
+				if p, ok = payloadForPacket(r); !ok {
+					n.SetError(r, input_error_unknown_payload)
+					return
+				}
+			}
+		} else {
+			h.SetLabelTTL(e.OutLabel, ttl-1)
+		}
+		rw := &e.rewrites[p]
+		vnet.PerformRewrite(r, rw)
+		r.Si = rw.Si
+		return uint(rw.NextIndex)
+	}
+	n.SetError(r, input_error_stack_too_deep)
+	return
+}
+
+func (n *inputNode) NodeInput(in *vnet.RefIn, o *vnet.RefOut) {
+	for i := uint(0); i < in.Len(); i++ {
+		r := &in.Refs[i]
+		x := n.inputNext(r)
+		o.Outs[x].BufferPool = in.BufferPool
+		no := o.Outs[x].AddLen(n.Vnet)
+		o.Outs[x].Refs[no] = *r
+	}
+}
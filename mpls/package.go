@@ -0,0 +1,39 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mpls
+
+import (
+	"github.com/platinasystems/vnet"
+	"github.com/platinasystems/vnet/ethernet"
+	"github.com/platinasystems/vnet/ip4"
+)
+
+var packageIndex uint
+
+type Main struct {
+	vnet.Package
+	em *ethernet.Main
+	im *ip4.Main
+	fibMain
+	nodeMain
+}
+
+func Init(v *vnet.Vnet) {
+	m := &Main{}
+	packageIndex = v.AddPackage("mpls", m)
+	m.DependsOn("ethernet", "ip4", "ip6")
+}
+
+func GetMain(v *vnet.Vnet) *Main { return v.GetPackage(packageIndex).(*Main) }
+
+func (m *Main) Init() (err error) {
+	v := m.Vnet
+	m.em = ethernet.GetMain(v)
+	m.im = ip4.GetMain(v)
+	m.nodeInit(v)
+	m.cliInit(v)
+	m.em.RegisterIpNeighborAddDelHook(m.ip4NeighborAddDel)
+	return
+}
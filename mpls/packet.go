@@ -24,6 +24,29 @@ func (h *Header) FromUint32(x vnet.Uint32) { *(*vnet.Uint32)(unsafe.Pointer(&h[0
 func (h *Header) GetLabel() Label          { return Label(h.AsUint32().ToHost() >> 12) }
 func (h *Header) GetTTL() uint8            { return h[3] }
 func (h *Header) IsBottomOfStack() bool    { return h[2]&1 != 0 }
+func (h *Header) GetExp() uint8            { return (h[2] >> 1) & 7 }
+
+func (h *Header) Set(l Label, exp uint8, bos bool, ttl uint8) {
+	x := uint32(l)<<12 | uint32(exp&7)<<9 | uint32(ttl)
+	if bos {
+		x |= 1 << 8
+	}
+	h.FromUint32(vnet.Uint32(x).FromHost())
+}
+
+// Replace label and time to live keeping traffic class and bottom of stack bit.
+func (h *Header) SetLabelTTL(l Label, ttl uint8) {
+	x := h.AsUint32().ToHost()
+	x = uint32(l)<<12 | x&(0xf<<8) | uint32(ttl)
+	h.FromUint32(vnet.Uint32(x).FromHost())
+}
+
+const HeaderBytes = 4
+
+// Largest valid label.
+const MaxLabel Label = 1<<20 - 1
+
+func GetHeader(r *vnet.Ref) *Header { return (*Header)(r.Data()) }
 
 // Special labels 0-15
 // 16-239 Unassigned.
@@ -0,0 +1,433 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nd
+
+import (
+	"github.com/platinasystems/elib"
+	"github.com/platinasystems/elib/cpu"
+	"github.com/platinasystems/vnet"
+	"github.com/platinasystems/vnet/ethernet"
+	"github.com/platinasystems/vnet/ip"
+	"github.com/platinasystems/vnet/ip6"
+
+	"unsafe"
+)
+
+type nodeMain struct {
+	inputNode inputNode
+	gleanNode gleanNode
+}
+
+func (m *Main) nodeInit(v *vnet.Vnet) {
+	n := &m.inputNode
+	n.m = m
+	n.Next = []string{
+		input_next_drop: "error",
+		input_next_punt: "punt",
+	}
+	n.Errors = []string{
+		input_error_too_short:               "packet too short",
+		input_error_invalid:                 "invalid neighbor discovery packet",
+		input_error_checksum:                "bad icmp6 checksum",
+		input_error_not_local:               "solicitation for non-local address",
+		input_error_advertisements_sent:     "advertisements sent",
+		input_error_advertisements_received: "advertisements received",
+		input_error_neighbor_add_error:      "neighbor add error",
+	}
+	v.RegisterInOutNode(n, "ip6-icmp-input")
+	n.rewrites.Init(v)
+	m.im.RegisterProtocol(ip.ICMP6, "ip6-icmp-input")
+	m.em.RegisterIpNeighborProber(ip.Ip6, n)
+
+	g := &m.gleanNode
+	g.m = m
+	g.Next = []string{
+		glean_next_drop: "error",
+	}
+	g.Errors = []string{
+		glean_error_not_glean:          "adjacency is not glean",
+		glean_error_no_address:         "no interface address",
+		glean_error_throttled:          "solicitation throttled",
+		glean_error_solicitations_sent: "solicitations sent",
+	}
+	v.RegisterInOutNode(g, "ip6-glean")
+	m.im.RegisterGleanNode(g, "ip6-glean")
+	m.im.RegisterNeighborSolicitor(m.em.ResolveIpNeighbor)
+}
+
+// Link layer address of given interface.
+func interfaceAddress(v *vnet.Vnet, si vnet.Si) (a ethernet.Address, ok bool) {
+	var h ethernet.HwInterfacer
+	if h, ok = v.HwIfer(v.SupHi(si)).(ethernet.HwInterfacer); ok {
+		a = h.GetInterface().Address
+	}
+	return
+}
+
+// First ip6 address of given interface.
+func (m *Main) interfaceIp6Address(si vnet.Si) (a ip6.Address, ok bool) {
+	m.im.ForeachIfAddress(si, func(_ ip.IfAddr, ia *ip.IfAddress) (err error) {
+		if !ok {
+			copy(a[:], ia.Prefix.Address[:])
+			ok = true
+		}
+		return
+	})
+	return
+}
+
+// Update neighbor in ethernet neighbor table (and thereby fib) with link layer address and state.
+func (m *Main) updateNeighbor(si vnet.Si, a *ip6.Address, ea *ethernet.Address, s ethernet.NeighborState) error {
+	nb := ethernet.IpNeighbor{
+		Ethernet: *ea,
+		Ip:       a.ToIp(),
+		Si:       si,
+	}
+	return m.em.UpdateIpNeighbor(&m.im.Main, &nb, s)
+}
+
+// Fill in ip6 header, neighbor discovery header and link layer address option.
+func (p *ethernetPacket) set(t Icmp6Type, flags uint32, src, dst, target *ip6.Address, optionType uint8, ea *ethernet.Address) {
+	*p = ethernetPacket{}
+	p.ip.Ip_version_traffic_class_and_flow_label = uint32(vnet.Uint32(6 << 28).FromHost())
+	p.ip.Payload_length = uint16(vnet.Uint16(HeaderBytes + EthernetLinkLayerAddressOptionBytes).FromHost())
+	p.ip.Protocol = uint8(ip.ICMP6)
+	p.ip.Ttl = hopLimit
+	p.ip.Src, p.ip.Dst = *src, *dst
+	p.nd.Type = t
+	p.nd.Flags = vnet.Uint32(flags).FromHost()
+	p.nd.Target = *target
+	p.option.Type = optionType
+	p.option.Len = EthernetLinkLayerAddressOptionBytes / 8
+	p.option.Ethernet = *ea
+	p.nd.Checksum = checksum(&p.ip, HeaderBytes+EthernetLinkLayerAddressOptionBytes)
+}
+
+const (
+	input_next_drop = iota
+	input_next_punt
+)
+
+const (
+	input_error_none = iota
+	input_error_too_short
+	input_error_invalid
+	input_error_checksum
+	input_error_not_local
+	input_error_advertisements_sent
+	input_error_advertisements_received
+	input_error_neighbor_add_error
+)
+
+// Node receiving icmp6 packets for local and multicast addresses.
+// Neighbor solicitations and advertisements are handled here; other icmp6 packets are punted.
+type inputNode struct {
+	vnet.InOutNode
+	m *Main
+	// Rewrites for advertisements sent by interface.
+	rewrites ethernet.RewriteCache
+}
+
+// Find link layer address option of given type.
+func linkLayerAddress(h *Header, n uint, optionType uint8) (ea *ethernet.Address, ok bool) {
+	for i := uint(HeaderBytes); i+2 <= n; {
+		o := (*EthernetLinkLayerAddressOption)(elib.PointerAdd(unsafe.Pointer(h), uintptr(i)))
+		l := 8 * uint(o.Len)
+		if l == 0 || i+l > n {
+			return
+		}
+		if o.Type == optionType && l == EthernetLinkLayerAddressOptionBytes {
+			ea, ok = &o.Ethernet, true
+			return
+		}
+		i += l
+	}
+	return
+}
+
+// Source ethernet address of received packet.
+func ethernetSource(v *vnet.Vnet, r *vnet.Ref) (ea ethernet.Address) {
+	l := r.DataLen()
+	v.RestoreL2Header(r)
+	ea = ethernet.GetHeader(r).Src
+	r.Advance(int(r.DataLen() - l))
+	return
+}
+
+func (n *inputNode) inputNext(r *vnet.Ref) (next uint) {
+	m := n.m
+	next = input_next_drop
+	ih := ip6.GetHeader(r)
+	l := uint(vnet.Uint16(ih.Payload_length).ToHost())
+	if l < 4 || r.DataLen() < ip6.HeaderBytes+l {
+		n.SetError(r, input_error_too_short)
+		return
+	}
+
+	h := GetHeader(r)
+	if h.Type != NeighborSolicitation && h.Type != NeighborAdvertisement {
+		n.Vnet.RestoreL2Header(r)
+		return input_next_punt
+	}
+	if l < HeaderBytes {
+		n.SetError(r, input_error_too_short)
+		return
+	}
+	if ih.Ttl != hopLimit || h.Code != 0 || h.Target.IsMulticast() {
+		n.SetError(r, input_error_invalid)
+		return
+	}
+	if checksum(ih, l) != 0 {
+		n.SetError(r, input_error_checksum)
+		return
+	}
+
+	if h.Type == NeighborAdvertisement {
+		n.advertisement(r, ih, h, l)
+		n.SetError(r, input_error_advertisements_received)
+		return
+	}
+
+	// Neighbor solicitation.
+	lla, hasLla := linkLayerAddress(h, l, OptionSourceLinkLayerAddress)
+	isDad := ih.Src.IsZero()
+	if isDad && hasLla {
+		n.SetError(r, input_error_invalid)
+		return
+	}
+
+	// Only answer solicitations for our interface addresses on receiving interface.
+	var p ip.Prefix
+	copy(p.Address[:], h.Target[:])
+	ia, ok := m.im.IfAddrForPrefix(r.Si, &p)
+	if !ok || m.im.GetIfAddr(ia).Si != r.Si {
+		n.SetError(r, input_error_not_local)
+		return
+	}
+	ea, ok := interfaceAddress(n.Vnet, r.Si)
+	if !ok {
+		n.SetError(r, input_error_invalid)
+		return
+	}
+
+	// Solicitation with source link layer address creates stale entry for sender.
+	var dstEthernet ethernet.Address
+	if hasLla {
+		dstEthernet = *lla
+		if err := m.updateNeighbor(r.Si, &ih.Src, lla, ethernet.NeighborStale); err != nil {
+			n.CountError(input_error_neighbor_add_error, 1)
+		}
+	} else if !isDad {
+		dstEthernet = ethernetSource(n.Vnet, r)
+	}
+
+	// Turn solicitation into advertisement in place.
+	// Duplicate address detection solicitations are answered to all nodes.
+	flags := uint32(AdvertisementOverride)
+	dst := ih.Src
+	if isDad {
+		dst = allNodesAddress
+		dstEthernet = ethernetMulticastAddress(&dst)
+	} else {
+		flags |= AdvertisementSolicited
+	}
+	target := h.Target
+	pkt := (*ethernetPacket)(r.Data())
+	pkt.set(NeighborAdvertisement, flags, &target, &dst, &target, OptionTargetLinkLayerAddress, &ea)
+	r.SetDataLen(ethernetPacketBytes)
+
+	next = n.rewrites.PerformRewrite(n.Vnet, n, r, r.Si, vnet.IP6, &dstEthernet)
+	n.CountError(input_error_advertisements_sent, 1)
+	return
+}
+
+// Update neighbor state from received advertisement (RFC 4861 section 7.2.5).
+func (n *inputNode) advertisement(r *vnet.Ref, ih *ip6.Header, h *Header, l uint) {
+	m := n.m
+	flags := h.Flags.ToHost()
+	isSolicited := flags&AdvertisementSolicited != 0
+	isOverride := flags&AdvertisementOverride != 0
+	if isSolicited && ih.Dst.IsMulticast() {
+		return
+	}
+	lla, hasLla := linkLayerAddress(h, l, OptionTargetLinkLayerAddress)
+
+	// Unsolicited advertisements for unknown neighbors are ignored.
+	ta := h.Target.ToIp()
+	e, s, ok := m.em.GetIpNeighbor(&m.im.Main, &ta, r.Si)
+	if !ok {
+		return
+	}
+
+	var err error
+	switch {
+	case s == ethernet.NeighborIncomplete || s == ethernet.NeighborFailed:
+		if !hasLla {
+			return
+		}
+		s = ethernet.NeighborStale
+		if isSolicited {
+			s = ethernet.NeighborReachable
+		}
+		err = m.updateNeighbor(r.Si, &h.Target, lla, s)
+	case !isOverride && hasLla && *lla != e.Ethernet:
+		// Different address without override: keep old address but mark stale.
+		if s == ethernet.NeighborReachable {
+			err = m.updateNeighbor(r.Si, &h.Target, &e.Ethernet, ethernet.NeighborStale)
+		}
+	default:
+		isChanged := hasLla && *lla != e.Ethernet
+		if isSolicited {
+			s = ethernet.NeighborReachable
+		} else if isChanged {
+			s = ethernet.NeighborStale
+		}
+		if !hasLla {
+			lla = &e.Ethernet
+		}
+		err = m.updateNeighbor(r.Si, &h.Target, lla, s)
+	}
+	if err != nil {
+		n.CountError(input_error_neighbor_add_error, 1)
+	}
+}
+
+// See ethernet.IpNeighborProber interface.
+// Probes are neighbor solicitations sent to neighbor's unicast address.
+func (n *inputNode) ProbeIpNeighbor(r *vnet.Ref, nb *ethernet.IpNeighbor, isSolicit bool) (t vnet.PacketType, da ethernet.Address, ok bool) {
+	ea, ok := interfaceAddress(n.Vnet, nb.Si)
+	if !ok {
+		return
+	}
+	src, ok := n.m.interfaceIp6Address(nb.Si)
+	if !ok {
+		return
+	}
+	var target ip6.Address
+	copy(target[:], nb.Ip[:])
+	dst, da := target, nb.Ethernet
+	if isSolicit {
+		dst = solicitedNodeAddress(&target)
+		da = ethernetMulticastAddress(&dst)
+	}
+	pkt := (*ethernetPacket)(r.Data())
+	pkt.set(NeighborSolicitation, 0, &src, &dst, &target, OptionSourceLinkLayerAddress, &ea)
+	r.SetDataLen(ethernetPacketBytes)
+	t = vnet.IP6
+	return
+}
+
+func (n *inputNode) NodeInput(in *vnet.RefIn, o *vnet.RefOut) {
+	for i := uint(0); i < in.Len(); i++ {
+		r := &in.Refs[i]
+		x := n.inputNext(r)
+		o.Outs[x].BufferPool = in.BufferPool
+		no := o.Outs[x].AddLen(n.Vnet)
+		o.Outs[x].Refs[no] = *r
+	}
+}
+
+const (
+	glean_next_drop = iota
+)
+
+const (
+	glean_error_none = iota
+	glean_error_not_glean
+	glean_error_no_address
+	glean_error_throttled
+	glean_error_solicitations_sent
+)
+
+// Minimum time in seconds between solicitations for a given address.
+const gleanThrottleInterval = 1
+
+// Node receiving ip6 packets for glean adjacencies.
+// Sends neighbor solicitations for unresolved destinations, re-using packet buffer.
+type gleanNode struct {
+	vnet.InOutNode
+	m *Main
+
+	// Addresses for which solicitations have been sent since throttle time.
+	throttleTime cpu.Time
+	throttle     map[ip6.Address]struct{}
+}
+
+func (n *gleanNode) isThrottled(a *ip6.Address) (ok bool) {
+	now := cpu.TimeNow()
+	if n.throttle == nil || n.Vnet.TimeDiff(now, n.throttleTime) > gleanThrottleInterval {
+		n.throttle = make(map[ip6.Address]struct{})
+		n.throttleTime = now
+	}
+	if _, ok = n.throttle[*a]; !ok {
+		n.throttle[*a] = struct{}{}
+	}
+	return
+}
+
+// Source address for solicitations: interface address of adjacency or else first address of interface.
+func (n *gleanNode) sourceAddress(a *ip.Adjacency) (src ip6.Address, ok bool) {
+	im := &n.m.im.Main
+	if a.IfAddr != ip.IfAddrNil {
+		copy(src[:], im.GetIfAddr(a.IfAddr).Prefix.Address[:])
+		ok = true
+		return
+	}
+	return n.m.interfaceIp6Address(a.Si)
+}
+
+func (n *gleanNode) gleanNext(r *vnet.Ref, p *vnet.BufferPool) (next uint) {
+	next = glean_next_drop
+	m := n.m
+	ih := ip6.GetHeader(r)
+	dst := ih.Dst
+
+	a := m.im.GetAdjacency(ip.GetRefAdj(r))
+	if a.LookupNextIndex != ip.LookupNextGlean {
+		n.SetError(r, glean_error_not_glean)
+		return
+	}
+	rw := &a.Rewrite
+	ea, ok := interfaceAddress(n.Vnet, rw.Si)
+	if !ok {
+		n.SetError(r, glean_error_not_glean)
+		return
+	}
+	src, ok := n.sourceAddress(a)
+	if !ok {
+		n.SetError(r, glean_error_no_address)
+		return
+	}
+	if n.isThrottled(&dst) {
+		n.SetError(r, glean_error_throttled)
+		return
+	}
+	ia := dst.ToIp()
+	m.em.SolicitIpNeighbor(&m.im.Main, &ia, rw.Si)
+
+	// Packet which triggered solicitation is replaced by solicitation.
+	p.Unchain(r)
+	sn := solicitedNodeAddress(&dst)
+	pkt := (*ethernetPacket)(r.Data())
+	pkt.set(NeighborSolicitation, 0, &src, &sn, &dst, OptionSourceLinkLayerAddress, &ea)
+	r.SetDataLen(ethernetPacketBytes)
+
+	// Rewrite is to broadcast address; solicitations go to solicited node multicast address.
+	vnet.PerformRewrite(r, rw)
+	ethernet.GetHeader(r).Dst = ethernetMulticastAddress(&sn)
+	r.Si = rw.Si
+	n.CountError(glean_error_solicitations_sent, 1)
+	return uint(rw.NextIndex)
+}
+
+func (n *gleanNode) NodeInput(in *vnet.RefIn, o *vnet.RefOut) {
+	for i := uint(0); i < in.Len(); i++ {
+		r := &in.Refs[i]
+		x := n.gleanNext(r, in.BufferPool)
+		o.Outs[x].BufferPool = in.BufferPool
+		no := o.Outs[x].AddLen(n.Vnet)
+		o.Outs[x].Refs[no] = *r
+	}
+}
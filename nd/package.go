@@ -0,0 +1,36 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nd
+
+import (
+	"github.com/platinasystems/vnet"
+	"github.com/platinasystems/vnet/ethernet"
+	"github.com/platinasystems/vnet/ip6"
+)
+
+var packageIndex uint
+
+type Main struct {
+	vnet.Package
+	em *ethernet.Main
+	im *ip6.Main
+	nodeMain
+}
+
+func Init(v *vnet.Vnet) {
+	m := &Main{}
+	packageIndex = v.AddPackage("nd", m)
+	m.DependsOn("ethernet", "ip6")
+}
+
+func GetMain(v *vnet.Vnet) *Main { return v.GetPackage(packageIndex).(*Main) }
+
+func (m *Main) Init() (err error) {
+	v := m.Vnet
+	m.em = ethernet.GetMain(v)
+	m.im = ip6.GetMain(v)
+	m.nodeInit(v)
+	return
+}
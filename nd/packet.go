@@ -0,0 +1,146 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// IPv6 neighbor discovery (RFC 4861).
+package nd
+
+import (
+	"github.com/platinasystems/elib"
+	"github.com/platinasystems/vnet"
+	"github.com/platinasystems/vnet/ethernet"
+	"github.com/platinasystems/vnet/ip"
+	"github.com/platinasystems/vnet/ip6"
+
+	"unsafe"
+)
+
+type Icmp6Type uint8
+
+const (
+	RouterSolicitation    Icmp6Type = 133
+	RouterAdvertisement   Icmp6Type = 134
+	NeighborSolicitation  Icmp6Type = 135
+	NeighborAdvertisement Icmp6Type = 136
+	Redirect              Icmp6Type = 137
+)
+
+var icmp6TypeStrings = [...]string{
+	RouterSolicitation:    "router-solicitation",
+	RouterAdvertisement:   "router-advertisement",
+	NeighborSolicitation:  "neighbor-solicitation",
+	NeighborAdvertisement: "neighbor-advertisement",
+	Redirect:              "redirect",
+}
+
+func (x Icmp6Type) String() string { return elib.StringerWithFormat(icmp6TypeStrings[:], int(x), "%d") }
+
+// Neighbor advertisement flags.
+const (
+	AdvertisementRouter    = 1 << 31
+	AdvertisementSolicited = 1 << 30
+	AdvertisementOverride  = 1 << 29
+)
+
+// Option types.
+const (
+	OptionSourceLinkLayerAddress = 1
+	OptionTargetLinkLayerAddress = 2
+)
+
+type Icmp6Header struct {
+	Type     Icmp6Type
+	Code     uint8
+	Checksum vnet.Uint16
+}
+
+// Header for neighbor solicitation and advertisement.
+type Header struct {
+	Icmp6Header
+
+	// Advertisement flags; reserved for solicitations.
+	Flags vnet.Uint32
+
+	// Address being solicited or advertised.
+	Target ip6.Address
+}
+
+const HeaderBytes = 24
+
+// Option header.
+type OptionHeader struct {
+	Type uint8
+	// Length of option in units of 8 bytes.
+	Len uint8
+}
+
+// Source/target link layer address option for ethernet.
+type EthernetLinkLayerAddressOption struct {
+	OptionHeader
+	Ethernet ethernet.Address
+}
+
+const EthernetLinkLayerAddressOptionBytes = 8
+
+// Solicitation or advertisement with a single link layer address option as sent by us.
+type ethernetPacket struct {
+	ip     ip6.Header
+	nd     Header
+	option EthernetLinkLayerAddressOption
+}
+
+const ethernetPacketBytes = ip6.HeaderBytes + HeaderBytes + EthernetLinkLayerAddressOptionBytes
+
+// Required hop limit for all neighbor discovery packets.
+const hopLimit = 255
+
+func GetHeader(r *vnet.Ref) *Header {
+	return (*Header)(elib.PointerAdd(r.Data(), ip6.HeaderBytes))
+}
+
+func checksumAdd(c ip.Checksum, p unsafe.Pointer, n uintptr) ip.Checksum {
+	i := uintptr(0)
+	for ; i+2 <= n; i += 2 {
+		c = c.AddWithCarry(ip.Checksum(*(*uint16)(elib.PointerAdd(p, i))))
+	}
+	if i < n {
+		var b [2]uint8
+		b[0] = *(*uint8)(elib.PointerAdd(p, i))
+		c = c.AddWithCarry(ip.Checksum(*(*uint16)(unsafe.Pointer(&b[0]))))
+	}
+	return c
+}
+
+// Icmp6 checksum including ip6 pseudo header for message of n bytes following ip6 header.
+// Returns zero when checksum in message is correct.
+func checksum(h *ip6.Header, n uint) vnet.Uint16 {
+	var pseudo struct {
+		len      vnet.Uint32
+		protocol vnet.Uint32
+	}
+	pseudo.len = vnet.Uint32(n).FromHost()
+	pseudo.protocol = vnet.Uint32(ip.ICMP6).FromHost()
+	c := ip.Checksum(0)
+	c = checksumAdd(c, unsafe.Pointer(&h.Src[0]), 2*ip6.AddressBytes)
+	c = checksumAdd(c, unsafe.Pointer(&pseudo), unsafe.Sizeof(pseudo))
+	c = checksumAdd(c, elib.PointerAdd(unsafe.Pointer(h), ip6.HeaderBytes), uintptr(n))
+	return ^c.Fold()
+}
+
+// Solicited node multicast address ff02::1:ffXX:XXXX for given address.
+func solicitedNodeAddress(a *ip6.Address) (s ip6.Address) {
+	s[0], s[1] = 0xff, 0x02
+	s[11], s[12] = 0x01, 0xff
+	copy(s[13:], a[13:])
+	return
+}
+
+// All nodes multicast address ff02::1.
+var allNodesAddress = ip6.Address{0: 0xff, 1: 0x02, 15: 0x01}
+
+// Ethernet multicast address 33:33:XX:XX:XX:XX for ip6 multicast address.
+func ethernetMulticastAddress(a *ip6.Address) (e ethernet.Address) {
+	e[0], e[1] = 0x33, 0x33
+	copy(e[2:], a[12:])
+	return
+}
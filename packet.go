@@ -19,3 +19,17 @@ const (
 type Arper interface {
 	SupportsArp()
 }
+
+// Interface defines L2HeaderBytes method to give size of layer 2 header for packets
+// received on given software interface.  Used to restore layer 2 header before punting
+// packets which have already been advanced to layer 3.
+type L2Headerer interface {
+	L2HeaderBytes(v *Vnet, si Si) uint
+}
+
+// Restore layer 2 header of a packet which has been advanced to its layer 3 header.
+func (v *Vnet) RestoreL2Header(r *Ref) {
+	if h, ok := v.HwIfer(v.SupHi(r.Si)).(L2Headerer); ok {
+		r.Advance(-int(h.L2HeaderBytes(v, r.Si)))
+	}
+}
@@ -9,11 +9,14 @@ import (
 	"github.com/platinasystems/netlink"
 	"github.com/platinasystems/vnet"
 	"github.com/platinasystems/vnet/ethernet"
+	"github.com/platinasystems/vnet/ip"
 	"github.com/platinasystems/vnet/ip4"
 	"github.com/platinasystems/vnet/ip6"
 
 	"fmt"
+	"strings"
 	"sync"
+	"unsafe"
 )
 
 type netlinkMain struct {
@@ -23,11 +26,28 @@ type netlinkMain struct {
 	c         chan netlink.Message
 	e         *netlinkEvent
 	eventPool sync.Pool
+
+	// Generation of dumps requested by listen; messages are tagged with generation when received.
+	resync         chan uint
+	dumpGeneration uint
+
+	// Next hops of routes learned from netlink.
+	// Used to find next hops to remove when route is replaced.
+	routes map[routeKey][]routeNextHop
+
+	// Route tables of linux VRF devices indexed by ifindex.
+	// Interfaces enslaved to a VRF (IFLA_MASTER) are put in its table.
+	vrfTableByIndex map[uint32]ip.FibId
+
+	netlinkResync
+	netlinkTx
 }
 
 // Ignore non-tuntap interfaces (e.g. eth0).
 func (m *Main) getInterface(ifindex uint32) (intf *Interface) {
+	m.ifByIndexMu.RLock()
 	intf = m.ifByIndex[int(ifindex)]
+	m.ifByIndexMu.RUnlock()
 	return
 }
 func (m *Main) knownInterface(i uint32) bool { return nil != m.getInterface(i) }
@@ -36,15 +56,28 @@ func (m *Main) msgGeneratesEvent(msg netlink.Message) (ok bool) {
 	ok = true
 	switch v := msg.(type) {
 	case *netlink.IfInfoMessage:
-		ok = m.knownInterface(v.Index)
+		_, isVrf := vrfTable(v)
+		ok = isVrf || m.knownInterface(v.Index)
 	case *netlink.IfAddrMessage:
 		ok = m.knownInterface(v.Index)
 	case *netlink.RouteMessage:
-		ok = m.knownInterface(uint32(v.Attrs[netlink.RTA_OIF].(netlink.Uint32Attr)))
+		if _, isSpecial := specialRouteLookupNext(v); isSpecial {
+			// Special routes have no output interface.
+			break
+		}
+		if t := v.Attrs[netlink.RTA_MULTIPATH]; t != nil {
+			// Multipath routes have output interface for each next hop.
+			ok = false
+			for _, nh := range multipathNextHops(t) {
+				ok = ok || m.knownInterface(nh.ifindex)
+			}
+			break
+		}
+		ok = m.ifAttr(v.Attrs[netlink.RTA_OIF]) != nil
 	case *netlink.NeighborMessage:
 		ok = m.knownInterface(v.Index)
 	case *netlink.DoneMessage:
-		ok = false // ignore done messages
+		// Done messages end dumps requested by resync.
 	default:
 		panic("unknown netlink message")
 	}
@@ -67,9 +100,13 @@ func (m *Main) addMsg(msg netlink.Message) {
 func (m *Main) listener(l *loop.Loop) {
 	nm := &m.netlinkMain
 	for {
-		// Block until next message.
-		msg := <-nm.c
-		m.addMsg(msg)
+		// Block until next message or start of resync.
+		select {
+		case msg := <-nm.c:
+			m.addMsg(msg)
+		case g := <-nm.resync:
+			m.startDumps(g)
+		}
 
 		// Read any remaining messages without blocking.
 	loop:
@@ -87,14 +124,33 @@ func (m *Main) listener(l *loop.Loop) {
 	}
 }
 
+// Start of dumps with given generation: messages from earlier dumps still queued are added to events
+// of their generation before event marking start of resync.
+func (m *Main) startDumps(g uint) {
+	nm := &m.netlinkMain
+loop:
+	for {
+		select {
+		case msg := <-nm.c:
+			m.addMsg(msg)
+		default:
+			break loop
+		}
+	}
+	nm.e.add()
+	nm.dumpGeneration = g
+	nm.getEvent().isResyncStart = true
+}
+
 func (nm *netlinkMain) LoopInit(l *loop.Loop) {
 	var err error
 	nm.c = make(chan netlink.Message, 64)
+	nm.resync = make(chan uint)
 	nm.s, err = netlink.New(nm.c)
 	if err != nil {
 		panic(err)
 	}
-	go nm.s.Listen()
+	go nm.listen()
 	go nm.m.listener(l)
 }
 
@@ -109,6 +165,10 @@ func (nm *netlinkMain) Init(m *Main) (err error) {
 type netlinkEvent struct {
 	m    *Main
 	msgs []netlink.Message
+	// Set for event marking start of resync.
+	isResyncStart bool
+	// Generation of dumps when messages were received.
+	generation uint
 }
 
 func (m *netlinkMain) newEvent() interface{} {
@@ -118,11 +178,12 @@ func (m *netlinkMain) newEvent() interface{} {
 func (m *netlinkMain) getEvent() *netlinkEvent {
 	if m.e == nil {
 		m.e = m.eventPool.Get().(*netlinkEvent)
+		m.e.generation = m.dumpGeneration
 	}
 	return m.e
 }
 func (e *netlinkEvent) add() {
-	if len(e.msgs) > 0 {
+	if e != nil && (len(e.msgs) > 0 || e.isResyncStart) {
 		e.m.AddEvent(e, e.m)
 		e.m.e = nil
 	}
@@ -131,6 +192,7 @@ func (e *netlinkEvent) put() {
 	if len(e.msgs) > 0 {
 		e.msgs = e.msgs[:0]
 	}
+	e.isResyncStart = false
 	e.m.eventPool.Put(e)
 }
 
@@ -175,19 +237,24 @@ func (e *netlinkEvent) String() (s string) {
 }
 
 func (e *netlinkEvent) EventAction() {
-	var err error
-	vn := e.m.v
-	known := false
+	if e.isResyncStart {
+		e.m.startResync(e.generation)
+	}
+	e.m.isNetlinkEvent = true
+	defer func() { e.m.isNetlinkEvent = false }()
 	for _, msg := range e.msgs {
+		var err error
+		known := false
 		if e.m.verboseNetlink {
 			e.m.v.Logf("netlink %s\n", msg)
 		}
 		switch v := msg.(type) {
+		case *netlink.DoneMessage:
+			known = true
+			e.m.dumpDone(e.generation)
 		case *netlink.IfInfoMessage:
 			known = true
-			intf := e.m.getInterface(v.Index)
-			// Respect flag admin state changes from unix shell via ifconfig or "ip link" commands.
-			err = intf.si.SetAdminUp(vn, v.IfInfomsg.Flags&netlink.IFF_UP != 0)
+			err = e.m.ifInfoMsg(v)
 		case *netlink.IfAddrMessage:
 			switch v.Family {
 			case netlink.AF_INET:
@@ -266,12 +333,252 @@ func (m *Main) ifAttr(t netlink.Attr) (intf *Interface) {
 	return
 }
 
+// Linux route table ids which map to default table.
+const (
+	linuxTableUnspec  = 0
+	linuxTableDefault = 253
+	linuxTableMain    = 254
+)
+
+// Route table of route message.  Ids larger than 255 are only given by RTA_TABLE attribute.
+func routeTable(v *netlink.RouteMessage) ip.FibId {
+	id := uint32(v.Table)
+	if t := v.Attrs[netlink.RTA_TABLE]; t != nil {
+		id = t.(netlink.Uint32Attr).Uint()
+	}
+	switch id {
+	case linuxTableUnspec, linuxTableDefault, linuxTableMain:
+		return 0
+	}
+	return ip.FibId(id)
+}
+
+// Netlink attributes write their payload with Set.
+type netlinkAttrPayload interface {
+	Size() int
+	Set([]byte)
+}
+
+// Linux struct rtnexthop: next hop of RTA_MULTIPATH followed by its own attributes.
+type rtnexthop struct {
+	len     uint16
+	flags   uint8
+	hops    uint8
+	ifindex uint32
+}
+
+// Linux struct rtattr.
+type rtattr struct {
+	len  uint16
+	kind uint16
+}
+
+const (
+	sizeofRtnexthop = 8
+	sizeofRtattr    = 4
+)
+
+// Next lengths are aligned to 4 bytes.
+func rtaNext(b []byte, l int) []byte {
+	if l = (l + 3) &^ 3; l > len(b) {
+		l = len(b)
+	}
+	return b[l:]
+}
+
+// Calls f for each attribute in b with its kind and payload.
+func foreachRtattr(b []byte, f func(kind uint16, payload []byte)) {
+	for len(b) >= sizeofRtattr {
+		rta := (*rtattr)(unsafe.Pointer(&b[0]))
+		l := int(rta.len)
+		if l < sizeofRtattr || l > len(b) {
+			break
+		}
+		f(rta.kind, b[sizeofRtattr:l])
+		b = rtaNext(b, l)
+	}
+}
+
+// Linux IFLA_LINKINFO nested attributes.
+const (
+	iflaInfoKind = 1
+	iflaInfoData = 2
+	// Within IFLA_INFO_DATA of VRF devices.
+	iflaVrfTable = 1
+)
+
+// Returns route table of linux VRF device from IFLA_LINKINFO attribute of link message.
+func vrfTable(v *netlink.IfInfoMessage) (id ip.FibId, ok bool) {
+	p, isPayload := v.Attrs[netlink.IFLA_LINKINFO].(netlinkAttrPayload)
+	if !isPayload {
+		return
+	}
+	b := make([]byte, p.Size())
+	p.Set(b)
+	var data []byte
+	isVrf := false
+	foreachRtattr(b, func(kind uint16, payload []byte) {
+		switch kind {
+		case iflaInfoKind:
+			isVrf = strings.TrimRight(string(payload), "\x00") == "vrf"
+		case iflaInfoData:
+			data = payload
+		}
+	})
+	if !isVrf {
+		return
+	}
+	foreachRtattr(data, func(kind uint16, payload []byte) {
+		if kind == iflaVrfTable && len(payload) >= 4 {
+			id, ok = ip.FibId(*(*uint32)(unsafe.Pointer(&payload[0]))), true
+		}
+	})
+	return
+}
+
+// Moves interface to route table of VRF it is enslaved to or to default table when it has no VRF master
+// (or master is not known to be a VRF).
+func (m *Main) setInterfaceVrf(intf *Interface) (err error) {
+	id := m.vrfTableByIndex[intf.vrfIndex]
+	if err = ip4.GetMain(m.v).SetInterfaceTable(intf.si, id); err != nil {
+		return
+	}
+	err = ip6.GetMain(m.v).SetInterfaceTable(intf.si, id)
+	return
+}
+
+// Records table of VRF device and moves interfaces enslaved to VRF (possibly before it was known) to its table.
+func (m *Main) vrfMsg(v *netlink.IfInfoMessage, id ip.FibId) (err error) {
+	if v.Header.Type == netlink.RTM_DELLINK {
+		delete(m.vrfTableByIndex, v.Index)
+	} else {
+		if m.vrfTableByIndex == nil {
+			m.vrfTableByIndex = make(map[uint32]ip.FibId)
+		}
+		m.vrfTableByIndex[v.Index] = id
+	}
+	for _, intf := range m.ifVec {
+		if intf != nil && intf.vrfIndex == v.Index {
+			if err = m.setInterfaceVrf(intf); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+type multipathNextHop struct {
+	ifindex uint32
+	gateway []byte
+	weight  ip.NextHopWeight
+}
+
+// Decodes RTA_MULTIPATH attribute.  Next hop weight is rtnh_hops + 1 as with "ip route ... nexthop weight W".
+func multipathNextHops(t netlink.Attr) (nhs []multipathNextHop) {
+	p, ok := t.(netlinkAttrPayload)
+	if !ok {
+		return
+	}
+	b := make([]byte, p.Size())
+	p.Set(b)
+	return decodeMultipath(b)
+}
+
+// Decodes payload of RTA_MULTIPATH attribute: a sequence of struct rtnexthop each followed by its attributes.
+// Decoding stops at first malformed next hop.
+func decodeMultipath(b []byte) (nhs []multipathNextHop) {
+	for len(b) >= sizeofRtnexthop {
+		rtnh := (*rtnexthop)(unsafe.Pointer(&b[0]))
+		l := int(rtnh.len)
+		if l < sizeofRtnexthop || l > len(b) {
+			break
+		}
+		nh := multipathNextHop{
+			ifindex: rtnh.ifindex,
+			weight:  ip.NextHopWeight(rtnh.hops) + 1,
+		}
+		foreachRtattr(b[sizeofRtnexthop:l], func(kind uint16, payload []byte) {
+			if kind == uint16(netlink.RTA_GATEWAY) {
+				nh.gateway = payload
+			}
+		})
+		nhs = append(nhs, nh)
+		b = rtaNext(b, l)
+	}
+	return
+}
+
+// Lookup next for routes which do not forward packets.
+func specialRouteLookupNext(v *netlink.RouteMessage) (next ip.LookupNext, ok bool) {
+	ok = true
+	switch v.Rtmsg.Type {
+	case netlink.RTN_BLACKHOLE:
+		next = ip.LookupNextDrop
+	case netlink.RTN_UNREACHABLE:
+		next = ip.LookupNextUnreachable
+	case netlink.RTN_PROHIBIT:
+		next = ip.LookupNextProhibit
+	default:
+		ok = false
+	}
+	return
+}
+
+func (m *Main) ifInfoMsg(v *netlink.IfInfoMessage) (err error) {
+	if id, ok := vrfTable(v); ok {
+		return m.vrfMsg(v, id)
+	}
+	intf := m.getInterface(v.Index)
+	if intf == nil {
+		// Deleted by an earlier message of same event.
+		return
+	}
+	if v.Header.Type == netlink.RTM_DELLINK {
+		m.delInterface(intf)
+		return
+	}
+	// Respect flag admin state changes from unix shell via ifconfig or "ip link" commands.
+	// Carrier (lower up) is set by vnet from hardware link state and so is ignored here.
+	if err = intf.si.SetAdminUp(m.v, v.IfInfomsg.Flags&netlink.IFF_UP != 0); err != nil {
+		return
+	}
+	// Likewise for MTU, ethernet address and name.
+	if t := v.Attrs[netlink.IFLA_MTU]; t != nil {
+		if err = intf.changeMtu(uint(t.(netlink.Uint32Attr).Uint())); err != nil {
+			return
+		}
+	}
+	if t := v.Attrs[netlink.IFLA_ADDRESS]; t != nil && !m.isTun {
+		if err = intf.changeAddress(ethernetAddress(t)); err != nil {
+			return
+		}
+	}
+	if t := v.Attrs[netlink.IFLA_IFNAME]; t != nil {
+		intf.rename(t.(netlink.StringAttr).String())
+	}
+	// Only changes of VRF master move interface so that tables set from vnet are kept.
+	var vrfIndex uint32
+	if t := v.Attrs[netlink.IFLA_MASTER]; t != nil {
+		vrfIndex = t.(netlink.Uint32Attr).Uint()
+	}
+	if vrfIndex != intf.vrfIndex {
+		intf.vrfIndex = vrfIndex
+		err = m.setInterfaceVrf(intf)
+	}
+	return
+}
+
 func (m *Main) ip4IfaddrMsg(v *netlink.IfAddrMessage) (err error) {
 	p := ip4Prefix(v.Attrs[netlink.IFA_ADDRESS], v.Prefixlen)
 	m4 := ip4.GetMain(m.v)
 	intf := m.getInterface(v.Index)
+	if intf == nil {
+		// Deleted by an earlier message of same event.
+		return
+	}
 	isDel := v.Header.Type == netlink.RTM_DELADDR
 	err = m4.AddDelInterfaceAddress(intf.si, &p, isDel)
+	m.learn(ifAddrKey{family: ip.Ip4, si: intf.si, prefix: p.ToIpPrefix()}, isDel)
 	return
 }
 
@@ -291,17 +598,27 @@ func (m *Main) ip4NeighborMsg(v *netlink.NeighborMessage) (err error) {
 		isStatic = true
 	}
 	intf := m.getInterface(v.Index)
+	if intf == nil {
+		// Deleted by an earlier message of same event.
+		return
+	}
 	dst := ip4Address(v.Attrs[netlink.NDA_DST])
 	nbr := ethernet.IpNeighbor{
 		Si:       intf.si,
 		Ethernet: ethernetAddress(v.Attrs[netlink.NDA_LLADDR]),
 		Ip:       dst.ToIp(),
+		Static:   isStatic,
+	}
+	k := neighborKey{family: ip.Ip4, si: nbr.Si, ip: nbr.Ip}
+	if m.isTxNeighbor(k, &nbr.Ethernet, isDel) {
+		return
 	}
 	m4 := ip4.GetMain(m.v)
 	err = ethernet.GetMain(m.v).AddDelIpNeighbor(&m4.Main, &nbr, isDel)
+	m.learn(k, isDel)
 
-	// Ignore delete of unknown static Arp entry.
-	if err == ethernet.ErrDelUnknownNeighbor && isStatic {
+	// Ignore delete of unknown static Arp entry or of dynamic entry which has already been aged out.
+	if err == ethernet.ErrDelUnknownNeighbor {
 		err = nil
 	}
 	// not yet
@@ -317,29 +634,165 @@ func (m *Main) ip4RouteMsg(v *netlink.RouteMessage) (err error) {
 		// Ignore all except routes that are static (RTPROT_BOOT) or originating from routing-protocols.
 		return
 	}
+	p := ip4Prefix(v.Attrs[netlink.RTA_DST], v.DstLen)
+	isDel := v.Header.Type == netlink.RTM_DELROUTE
+	m4 := ip4.GetMain(m.v)
+	if next, ok := specialRouteLookupNext(v); ok {
+		k := specialRouteKey{family: ip.Ip4, table: routeTable(v), prefix: p.ToIpPrefix()}
+		fi, ok := m4.FibIndexForTable(k.table, !isDel)
+		if !ok {
+			return
+		}
+		// Replace known route (e.g. dumped again by resync).
+		if !isDel && m.isLearned(k) {
+			if err = m4.AddDelSpecialRoute(&k.prefix, fi, next, true); err != nil {
+				return
+			}
+		}
+		err = m4.AddDelSpecialRoute(&k.prefix, fi, next, isDel)
+		m.learn(k, isDel)
+		return
+	}
 	if v.Rtmsg.Type != netlink.RTN_UNICAST {
 		return
 	}
-	p := ip4Prefix(v.Attrs[netlink.RTA_DST], v.DstLen)
+	k := routeKey{family: ip.Ip4, table: routeTable(v), prefix: p.ToIpPrefix()}
+	nhs := m.routeNextHops(v, k.family)
+	if false {
+		fmt.Printf("route isDel %v, %s -> %+v %s\n", isDel, &p, nhs, err)
+	}
+	// Routes dumped by resync replace any next hops lost while out of sync.
+	isReplace := m.isResync || v.Header.Flags&netlink.NLM_F_REPLACE != 0
+	err = m.addDelRoute(k, nhs, isDel, isReplace)
+	return
+}
+
+// Routes learned from netlink are identified by family, table and prefix.
+type routeKey struct {
+	family ip.Family
+	table  ip.FibId
+	prefix ip.Prefix
+}
+
+// Next hops of a route are identified by interface and gateway address.
+type nextHopKey struct {
+	si      vnet.Si
+	address ip.Address
+}
+
+type routeNextHop struct {
+	nextHopKey
+	weight ip.NextHopWeight
+}
+
+// Next hops of route message.  Next hops via non-tuntap interfaces (or interfaces deleted by an
+// earlier message of same event) are ignored.
+func (m *Main) routeNextHops(v *netlink.RouteMessage, family ip.Family) (nhs []routeNextHop) {
+	if t := v.Attrs[netlink.RTA_MULTIPATH]; t != nil {
+		for _, x := range multipathNextHops(t) {
+			intf := m.getInterface(x.ifindex)
+			if intf == nil {
+				continue
+			}
+			nh := routeNextHop{weight: x.weight}
+			nh.si = intf.si
+			copy(nh.address[:], x.gateway)
+			nhs = append(nhs, nh)
+		}
+		return
+	}
 	intf := m.ifAttr(v.Attrs[netlink.RTA_OIF])
-	nh := ip4.NextHop{
-		Si:      vnet.SiNil,
-		Address: ip4Address(v.Attrs[netlink.RTA_GATEWAY]),
-		// FIXME: Not sure how netlink specifies nexthop weight.
-		Weight: 1,
+	if intf == nil {
+		return
 	}
-	if intf != nil {
-		nh.Si = intf.si
+	nh := routeNextHop{weight: 1}
+	nh.si = intf.si
+	if family == ip.Ip4 {
+		a := ip4Address(v.Attrs[netlink.RTA_GATEWAY])
+		nh.address = a.ToIp()
+	} else {
+		a := ip6Address(v.Attrs[netlink.RTA_GATEWAY])
+		nh.address = a.ToIp()
 	}
-	isDel := v.Header.Type == netlink.RTM_DELROUTE
-	if false {
-		fmt.Printf("route if %s, isDel %v, %s -> %+v %s\n", intf, isDel, &p, &nh, err)
+	nhs = append(nhs, nh)
+	return
+}
+
+func nextHopIndex(nhs []routeNextHop, k *nextHopKey) int {
+	for i := range nhs {
+		if nhs[i].nextHopKey == *k {
+			return i
+		}
+	}
+	return -1
+}
+
+// Adds/deletes next hops of route.  For replace next hops not in new set are deleted.
+// Next hops are added before others are deleted so that route stays up while it has a path.
+func (m *Main) addDelRoute(k routeKey, nhs []routeNextHop, isDel, isReplace bool) (err error) {
+	old := m.routes[k]
+	var adds, dels, cur []routeNextHop
+	switch {
+	case isDel:
+		dels = nhs
+		for i := range old {
+			if nextHopIndex(dels, &old[i].nextHopKey) < 0 {
+				cur = append(cur, old[i])
+			}
+		}
+	case isReplace:
+		adds, cur = nhs, nhs
+		for i := range old {
+			if nextHopIndex(nhs, &old[i].nextHopKey) < 0 {
+				dels = append(dels, old[i])
+			}
+		}
+	default:
+		adds = nhs
+		cur = append(cur, old...)
+		for i := range nhs {
+			if j := nextHopIndex(cur, &nhs[i].nextHopKey); j >= 0 {
+				cur[j] = nhs[i]
+			} else {
+				cur = append(cur, nhs[i])
+			}
+		}
+	}
+
+	for i := range adds {
+		if e := m.addDelRouteNextHop(&k, &adds[i], false); e != nil && err == nil {
+			err = e
+		}
+	}
+	for i := range dels {
+		if e := m.addDelRouteNextHop(&k, &dels[i], true); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	m.learn(k, len(cur) == 0)
+	if len(cur) == 0 {
+		delete(m.routes, k)
+	} else {
+		if m.routes == nil {
+			m.routes = make(map[routeKey][]routeNextHop)
+		}
+		m.routes[k] = cur
 	}
-	m4 := ip4.GetMain(m.v)
-	err = m4.AddDelRouteNextHop(&p, &nh, isDel)
 	return
 }
 
+func (m *Main) addDelRouteNextHop(k *routeKey, nh *routeNextHop, isDel bool) error {
+	if k.family == ip.Ip4 {
+		p := ip4.FromIp4Prefix(&k.prefix)
+		x := ip4.NextHop{Address: *ip4.IpAddress(&nh.address), Si: nh.si, Weight: nh.weight}
+		return ip4.GetMain(m.v).AddDelRouteNextHopTable(k.table, &p, &x, isDel)
+	}
+	p := ip6.FromIp6Prefix(&k.prefix)
+	x := ip6.NextHop{Address: *ip6.IpAddress(&nh.address), Si: nh.si, Weight: nh.weight}
+	return ip6.GetMain(m.v).AddDelRouteNextHopTable(k.table, &p, &x, isDel)
+}
+
 func ip6Prefix(t netlink.Attr, l uint8) (p ip6.Prefix) {
 	p.Len = uint32(l)
 	if t != nil {
@@ -351,7 +804,104 @@ func ip6Prefix(t netlink.Attr, l uint8) (p ip6.Prefix) {
 	return
 }
 
-// not yet
-func (m *Main) ip6IfaddrMsg(v *netlink.IfAddrMessage) (err error)     { return }
-func (m *Main) ip6NeighborMsg(v *netlink.NeighborMessage) (err error) { return }
-func (m *Main) ip6RouteMsg(v *netlink.RouteMessage) (err error)       { return }
+func ip6Address(t netlink.Attr) (a ip6.Address) {
+	if t != nil {
+		b := t.(*netlink.Ip6Address)
+		for i := range b {
+			a[i] = b[i]
+		}
+	}
+	return
+}
+
+func (m *Main) ip6IfaddrMsg(v *netlink.IfAddrMessage) (err error) {
+	p := ip6Prefix(v.Attrs[netlink.IFA_ADDRESS], v.Prefixlen)
+	m6 := ip6.GetMain(m.v)
+	intf := m.getInterface(v.Index)
+	if intf == nil {
+		// Deleted by an earlier message of same event.
+		return
+	}
+	isDel := v.Header.Type == netlink.RTM_DELADDR
+	err = m6.AddDelInterfaceAddress(intf.si, &p, isDel)
+	m.learn(ifAddrKey{family: ip.Ip6, si: intf.si, prefix: p.ToIpPrefix()}, isDel)
+	return
+}
+
+func (m *Main) ip6NeighborMsg(v *netlink.NeighborMessage) (err error) {
+	if v.Ndmsg.Type != netlink.RTN_UNICAST {
+		return
+	}
+	isDel := v.Header.Type == netlink.RTM_DELNEIGH
+	isStatic := false
+	switch v.State {
+	case netlink.NUD_NOARP, netlink.NUD_NONE:
+		// ignore these
+		return
+	case netlink.NUD_FAILED:
+		isDel = true
+	case netlink.NUD_PERMANENT:
+		isStatic = true
+	}
+	intf := m.getInterface(v.Index)
+	if intf == nil {
+		// Deleted by an earlier message of same event.
+		return
+	}
+	dst := ip6Address(v.Attrs[netlink.NDA_DST])
+	nbr := ethernet.IpNeighbor{
+		Si:       intf.si,
+		Ethernet: ethernetAddress(v.Attrs[netlink.NDA_LLADDR]),
+		Ip:       dst.ToIp(),
+		Static:   isStatic,
+	}
+	k := neighborKey{family: ip.Ip6, si: nbr.Si, ip: nbr.Ip}
+	if m.isTxNeighbor(k, &nbr.Ethernet, isDel) {
+		return
+	}
+	m6 := ip6.GetMain(m.v)
+	err = ethernet.GetMain(m.v).AddDelIpNeighbor(&m6.Main, &nbr, isDel)
+	m.learn(k, isDel)
+
+	// Ignore delete of unknown static neighbor entry or of dynamic entry which has already been aged out.
+	if err == ethernet.ErrDelUnknownNeighbor {
+		err = nil
+	}
+	return
+}
+
+func (m *Main) ip6RouteMsg(v *netlink.RouteMessage) (err error) {
+	switch v.Protocol {
+	case netlink.RTPROT_KERNEL, netlink.RTPROT_REDIRECT:
+		// Ignore all except routes that are static (RTPROT_BOOT) or originating from routing-protocols.
+		return
+	}
+	p := ip6Prefix(v.Attrs[netlink.RTA_DST], v.DstLen)
+	isDel := v.Header.Type == netlink.RTM_DELROUTE
+	m6 := ip6.GetMain(m.v)
+	if next, ok := specialRouteLookupNext(v); ok {
+		k := specialRouteKey{family: ip.Ip6, table: routeTable(v), prefix: p.ToIpPrefix()}
+		fi, ok := m6.FibIndexForTable(k.table, !isDel)
+		if !ok {
+			return
+		}
+		// Replace known route (e.g. dumped again by resync).
+		if !isDel && m.isLearned(k) {
+			if err = m6.AddDelSpecialRoute(&k.prefix, fi, next, true); err != nil {
+				return
+			}
+		}
+		err = m6.AddDelSpecialRoute(&k.prefix, fi, next, isDel)
+		m.learn(k, isDel)
+		return
+	}
+	if v.Rtmsg.Type != netlink.RTN_UNICAST {
+		return
+	}
+	k := routeKey{family: ip.Ip6, table: routeTable(v), prefix: p.ToIpPrefix()}
+	nhs := m.routeNextHops(v, k.family)
+	// Routes dumped by resync replace any next hops lost while out of sync.
+	isReplace := m.isResync || v.Header.Flags&netlink.NLM_F_REPLACE != 0
+	err = m.addDelRoute(k, nhs, isDel, isReplace)
+	return
+}
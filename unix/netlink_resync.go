@@ -0,0 +1,160 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package unix
+
+import (
+	"github.com/platinasystems/netlink"
+	"github.com/platinasystems/vnet"
+	"github.com/platinasystems/vnet/ethernet"
+	"github.com/platinasystems/vnet/ip"
+	"github.com/platinasystems/vnet/ip4"
+	"github.com/platinasystems/vnet/ip6"
+
+	"syscall"
+)
+
+// Dumps requested at startup and after socket overflow to learn existing kernel state.
+var netlinkDumpReqs = []netlink.ListenReq{
+	{MsgType: netlink.RTM_GETLINK, AddressFamily: netlink.AF_UNSPEC},
+	{MsgType: netlink.RTM_GETADDR, AddressFamily: netlink.AF_INET},
+	{MsgType: netlink.RTM_GETADDR, AddressFamily: netlink.AF_INET6},
+	{MsgType: netlink.RTM_GETNEIGH, AddressFamily: netlink.AF_INET},
+	{MsgType: netlink.RTM_GETNEIGH, AddressFamily: netlink.AF_INET6},
+	{MsgType: netlink.RTM_GETROUTE, AddressFamily: netlink.AF_INET},
+	{MsgType: netlink.RTM_GETROUTE, AddressFamily: netlink.AF_INET6},
+}
+
+// Keys of state learned from netlink in addition to routeKey.
+type ifAddrKey struct {
+	family ip.Family
+	si     vnet.Si
+	prefix ip.Prefix
+}
+
+type neighborKey struct {
+	family ip.Family
+	si     vnet.Si
+	ip     ip.Address
+}
+
+type specialRouteKey struct {
+	family ip.Family
+	table  ip.FibId
+	prefix ip.Prefix
+}
+
+// Resync dumps kernel state and reconciles it with state learned before.
+// Entries learned from netlink are marked with current generation when seen; after all dumps
+// are done entries of older generations (i.e. not confirmed by dump) are deleted.
+type netlinkResync struct {
+	generation      uint
+	generationByKey map[interface{}]uint
+
+	// Set from start of resync until all dumps are done.
+	isResync   bool
+	nDumpsDone uint
+}
+
+// Listen for netlink messages.  Receive buffer overflow (ENOBUFS) means messages have been lost
+// so kernel state is dumped again.
+func (nm *netlinkMain) listen() {
+	for g := uint(1); ; g++ {
+		// Mark start of resync before dumps are requested.  All messages of earlier dumps have been
+		// queued by now, so listener marks them as older than this generation.
+		nm.resync <- g
+
+		err := nm.s.Listen(netlinkDumpReqs...)
+		if err != syscall.ENOBUFS {
+			if err != nil {
+				nm.m.v.Logf("netlink listen: %s\n", err)
+			}
+			return
+		}
+		nm.m.v.Logf("netlink overflow: resync\n")
+	}
+}
+
+func (m *netlinkResync) learn(k interface{}, isDel bool) {
+	if isDel {
+		delete(m.generationByKey, k)
+		return
+	}
+	if m.generationByKey == nil {
+		m.generationByKey = make(map[interface{}]uint)
+	}
+	m.generationByKey[k] = m.generation
+}
+
+func (m *netlinkResync) isLearned(k interface{}) (ok bool) {
+	_, ok = m.generationByKey[k]
+	return
+}
+
+func (m *netlinkResync) startResync(g uint) {
+	m.generation = g
+	m.isResync = true
+	m.nDumpsDone = 0
+}
+
+// Done message ends a dump of given generation.  Done messages of dumps interrupted by overflow
+// may still arrive after next resync has started; these are ignored.
+func (m *Main) dumpDone(g uint) {
+	if !m.isResync || g != m.generation {
+		return
+	}
+	if m.nDumpsDone++; m.nDumpsDone >= uint(len(netlinkDumpReqs)) {
+		m.sweep()
+		m.isResync = false
+	}
+}
+
+// Delete entries not confirmed by resync.
+func (m *Main) sweep() {
+	for k, g := range m.generationByKey {
+		if g == m.generation {
+			continue
+		}
+		if err := m.forget(k); err != nil {
+			m.v.Logf("netlink resync %+v: %s\n", k, err)
+		}
+		delete(m.generationByKey, k)
+	}
+}
+
+func (m *Main) forget(key interface{}) (err error) {
+	m4, m6 := ip4.GetMain(m.v), ip6.GetMain(m.v)
+	switch k := key.(type) {
+	case routeKey:
+		err = m.addDelRoute(k, m.routes[k], true, false)
+	case specialRouteKey:
+		if k.family == ip.Ip4 {
+			if fi, ok := m4.FibIndexForTable(k.table, false); ok {
+				err = m4.AddDelSpecialRoute(&k.prefix, fi, ip.LookupNextDrop, true)
+			}
+		} else {
+			if fi, ok := m6.FibIndexForTable(k.table, false); ok {
+				err = m6.AddDelSpecialRoute(&k.prefix, fi, ip.LookupNextDrop, true)
+			}
+		}
+	case ifAddrKey:
+		if k.family == ip.Ip4 {
+			p := ip4.FromIp4Prefix(&k.prefix)
+			err = m4.AddDelInterfaceAddress(k.si, &p, true)
+		} else {
+			p := ip6.FromIp6Prefix(&k.prefix)
+			err = m6.AddDelInterfaceAddress(k.si, &p, true)
+		}
+	case neighborKey:
+		im := &m4.Main
+		if k.family == ip.Ip6 {
+			im = &m6.Main
+		}
+		nbr := ethernet.IpNeighbor{Si: k.si, Ip: k.ip}
+		if err = ethernet.GetMain(m.v).AddDelIpNeighbor(im, &nbr, true); err == ethernet.ErrDelUnknownNeighbor {
+			err = nil
+		}
+	}
+	return
+}
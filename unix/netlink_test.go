@@ -0,0 +1,126 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package unix
+
+import (
+	"github.com/platinasystems/netlink"
+
+	"bytes"
+	"testing"
+	"unsafe"
+)
+
+type testRtattr struct {
+	kind    uint16
+	payload []byte
+}
+
+type testRtnexthop struct {
+	hops    uint8
+	ifindex uint32
+	attrs   []testRtattr
+	// Overrides computed rtnh_len when non-zero.
+	len int
+}
+
+// Encodes next hops as payload of RTA_MULTIPATH attribute in host byte order.
+func encodeMultipath(nhs []testRtnexthop) (b []byte) {
+	for _, x := range nhs {
+		o := len(b)
+		b = append(b, make([]byte, sizeofRtnexthop)...)
+		for _, a := range x.attrs {
+			ao := len(b)
+			b = append(b, make([]byte, sizeofRtattr)...)
+			b = append(b, a.payload...)
+			rta := (*rtattr)(unsafe.Pointer(&b[ao]))
+			rta.kind = a.kind
+			rta.len = uint16(sizeofRtattr + len(a.payload))
+			for len(b)%4 != 0 {
+				b = append(b, 0)
+			}
+		}
+		rtnh := (*rtnexthop)(unsafe.Pointer(&b[o]))
+		rtnh.len = uint16(len(b) - o)
+		if x.len != 0 {
+			rtnh.len = uint16(x.len)
+		}
+		rtnh.hops = x.hops
+		rtnh.ifindex = x.ifindex
+	}
+	return
+}
+
+func TestDecodeMultipath(t *testing.T) {
+	gw4 := []byte{10, 0, 0, 1}
+	gw4b := []byte{10, 0, 0, 2}
+	gw6 := []byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}
+	gateway := uint16(netlink.RTA_GATEWAY)
+	// Some other next hop attribute (RTA_FLOW) with a length which needs padding.
+	const other = 11
+	tests := []struct {
+		name string
+		nhs  []testRtnexthop
+		want []multipathNextHop
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "single",
+			nhs:  []testRtnexthop{{ifindex: 3, attrs: []testRtattr{{gateway, gw4}}}},
+			want: []multipathNextHop{{ifindex: 3, gateway: gw4, weight: 1}},
+		},
+		{
+			name: "weights",
+			nhs: []testRtnexthop{
+				{ifindex: 3, hops: 0, attrs: []testRtattr{{gateway, gw4}}},
+				{ifindex: 4, hops: 2, attrs: []testRtattr{{gateway, gw4b}}},
+			},
+			want: []multipathNextHop{
+				{ifindex: 3, gateway: gw4, weight: 1},
+				{ifindex: 4, gateway: gw4b, weight: 3},
+			},
+		},
+		{
+			name: "ip6 gateway after padded attribute",
+			nhs:  []testRtnexthop{{ifindex: 5, attrs: []testRtattr{{other, []byte{1, 2, 3}}, {gateway, gw6}}}},
+			want: []multipathNextHop{{ifindex: 5, gateway: gw6, weight: 1}},
+		},
+		{
+			name: "no gateway",
+			nhs:  []testRtnexthop{{ifindex: 6, hops: 1}},
+			want: []multipathNextHop{{ifindex: 6, weight: 2}},
+		},
+		{
+			name: "truncated second next hop",
+			nhs: []testRtnexthop{
+				{ifindex: 3, attrs: []testRtattr{{gateway, gw4}}},
+				{ifindex: 4, attrs: []testRtattr{{gateway, gw4b}}, len: 64},
+			},
+			want: []multipathNextHop{{ifindex: 3, gateway: gw4, weight: 1}},
+		},
+		{
+			name: "length shorter than rtnexthop",
+			nhs: []testRtnexthop{
+				{ifindex: 3},
+				{ifindex: 4, attrs: []testRtattr{{gateway, gw4}}, len: 2},
+			},
+			want: []multipathNextHop{{ifindex: 3, weight: 1}},
+		},
+	}
+	for _, x := range tests {
+		got := decodeMultipath(encodeMultipath(x.nhs))
+		if len(got) != len(x.want) {
+			t.Errorf("%s: got %d next hops, want %d", x.name, len(got), len(x.want))
+			continue
+		}
+		for i := range got {
+			g, w := &got[i], &x.want[i]
+			if g.ifindex != w.ifindex || g.weight != w.weight || !bytes.Equal(g.gateway, w.gateway) {
+				t.Errorf("%s: next hop %d: got %+v, want %+v", x.name, i, *g, *w)
+			}
+		}
+	}
+}
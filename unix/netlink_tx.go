@@ -0,0 +1,110 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package unix
+
+import (
+	"github.com/platinasystems/netlink"
+	"github.com/platinasystems/vnet/ethernet"
+	"github.com/platinasystems/vnet/ip"
+)
+
+// Neighbors learned by vnet from ARP/ND are sent to kernel so that it does not re-resolve them.
+type netlinkTx struct {
+	// Set while netlink messages are being handled; changes they cause came from kernel and are not sent back.
+	isNetlinkEvent bool
+
+	// Ethernet addresses of neighbors sent to kernel.  Kernel's notifications for these are ignored.
+	txNeighbors map[neighborKey]ethernet.Address
+
+	// Number of neighbor messages dropped since netlink socket transmit queue was full.
+	nTxNeighborDrops uint
+}
+
+// Ethernet ip neighbor add/delete hook.
+func (m *Main) ipNeighborAddDel(im *ip.Main, n *ethernet.IpNeighbor, isDel bool) {
+	// Static neighbors are configuration, not learned.
+	if m.isNetlinkEvent || n.Static || m.s == nil {
+		return
+	}
+	intf := m.interfaceForSi(n.Si)
+	if intf == nil {
+		return
+	}
+	k := neighborKey{family: im.Family, si: n.Si, ip: n.Ip}
+	if isDel {
+		if _, ok := m.txNeighbors[k]; !ok {
+			return
+		}
+		delete(m.txNeighbors, k)
+	} else {
+		if m.txNeighbors == nil {
+			m.txNeighbors = make(map[neighborKey]ethernet.Address)
+		}
+		m.txNeighbors[k] = n.Ethernet
+	}
+	if !m.txNeighbor(intf, im.Family, n, isDel) {
+		// Kernel does not have neighbor so its own notifications for it are not ours.
+		delete(m.txNeighbors, k)
+	}
+}
+
+// Sends neighbor to kernel without blocking main loop.  Returns false when message was dropped since
+// transmit queue is full; kernel then resolves neighbor itself.
+func (m *Main) txNeighbor(intf *Interface, family ip.Family, n *ethernet.IpNeighbor, isDel bool) (ok bool) {
+	msg := netlink.NewNeighborMessage()
+	if isDel {
+		msg.Header.Type = netlink.RTM_DELNEIGH
+		msg.Header.Flags = netlink.NLM_F_REQUEST
+	} else {
+		msg.Header.Type = netlink.RTM_NEWNEIGH
+		msg.Header.Flags = netlink.NLM_F_REQUEST | netlink.NLM_F_CREATE | netlink.NLM_F_REPLACE
+	}
+	msg.Index = uint32(intf.ifindex)
+	msg.State = netlink.NUD_REACHABLE
+	msg.Ndmsg.Type = netlink.RTN_UNICAST
+	if family == ip.Ip4 {
+		msg.Family = netlink.AF_INET
+		var a netlink.Ip4Address
+		copy(a[:], n.Ip[:])
+		msg.Attrs[netlink.NDA_DST] = &a
+	} else {
+		msg.Family = netlink.AF_INET6
+		var a netlink.Ip6Address
+		copy(a[:], n.Ip[:])
+		msg.Attrs[netlink.NDA_DST] = &a
+	}
+	var ea netlink.EthernetAddress
+	copy(ea[:], n.Ethernet[:])
+	msg.Attrs[netlink.NDA_LLADDR] = &ea
+	if m.verboseNetlink {
+		m.v.Logf("netlink tx %s\n", msg)
+	}
+	select {
+	case m.s.Tx <- msg:
+		ok = true
+	default:
+		msg.Close()
+		// Log first drop and then every power of 2 drops.
+		if m.nTxNeighborDrops++; m.nTxNeighborDrops&(m.nTxNeighborDrops-1) == 0 {
+			m.v.Logf("netlink tx: queue full; %d neighbor messages dropped\n", m.nTxNeighborDrops)
+		}
+	}
+	return
+}
+
+// Returns true for kernel notifications of neighbors sent by vnet.
+// Kernel aging out or failing its copy is ignored since vnet still has neighbor.
+// A different ethernet address means kernel has learned neighbor itself so it is no longer vnet's.
+func (m *Main) isTxNeighbor(k neighborKey, ea *ethernet.Address, isDel bool) bool {
+	tx, ok := m.txNeighbors[k]
+	if !ok {
+		return false
+	}
+	if isDel || tx == *ea {
+		return true
+	}
+	delete(m.txNeighbors, k)
+	return false
+}
@@ -39,12 +39,26 @@ func (nm *nodeMain) Init(m *Main) {
 type node struct {
 	ethernet.Interface
 	vnet.InterfaceNode
-	i           *Interface
+	i *Interface
+	// Queue input starts with; rotated so that no queue is starved when input vector fills.
+	rxQueue uint
+}
+
+// Tuntap queue with its own /dev/net/tun file descriptor.
+type queue struct {
+	iomux.File
+	intf *Interface
+	// Packets received from this queue.
 	rxRefs      chan rxRef
 	txRefIns    chan txRefIn
 	txRefIn     txRefIn
 	txAvailable int32
 	txIovecs    iovecVec
+	// Header written before each packet in vnet header mode.
+	txVnetHdr vnetHdr
+	// Buffers for segmenting packets received with segmentation offload.
+	gsoBuf, segHdr []byte
+	segRefs        vnet.RefVec
 }
 
 type rxNext int
@@ -58,8 +72,6 @@ func (intf *Interface) interfaceNodeInit(m *Main) {
 	vnetName := ifName + "-unix"
 	n := &intf.node
 	n.i = intf
-	n.rxRefs = make(chan rxRef, vnet.MaxVectorLen)
-	n.txRefIns = make(chan txRefIn, 64)
 	m.v.RegisterHwInterface(n, vnetName)
 	n.Next = []string{
 		rxNextTx: ifName,
@@ -68,9 +80,14 @@ func (intf *Interface) interfaceNodeInit(m *Main) {
 	ni := m.v.AddNamedNext(&m.puntNode, vnetName)
 	m.puntNode.setNext(intf.si, ni)
 
-	// Use /dev/net/tun file descriptor for input/output.
-	intf.Fd = intf.dev_net_tun_fd
-	iomux.Add(intf)
+	// Use /dev/net/tun file descriptor of each queue for input/output.
+	for _, q := range intf.queues {
+		q.rxRefs = make(chan rxRef, vnet.MaxVectorLen)
+		q.txRefIns = make(chan txRefIn, 64)
+		// Vnet never leaves partial checksums for kernel to finish; instead kernel is told not to verify checksums.
+		q.txVnetHdr.flags = vnet_hdr_f_data_valid
+		iomux.Add(q)
+	}
 }
 
 func (n *node) GetHwInterfaceCounterNames() (nm vnet.InterfaceCounterNames) { return }
@@ -103,22 +120,36 @@ type packet struct {
 	iovs  iovecVec
 	chain vnet.RefChain
 	refs  vnet.RefVec
+	// Header read into first iovec in vnet header mode.
+	vnetHdr vnetHdr
+	// Number of iovecs before those for packet data.
+	nHdrIovs uint
 }
 
 func (p *packet) allocRefs(m *Main, n uint) {
 	m.bufferPool.AllocRefs(p.refs[:n])
 	for i := uint(0); i < n; i++ {
-		p.iovs[i].Base = (*byte)(p.refs[i].Data())
-		p.iovs[i].Len = uint64(m.bufferPool.Size)
+		iov := &p.iovs[p.nHdrIovs+i]
+		iov.Base = (*byte)(p.refs[i].Data())
+		iov.Len = uint64(m.bufferPool.Size)
 	}
 }
 
 func (p *packet) initForRx(m *Main, intf *Interface) {
 	n := intf.mtuBuffers
-	p.iovs.Validate(n - 1)
+	if m.isVnetHdr {
+		p.nHdrIovs = 1
+	}
+	p.iovs.Validate(p.nHdrIovs + n - 1)
 	p.refs.Validate(n - 1)
-	p.iovs = p.iovs[:n]
+	p.iovs = p.iovs[:p.nHdrIovs+n]
 	p.refs = p.refs[:n]
+	if m.isVnetHdr {
+		p.iovs[0] = iovec{
+			Base: (*byte)(unsafe.Pointer(&p.vnetHdr)),
+			Len:  uint64(vnetHdrBytes),
+		}
+	}
 	p.allocRefs(m, n)
 }
 
@@ -151,26 +182,31 @@ func (n *node) InterfaceInput(o *vnet.RefOut) {
 	t := n.GetIfThread()
 	nPackets, nBytes, nDrops := uint(0), uint(0), uint(0)
 
-	done := false
-	for !done {
-		select {
-		case r := <-n.rxRefs:
-			if r.len == ^uint(0) {
-				nDrops++
-			} else {
-				nBytes += r.len
-				r.ref.Si = n.Si() // use xxx-unix interface as receive interface.
-				toTx.Refs[nPackets] = r.ref
-				nPackets++
-				if m.verbosePackets {
-					m.v.Logf("unix rx %d: %x\n", r.len, r.ref.DataSlice())
+	qs := n.i.queues
+	for i := range qs {
+		q := qs[(n.rxQueue+uint(i))%uint(len(qs))]
+		done := nPackets >= uint(len(toTx.Refs))
+		for !done {
+			select {
+			case r := <-q.rxRefs:
+				if r.len == ^uint(0) {
+					nDrops++
+				} else {
+					nBytes += r.len
+					r.ref.Si = n.Si() // use xxx-unix interface as receive interface.
+					toTx.Refs[nPackets] = r.ref
+					nPackets++
+					if m.verbosePackets {
+						m.v.Logf("unix rx %d: %x\n", r.len, r.ref.DataSlice())
+					}
+					done = nPackets >= uint(len(toTx.Refs))
 				}
-				done = nPackets >= uint(len(toTx.Refs))
+			default:
+				done = true
 			}
-		default:
-			done = true
 		}
 	}
+	n.rxQueue++
 
 	vnet.IfRxCounter.Add(t, n.Si(), nPackets, nBytes)
 	vnet.IfDrops.Add(t, n.Si(), nDrops)
@@ -178,22 +214,46 @@ func (n *node) InterfaceInput(o *vnet.RefOut) {
 	n.Activate(false)
 }
 
-func (intf *Interface) ReadReady() (err error) {
+func (q *queue) ReadReady() (err error) {
+	intf := q.intf
 	m, n := intf.m, &intf.node
 	p := m.getRxPacket(intf)
 	var (
 		nRead int
 		errno syscall.Errno
 	)
-	nRead, errno = readv(intf.Fd, p.iovs)
+	nRead, errno = readv(q.Fd, p.iovs)
 	if errno != 0 {
 		err = errorForErrno("readv", errno)
 		m.putRxPacket(p)
-		n.rxRefs <- rxRef{len: ^uint(0)}
+		q.rxRefs <- rxRef{len: ^uint(0)}
 		return
 	}
 	size := m.bufferPool.Size
 	nLeft := uint(nRead)
+	var (
+		offload   vnet.TxOffload
+		isOffload bool
+	)
+	if m.isVnetHdr {
+		if nLeft < vnetHdrBytes {
+			// Short read without complete header.
+			m.putRxPacket(p)
+			q.rxRefs <- rxRef{len: ^uint(0)}
+			return
+		}
+		nLeft -= vnetHdrBytes
+		// Packets needing offloads output performs are passed on as is.
+		offload, isOffload = q.txOffload(&p.vnetHdr)
+		if !isOffload && p.vnetHdr.gsoType != vnet_hdr_gso_none {
+			// Segments are copied into new buffers; packet's buffers are re-used as is.
+			if err = q.segment(p, nLeft); err != nil {
+				q.rxRefs <- rxRef{len: ^uint(0)}
+			}
+			m.putRxPacket(p)
+			return
+		}
+	}
 	var nRefs uint
 	for nRefs = 0; nLeft > 0; nRefs++ {
 		l := size
@@ -208,12 +268,16 @@ func (intf *Interface) ReadReady() (err error) {
 		p.chain.Append(r)
 		nLeft -= l
 	}
+	if m.isVnetHdr && !isOffload && p.vnetHdr.flags&vnet_hdr_f_needs_csum != 0 {
+		p.vnetHdr.finishChecksum(p.refs[:nRefs])
+	}
 
 	// Send packet to input node.
 	var r rxRef
 	r.len = p.chain.Len()
 	r.ref = p.chain.Done()
-	n.rxRefs <- r
+	r.ref.TxOffload = offload
+	q.rxRefs <- r
 	n.Activate(true)
 
 	// Refill packet with new buffers & return for re-use.
@@ -229,20 +293,26 @@ type txRefIn struct {
 
 func (n *node) InterfaceOutput(i *vnet.TxRefVecIn) {
 	intf := n.i
-	n.txRefIns <- txRefIn{in: i}
-	atomic.AddInt32(&n.txAvailable, 1)
-	iomux.Update(intf)
+	if len(intf.queues) == 0 {
+		// Interface has been deleted via kernel.
+		n.Vnet.FreeTxRefIn(i)
+		return
+	}
+	// Spread output across queues by vnet thread.
+	q := intf.queues[int(i.ThreadId())%len(intf.queues)]
+	q.txRefIns <- txRefIn{in: i}
+	atomic.AddInt32(&q.txAvailable, 1)
+	iomux.Update(q)
 }
 
-func (intf *Interface) WriteAvailable() (ok bool) {
-	n := &intf.node
-	ri := &n.txRefIn
-	return n.txAvailable > 0 || ri.in != nil && ri.i < ri.in.Len()
+func (q *queue) WriteAvailable() (ok bool) {
+	ri := &q.txRefIn
+	return q.txAvailable > 0 || ri.in != nil && ri.i < ri.in.Len()
 }
 
-func (intf *Interface) WriteReady() (err error) {
-	n := &intf.node
-	ri := &n.txRefIn
+func (q *queue) WriteReady() (err error) {
+	intf := q.intf
+	ri := &q.txRefIn
 	for {
 		l := uint(0)
 		if ri.in != nil {
@@ -254,21 +324,32 @@ func (intf *Interface) WriteReady() (err error) {
 				ri.in = nil
 			}
 			select {
-			case *ri = <-n.txRefIns:
-				atomic.AddInt32(&n.txAvailable, -1)
+			case *ri = <-q.txRefIns:
+				atomic.AddInt32(&q.txAvailable, -1)
 				ri.i = 0
 			default:
-				iomux.Update(intf)
+				iomux.Update(q)
 				return
 			}
 		}
 
 		// Convert vnet buffer references for a single packet into iovecs for writing to kernel.
 		nIovecs, nWriteLeft := uint(0), uint(0)
+		nHdrIovecs := uint(0)
+		if intf.m.isVnetHdr {
+			// Packets from vnet are complete so header asks kernel for no checksum or segmentation
+			// and tells kernel that checksums need not be verified.
+			q.txIovecs.Validate(0)
+			q.txIovecs[0] = iovec{
+				Base: (*byte)(unsafe.Pointer(&q.txVnetHdr)),
+				Len:  uint64(vnetHdrBytes),
+			}
+			nIovecs, nWriteLeft, nHdrIovecs = 1, vnetHdrBytes, 1
+		}
 		for i := ri.i; i < ri.in.Refs.Len(); i++ {
-			n.txIovecs.Validate(nIovecs)
+			q.txIovecs.Validate(nIovecs)
 			r := &ri.in.Refs[i]
-			n.txIovecs[nIovecs] = iovec{
+			q.txIovecs[nIovecs] = iovec{
 				Base: (*byte)(r.Data()),
 				Len:  uint64(r.DataLen()),
 			}
@@ -280,8 +361,8 @@ func (intf *Interface) WriteReady() (err error) {
 		}
 
 		// Inject packet into kernel tun/tap devices.
-		if nIovecs > 0 {
-			nWrite, errno := writev(intf.Fd, n.txIovecs[:nIovecs])
+		if nIovecs > nHdrIovecs {
+			nWrite, errno := writev(q.Fd, q.txIovecs[:nIovecs])
 			switch {
 			case errno == syscall.EWOULDBLOCK:
 				return
@@ -299,7 +380,7 @@ func (intf *Interface) WriteReady() (err error) {
 					intf.m.v.Logf("unix tx %d: %x\n", nWrite, ri.in.Refs[ri.i].DataSlice())
 				}
 			}
-			ri.i += nIovecs
+			ri.i += nIovecs - nHdrIovecs
 		}
 	}
 
@@ -317,9 +398,9 @@ func errorForErrno(tag string, errno syscall.Errno) (err error) {
 	return
 }
 
-func (intf *Interface) ErrorReady() (err error) {
+func (q *queue) ErrorReady() (err error) {
 	var e int
-	if e, err = syscall.GetsockoptInt(intf.Fd, syscall.SOL_SOCKET, syscall.SO_ERROR); err == nil {
+	if e, err = syscall.GetsockoptInt(q.Fd, syscall.SOL_SOCKET, syscall.SO_ERROR); err == nil {
 		err = errorForErrno("error ready", syscall.Errno(e))
 	}
 	if err != nil {
@@ -12,45 +12,111 @@ import (
 	"github.com/platinasystems/elib/parse"
 	"github.com/platinasystems/vnet"
 	"github.com/platinasystems/vnet/ethernet"
+	"github.com/platinasystems/vnet/ip4"
+	"github.com/platinasystems/vnet/ip6"
 
 	"fmt"
+	"runtime"
 	"strings"
+	"sync"
 	"syscall"
 	"unsafe"
 )
 
 type Interface struct {
 	m *Main
-	// /dev/net/tun
+	// /dev/net/tun of first queue; used for tuntap ioctls.
 	dev_net_tun_fd int
 	// Raw socket bound to this interface used for provisioning.
 	provision_fd int
-	iomux.File   // provisioning socket
-	hi           vnet.Hi
-	si           vnet.Si
-	name         ifreq_name
-	ifindex      int // linux interface index
-	flags        iff_flag
-	node         node
-	mtuBytes     uint
-	mtuBuffers   uint
+	// Queues used for packet input/output.
+	queues     []*queue
+	hi         vnet.Hi
+	si         vnet.Si
+	name       ifreq_name
+	ifindex    int // linux interface index
+	flags      iff_flag
+	node       node
+	mtuBytes   uint
+	mtuBuffers uint
+	// Linux interface index of VRF device interface is enslaved to; zero when none.
+	vrfIndex uint32
 }
 
 //go:generate gentemplate -d Package=unix -id ifVec -d VecType=interfaceVec -d Type=*Interface github.com/platinasystems/elib/vec.tmpl
 
-func (m *Main) interfaceForSi(si vnet.Si) *Interface { return m.ifVec[si] }
+// Returns nil for interfaces without tuntap (e.g. deleted via kernel).
+func (m *Main) interfaceForSi(si vnet.Si) (intf *Interface) {
+	if uint(si) < uint(len(m.ifVec)) {
+		intf = m.ifVec[si]
+	}
+	return
+}
 
 func (i *Interface) Name() string   { return i.name.String() }
 func (i *Interface) String() string { return i.Name() }
 
 func (i *Interface) setMtu(m *Main, mtu uint) {
 	i.mtuBytes = mtu
+	// Receive buffers must hold largest packet kernel may send.
+	if m.isVnetHdr && mtu < vnetHdrMaxPacketBytes {
+		mtu = vnetHdrMaxPacketBytes
+	}
 	i.mtuBuffers = mtu / m.bufferPool.Size
 	if mtu%m.bufferPool.Size != 0 {
 		i.mtuBuffers++
 	}
 }
 
+// MTU changed via kernel (e.g. "ip link set mtu").
+func (i *Interface) changeMtu(mtu uint) (err error) {
+	if mtu == i.mtuBytes {
+		return
+	}
+	i.setMtu(i.m, mtu)
+	err = i.m.v.HwIf(i.hi).SetMaxPacketSize(mtu)
+	return
+}
+
+// Ethernet address changed via kernel.
+// Rewrites of neighbor and glean adjacencies out of interface have old address as source and so are re-made.
+func (i *Interface) changeAddress(a ethernet.Address) (err error) {
+	eifer, ok := i.m.v.HwIfer(i.hi).(ethernet.HwInterfacer)
+	if !ok {
+		return
+	}
+	ei := eifer.GetInterface()
+	if ei.Address == a {
+		return
+	}
+	ei.Address = a
+	m4, m6 := ip4.GetMain(i.m.v), ip6.GetMain(i.m.v)
+	em := ethernet.GetMain(i.m.v)
+	for _, x := range i.m.ifVec {
+		if x == nil || x.hi != i.hi {
+			continue
+		}
+		m4.RewriteInterfaceAdjacencies(x.si)
+		m6.RewriteInterfaceAdjacencies(x.si)
+		if err = em.RewriteIpNeighbors(x.si); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Interface renamed via kernel.
+// Only tuntap interface and its vnet node are renamed; vnet interface keeps its name since
+// it is used to configure vnet (e.g. cli and config files).
+func (i *Interface) rename(name string) {
+	if name == i.Name() {
+		return
+	}
+	i.name = ifreq_name{}
+	copy(i.name[:], name)
+	i.node.SetName(i.m.v, name+"-unix")
+}
+
 type Main struct {
 	vnet.Package
 
@@ -66,10 +132,20 @@ type tuntapMain struct {
 	// Selects whether we create tun or tap interfaces.
 	isTun bool
 
+	// Packets are preceded by virtio net header so that kernel may pass packets
+	// needing checksum and segmentation to vnet.
+	isVnetHdr bool
+
 	mtuBytes uint
 
-	ifVec     interfaceVec
-	ifByIndex map[int]*Interface
+	// Number of queues of each tuntap interface.
+	nQueues uint
+
+	ifVec interfaceVec
+
+	// Netlink listener looks up interfaces by kernel index concurrently with creation and deletion.
+	ifByIndexMu sync.RWMutex
+	ifByIndex   map[int]*Interface
 
 	bufferPool *vnet.BufferPool
 }
@@ -94,6 +170,9 @@ const (
 	iff_nofilter     = 1 << 12
 )
 
+// Maximum number of queues of multi-queue tuntap interface (MAX_TAP_QUEUES in kernel).
+const maxQueues = 256
+
 type ifreq_name [16]byte
 
 func (n ifreq_name) String() string { return strings.TrimRight(string(n[:]), "\x00") }
@@ -167,21 +246,26 @@ type ifreq_type int
 const (
 	ifreq_TUNSETIFF     ifreq_type = syscall.TUNSETIFF
 	ifreq_TUNSETPERSIST ifreq_type = syscall.TUNSETPERSIST
+	ifreq_TUNSETOFFLOAD ifreq_type = syscall.TUNSETOFFLOAD
 	ifreq_GETIFINDEX    ifreq_type = syscall.SIOCGIFINDEX
 	ifreq_GETIFFLAGS    ifreq_type = syscall.SIOCGIFFLAGS
 	ifreq_SETIFFLAGS    ifreq_type = syscall.SIOCSIFFLAGS
 	ifreq_SETIFHWADDR   ifreq_type = syscall.SIOCSIFHWADDR
 	ifreq_SETIFMTU      ifreq_type = syscall.SIOCSIFMTU
+	// _IOW('T', 226, int); not defined by syscall package.
+	ifreq_TUNSETCARRIER ifreq_type = 0x400454e2
 )
 
 var ifreq_type_names = map[ifreq_type]string{
 	ifreq_TUNSETIFF:     "TUNSETIFF",
 	ifreq_TUNSETPERSIST: "TUNSETPERSIST",
+	ifreq_TUNSETOFFLOAD: "TUNSETOFFLOAD",
 	ifreq_GETIFINDEX:    "GETIFINDEX",
 	ifreq_GETIFFLAGS:    "GETIFFLAGS",
 	ifreq_SETIFFLAGS:    "SETIFFLAGS",
 	ifreq_SETIFHWADDR:   "SETIFHWADDR",
 	ifreq_SETIFMTU:      "SETIFMTU",
+	ifreq_TUNSETCARRIER: "TUNSETCARRIER",
 }
 
 func (t ifreq_type) String() string {
@@ -233,11 +317,37 @@ func (m *Main) SwIfAddDel(v *vnet.Vnet, si vnet.Si, isDel bool) (err error) {
 		} else {
 			r.flags |= iff_tap
 		}
-		if err = intf.open(); err != nil {
-			return
+		if m.isVnetHdr {
+			r.flags |= iff_vnet_hdr
 		}
-		if err = intf.ioctl(intf.dev_net_tun_fd, ifreq_TUNSETIFF, uintptr(unsafe.Pointer(&r))); err != nil {
-			return
+		// Single queue interfaces stay compatible with existing persistent interfaces.
+		if m.nQueues > 1 {
+			r.flags |= iff_multi_queue
+		}
+		// Close queues already opened when a later step fails.
+		defer func() {
+			if err != nil {
+				for _, q := range intf.queues {
+					syscall.Close(q.Fd)
+				}
+				intf.queues = nil
+			}
+		}()
+		// Each TUNSETIFF on a new file descriptor attaches another queue.
+		for i := uint(0); i < m.nQueues; i++ {
+			var q *queue
+			if q, err = intf.openQueue(); err != nil {
+				return
+			}
+			if err = intf.ioctl(q.Fd, ifreq_TUNSETIFF, uintptr(unsafe.Pointer(&r))); err != nil {
+				return
+			}
+		}
+		intf.dev_net_tun_fd = intf.queues[0].Fd
+		if m.isVnetHdr {
+			if err = intf.ioctl(intf.dev_net_tun_fd, ifreq_TUNSETOFFLOAD, vnetHdrOffloads); err != nil {
+				return
+			}
 		}
 		if err = intf.ioctl(intf.dev_net_tun_fd, ifreq_TUNSETPERSIST, 1); err != nil {
 			return
@@ -319,10 +429,19 @@ func (m *Main) SwIfAddDel(v *vnet.Vnet, si vnet.Si, isDel bool) (err error) {
 
 	m.ifVec.Validate(uint(si))
 	m.ifVec[si] = intf
+	m.ifByIndexMu.Lock()
 	if m.ifByIndex == nil {
 		m.ifByIndex = make(map[int]*Interface)
 	}
 	m.ifByIndex[intf.ifindex] = intf
+	m.ifByIndexMu.Unlock()
+
+	// Tap carrier follows hardware link state; kernel sets carrier on when device is created.
+	// Failure (e.g. kernel without TUNSETCARRIER) leaves carrier on; interface is still usable.
+	if err = intf.setCarrier(v.HwIf(hi).IsLinkUp()); err != nil {
+		m.v.Logf("%s: set carrier: %s\n", intf.Name(), err)
+		err = nil
+	}
 
 	// Create Vnet interface.
 	intf.interfaceNodeInit(m)
@@ -330,6 +449,24 @@ func (m *Main) SwIfAddDel(v *vnet.Vnet, si vnet.Si, isDel bool) (err error) {
 	return
 }
 
+// Interface deleted via kernel (e.g. "ip link del").
+func (m *Main) delInterface(intf *Interface) {
+	for _, q := range intf.queues {
+		iomux.Del(q)
+	}
+	intf.close()
+	m.ifByIndexMu.Lock()
+	delete(m.ifByIndex, intf.ifindex)
+	m.ifByIndexMu.Unlock()
+	m.ifVec[intf.si] = nil
+	m.puntNode.setNext(intf.si, puntNextError)
+	for k := range m.txNeighbors {
+		if k.si == intf.si {
+			delete(m.txNeighbors, k)
+		}
+	}
+}
+
 func (m *Main) maybeChangeFlag(intf *Interface, isUp bool, flag iff_flag) (err error) {
 	change := false
 	switch {
@@ -355,6 +492,9 @@ func (m *Main) SwIfAdminUpDown(v *vnet.Vnet, si vnet.Si, isUp bool) (err error)
 		return
 	}
 	intf := m.interfaceForSi(si)
+	if intf == nil {
+		return
+	}
 	err = m.maybeChangeFlag(intf, isUp, iff_up|iff_running)
 	if err != nil {
 		return
@@ -369,7 +509,11 @@ func (m *Main) HwIfLinkUpDown(v *vnet.Vnet, hi vnet.Hi, isUp bool) (err error) {
 		return
 	}
 	intf := m.interfaceForSi(v.HwIf(hi).Si())
-	err = m.maybeChangeFlag(intf, isUp, iff_lower_up)
+	if intf == nil {
+		return
+	}
+	// Set carrier so that kernel sees link state of vnet interface.
+	err = intf.setCarrier(isUp)
 	if err != nil {
 		return
 	}
@@ -378,14 +522,38 @@ func (m *Main) HwIfLinkUpDown(v *vnet.Vnet, hi vnet.Hi, isUp bool) (err error) {
 	return
 }
 
-func (i *Interface) open() (err error) {
-	i.dev_net_tun_fd, err = syscall.Open("/dev/net/tun", syscall.O_RDWR, 0)
+// Lower up flag is read-only via SIOCSIFFLAGS; tap carrier must be set on tuntap device.
+func (i *Interface) setCarrier(isUp bool) (err error) {
+	var carrier int32
+	if isUp {
+		carrier = 1
+	}
+	if err = i.ioctl(i.dev_net_tun_fd, ifreq_TUNSETCARRIER, uintptr(unsafe.Pointer(&carrier))); err != nil {
+		return
+	}
+	if isUp {
+		i.flags |= iff_lower_up
+	} else {
+		i.flags &^= iff_lower_up
+	}
+	return
+}
+
+func (i *Interface) openQueue() (q *queue, err error) {
+	q = &queue{intf: i}
+	if q.Fd, err = syscall.Open("/dev/net/tun", syscall.O_RDWR, 0); err != nil {
+		return
+	}
+	i.queues = append(i.queues, q)
 	return
 }
 
 func (i *Interface) close() (err error) {
 	err = syscall.Close(i.provision_fd)
-	err = syscall.Close(i.dev_net_tun_fd)
+	for _, q := range i.queues {
+		err = syscall.Close(q.Fd)
+	}
+	i.queues = nil
 	i.dev_net_tun_fd = -1
 	i.provision_fd = -1
 	return
@@ -397,9 +565,16 @@ func (m *Main) Init() (err error) {
 	// Suitable defaults for an Ethernet-like tun/tap device.
 	m.mtuBytes = 4096 + 256
 
+	// One queue for each thread which may run vnet; "queues N" overrides.
+	m.nQueues = uint(runtime.GOMAXPROCS(0))
+	if m.nQueues > maxQueues {
+		m.nQueues = maxQueues
+	}
+
 	m.v.RegisterSwIfAddDelHook(m.SwIfAddDel)
 	m.v.RegisterSwIfAdminUpDownHook(m.SwIfAdminUpDown)
 	m.v.RegisterHwIfLinkUpDownHook(m.HwIfLinkUpDown)
+	ethernet.GetMain(m.v).RegisterIpNeighborAddDelHook(m.ipNeighborAddDel)
 	return
 }
 
@@ -407,10 +582,16 @@ func (m *Main) Configure(in *parse.Input) {
 	for !in.End() {
 		switch {
 		case in.Parse("mtu %d", &m.mtuBytes):
+		case in.Parse("queues %d", &m.nQueues):
+			if m.nQueues < 1 || m.nQueues > maxQueues {
+				panic(parse.ErrInput)
+			}
 		case in.Parse("tap"):
 			m.isTun = false
 		case in.Parse("tun"):
 			m.isTun = true
+		case in.Parse("vnet-hdr"):
+			m.isVnetHdr = true
 		case in.Parse("dump-packets"):
 			m.verbosePackets = true
 		case in.Parse("dump-netlink"):
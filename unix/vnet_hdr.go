@@ -0,0 +1,307 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package unix
+
+import (
+	"github.com/platinasystems/vnet"
+	"github.com/platinasystems/vnet/ip"
+
+	"encoding/binary"
+	"errors"
+	"unsafe"
+)
+
+// Virtio net header preceding packet data read from/written to tuntap in vnet header mode (IFF_VNET_HDR).
+// Fields are in host byte order.
+type vnetHdr struct {
+	flags      uint8
+	gsoType    uint8
+	hdrLen     uint16
+	gsoSize    uint16
+	csumStart  uint16
+	csumOffset uint16
+}
+
+const vnetHdrBytes = uint(unsafe.Sizeof(vnetHdr{}))
+
+const (
+	// vnetHdr flags
+	vnet_hdr_f_needs_csum = 1 << 0
+	vnet_hdr_f_data_valid = 1 << 1
+
+	// vnetHdr gso types
+	vnet_hdr_gso_none  = 0
+	vnet_hdr_gso_tcpv4 = 1
+	vnet_hdr_gso_udp   = 3
+	vnet_hdr_gso_tcpv6 = 4
+	vnet_hdr_gso_ecn   = 0x80
+)
+
+const (
+	// TUNSETOFFLOAD flags
+	tun_f_csum    = 1 << 0
+	tun_f_tso4    = 1 << 1
+	tun_f_tso6    = 1 << 2
+	tun_f_tso_ecn = 1 << 3
+	tun_f_ufo     = 1 << 4
+)
+
+// Offloads accepted from kernel.  Packets are passed on as is to outputs performing the offloads they need
+// (see vnet.OffloadOutputer); for other outputs segmentation and checksums are done here.
+// Either way this saves kernel from segmenting and checksumming each packet and saves a read system call per segment.
+const vnetHdrOffloads = tun_f_csum | tun_f_tso4 | tun_f_tso6
+
+// Kernel sends segmentation offload packets of up to 64k bytes plus layer 2 header.
+const vnetHdrMaxPacketBytes = 1<<16 + 64
+
+var errVnetHdr = errors.New("bad vnet header")
+
+// Internet checksum of bytes spread over possibly odd length slices.
+type checksummer struct {
+	c   ip.Checksum
+	odd bool
+	hi  byte
+}
+
+func (s *checksummer) add(b []byte) {
+	i := 0
+	if s.odd && len(b) > 0 {
+		s.c = s.c.AddWithCarry(ip.Checksum(uint16(s.hi)<<8 | uint16(b[0])))
+		s.odd = false
+		i = 1
+	}
+	for ; i+2 <= len(b); i += 2 {
+		s.c = s.c.AddWithCarry(ip.Checksum(uint16(b[i])<<8 | uint16(b[i+1])))
+	}
+	if i < len(b) {
+		s.hi, s.odd = b[i], true
+	}
+}
+
+// Returns checksum in host byte order.
+func (s *checksummer) sum() uint16 {
+	if s.odd {
+		s.c = s.c.AddWithCarry(ip.Checksum(uint16(s.hi) << 8))
+		s.odd = false
+	}
+	return uint16(^s.c.Fold())
+}
+
+// Set byte at given offset of data of packet's buffers.
+func refsSetByte(refs []vnet.Ref, offset uint, x byte) {
+	for i := range refs {
+		b := refs[i].DataSlice()
+		if offset < uint(len(b)) {
+			b[offset] = x
+			return
+		}
+		offset -= uint(len(b))
+	}
+}
+
+// Finish partial checksum of packet received from kernel: checksum field holds sum of
+// pseudo header; sum is completed over all bytes starting at csum start.
+func (h *vnetHdr) finishChecksum(refs []vnet.Ref) {
+	var s checksummer
+	start := uint(h.csumStart)
+	for i := range refs {
+		b := refs[i].DataSlice()
+		if start < uint(len(b)) {
+			s.add(b[start:])
+			start = 0
+		} else {
+			start -= uint(len(b))
+		}
+	}
+	x := s.sum()
+	if x == 0 {
+		x = 0xffff
+	}
+	o := uint(h.csumStart) + uint(h.csumOffset)
+	refsSetByte(refs, o+0, byte(x>>8))
+	refsSetByte(refs, o+1, byte(x))
+}
+
+// Offset of ip header in packet: after ethernet header and any vlan tags for tap interfaces.
+func (m *Main) l3Offset(b []byte) (o uint) {
+	if m.isTun {
+		return
+	}
+	o = 12
+	for o+2 <= uint(len(b)) {
+		switch binary.BigEndian.Uint16(b[o:]) {
+		case 0x8100, 0x88a8, 0x9100:
+			o += 4
+		default:
+			return o + 2
+		}
+	}
+	return
+}
+
+const (
+	tcp_flag_fin = 1 << 0
+	tcp_flag_psh = 1 << 3
+	tcp_flag_cwr = 1 << 7
+)
+
+// Offload request for packet from kernel when output of interface performs all offloads packet needs.
+func (q *queue) txOffload(h *vnetHdr) (o vnet.TxOffload, ok bool) {
+	if h.flags&vnet_hdr_f_needs_csum == 0 || h.csumStart == 0 {
+		return
+	}
+	out, isOffloader := q.intf.m.v.HwIfer(q.intf.hi).(vnet.OffloadOutputer)
+	if !isOffloader {
+		return
+	}
+	need, segmentSize := vnet.OffloadChecksum, uint(0)
+	switch h.gsoType {
+	case vnet_hdr_gso_none:
+	case vnet_hdr_gso_tcpv4:
+		need |= vnet.OffloadTso4
+		segmentSize = uint(h.gsoSize)
+	case vnet_hdr_gso_tcpv6:
+		need |= vnet.OffloadTso6
+		segmentSize = uint(h.gsoSize)
+	default:
+		return
+	}
+	if out.OutputOffloads()&need != need || h.gsoType != vnet_hdr_gso_none && segmentSize == 0 {
+		return
+	}
+	o, ok = vnet.MakeTxOffload(uint(h.csumStart), segmentSize), true
+	return
+}
+
+// Segment tcp packet received from kernel with segmentation offload into packets of at most gso size
+// payload bytes each with complete ip and tcp checksums.  Segments are sent to input node.
+func (q *queue) segment(p *packet, nBytes uint) (err error) {
+	m := q.intf.m
+	h := &p.vnetHdr
+
+	// Copy packet into contiguous buffer.
+	if uint(cap(q.gsoBuf)) < nBytes {
+		q.gsoBuf = make([]byte, nBytes)
+	}
+	b := q.gsoBuf[:nBytes]
+	size := m.bufferPool.Size
+	for i, o := 0, uint(0); o < nBytes; i++ {
+		l := nBytes - o
+		if l > size {
+			l = size
+		}
+		r := &p.refs[i]
+		r.SetDataLen(l)
+		o += uint(copy(b[o:], r.DataSlice()))
+	}
+
+	isIp4 := h.gsoType&^vnet_hdr_gso_ecn == vnet_hdr_gso_tcpv4
+	l3, l4, mss := m.l3Offset(b), uint(h.csumStart), uint(h.gsoSize)
+	if h.flags&vnet_hdr_f_needs_csum == 0 || mss == 0 || l4 <= l3 || l4+20 > nBytes {
+		return errVnetHdr
+	}
+	hdrLen := l4 + 4*uint(b[l4+12]>>4)
+	// Segment headers are written into first buffer of each segment.
+	if hdrLen > nBytes || hdrLen > size {
+		return errVnetHdr
+	}
+	if isIp4 && b[l3]>>4 != 4 || !isIp4 && b[l3]>>4 != 6 {
+		return errVnetHdr
+	}
+
+	// Payload is copied once more: directly from contiguous buffer into buffers of each segment.
+	q.segHdr = segmentTcp(b, q.segHdr, l3, l4, hdrLen, mss, isIp4, q.rxSegment)
+	return
+}
+
+// Split tcp packet b with ip header at l3, tcp header at l4 and hdrLen bytes of headers into segments
+// of at most mss payload bytes.  Fn is called for each segment with its header (lengths, ip4 fragment id,
+// tcp sequence number, flags and checksums set for segment) and payload.
+// Segment headers are built in hdr which is returned for re-use.
+func segmentTcp(b, hdr []byte, l3, l4, hdrLen, mss uint, isIp4 bool, fn func(hdr, payload []byte)) []byte {
+	payload := b[hdrLen:]
+	seq := binary.BigEndian.Uint32(b[l4+4:])
+	tcpFlags := b[l4+13]
+	var fragmentId uint16
+	if isIp4 {
+		fragmentId = binary.BigEndian.Uint16(b[l3+4:])
+	}
+	for i, o := uint(0), uint(0); o < uint(len(payload)); i, o = i+1, o+mss {
+		n := uint(len(payload)) - o
+		if n > mss {
+			n = mss
+		}
+		s := append(hdr[:0], b[:hdrLen]...)
+		hdr = s
+		segLen := hdrLen + n
+
+		// Only first segment keeps cwr; only last keeps fin and psh.
+		f := tcpFlags
+		if i > 0 {
+			f &^= tcp_flag_cwr
+		}
+		if o+n < uint(len(payload)) {
+			f &^= tcp_flag_fin | tcp_flag_psh
+		}
+		s[l4+13] = f
+		binary.BigEndian.PutUint32(s[l4+4:], seq+uint32(o))
+
+		var ps checksummer
+		l4Len := segLen - l4
+		if isIp4 {
+			binary.BigEndian.PutUint16(s[l3+2:], uint16(segLen-l3))
+			binary.BigEndian.PutUint16(s[l3+4:], fragmentId+uint16(i))
+			s[l3+10], s[l3+11] = 0, 0
+			var hs checksummer
+			hs.add(s[l3 : l3+4*uint(s[l3]&0xf)])
+			binary.BigEndian.PutUint16(s[l3+10:], hs.sum())
+			ps.add(s[l3+12 : l3+20])
+		} else {
+			binary.BigEndian.PutUint16(s[l3+4:], uint16(segLen-l3-40))
+			ps.add(s[l3+8 : l3+40])
+		}
+		ps.add([]byte{0, byte(ip.TCP), byte(l4Len >> 8), byte(l4Len)})
+		s[l4+16], s[l4+17] = 0, 0
+		ps.add(s[l4:])
+		ps.add(payload[o : o+n])
+		binary.BigEndian.PutUint16(s[l4+16:], ps.sum())
+
+		fn(s, payload[o:o+n])
+	}
+	return hdr
+}
+
+// Copy segment header and payload into buffers and send to input node.
+// Header fits in first buffer.
+func (q *queue) rxSegment(hdr, payload []byte) {
+	m, n := q.intf.m, &q.intf.node
+	size := m.bufferPool.Size
+	l := uint(len(hdr) + len(payload))
+	nRefs := (l + size - 1) / size
+	q.segRefs.Validate(nRefs - 1)
+	m.bufferPool.AllocRefs(q.segRefs[:nRefs])
+	var c vnet.RefChain
+	for i := uint(0); i < nRefs; i++ {
+		bl := l
+		if bl > size {
+			bl = size
+		}
+		r := &q.segRefs[i]
+		r.SetDataLen(bl)
+		d := r.DataSlice()
+		if i == 0 {
+			d = d[copy(d, hdr):]
+		}
+		payload = payload[copy(d, payload):]
+		c.Append(r)
+		l -= bl
+	}
+	var r rxRef
+	r.len = c.Len()
+	r.ref = c.Done()
+	r.ref.TxOffload = 0
+	q.rxRefs <- r
+	n.Activate(true)
+}
@@ -0,0 +1,180 @@
+// Copyright 2016 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package unix
+
+import (
+	"github.com/platinasystems/vnet/ip"
+
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestChecksummer(t *testing.T) {
+	// Example from RFC 1071: sum is 0xddf2.
+	rfc1071 := []byte{0x00, 0x01, 0xf2, 0x03, 0xf4, 0xf5, 0xf6, 0xf7}
+	tests := []struct {
+		name  string
+		parts [][]byte
+		want  uint16
+	}{
+		{name: "empty", want: 0xffff},
+		{name: "rfc1071", parts: [][]byte{rfc1071}, want: 0x220d},
+		{name: "rfc1071 odd split", parts: [][]byte{rfc1071[:1], rfc1071[1:]}, want: 0x220d},
+		{name: "rfc1071 odd splits", parts: [][]byte{rfc1071[:3], rfc1071[3:6], rfc1071[6:]}, want: 0x220d},
+		{name: "rfc1071 with empty parts", parts: [][]byte{nil, rfc1071[:5], nil, rfc1071[5:]}, want: 0x220d},
+		{name: "rfc1071 byte by byte", parts: [][]byte{
+			rfc1071[0:1], rfc1071[1:2], rfc1071[2:3], rfc1071[3:4],
+			rfc1071[4:5], rfc1071[5:6], rfc1071[6:7], rfc1071[7:8],
+		}, want: 0x220d},
+		// Odd length is padded with zero.
+		{name: "odd length", parts: [][]byte{{0x01}}, want: 0xfeff},
+		{name: "odd length split", parts: [][]byte{{0x12, 0x34}, {0x56}}, want: ^uint16(0x1234 + 0x5600)},
+		{name: "carry", parts: [][]byte{{0xff, 0xff}, {0x00, 0x01}}, want: 0xfffe},
+	}
+	for _, x := range tests {
+		var s checksummer
+		for _, p := range x.parts {
+			s.add(p)
+		}
+		if got := s.sum(); got != x.want {
+			t.Errorf("%s: got %#04x, want %#04x", x.name, got, x.want)
+		}
+	}
+}
+
+const (
+	testL3     = 14
+	testTcpSeq = 1000
+	testIp4Id  = 0x1234
+)
+
+// Builds ethernet (zeros), ip and tcp headers followed by payload of given length.
+func testTcpPacket(isIp4 bool, nPayload int, flags byte) (b []byte, l4 uint) {
+	b = make([]byte, testL3)
+	if isIp4 {
+		h := make([]byte, 20)
+		h[0] = 0x45
+		binary.BigEndian.PutUint16(h[2:], uint16(20+20+nPayload))
+		binary.BigEndian.PutUint16(h[4:], testIp4Id)
+		h[8], h[9] = 64, byte(ip.TCP)
+		copy(h[12:], []byte{10, 0, 0, 1, 10, 0, 0, 2})
+		b = append(b, h...)
+	} else {
+		h := make([]byte, 40)
+		h[0] = 0x60
+		binary.BigEndian.PutUint16(h[4:], uint16(20+nPayload))
+		h[6], h[7] = byte(ip.TCP), 64
+		h[8], h[9], h[23] = 0x20, 0x01, 1
+		h[24], h[25], h[39] = 0x20, 0x01, 2
+		b = append(b, h...)
+	}
+	l4 = uint(len(b))
+	h := make([]byte, 20)
+	binary.BigEndian.PutUint16(h[0:], 4000)
+	binary.BigEndian.PutUint16(h[2:], 80)
+	binary.BigEndian.PutUint32(h[4:], testTcpSeq)
+	h[12], h[13] = 5<<4, flags
+	b = append(b, h...)
+	for i := 0; i < nPayload; i++ {
+		b = append(b, byte(i))
+	}
+	return
+}
+
+func TestSegmentTcp(t *testing.T) {
+	const ack = 1 << 4
+	allFlags := byte(ack | tcp_flag_cwr | tcp_flag_psh | tcp_flag_fin)
+	tests := []struct {
+		name     string
+		isIp4    bool
+		nPayload int
+		mss      uint
+		wantLens []int
+	}{
+		{name: "ip4 partial last", isIp4: true, nPayload: 10, mss: 4, wantLens: []int{4, 4, 2}},
+		{name: "ip4 exact", isIp4: true, nPayload: 8, mss: 4, wantLens: []int{4, 4}},
+		{name: "ip4 single", isIp4: true, nPayload: 3, mss: 4, wantLens: []int{3}},
+		{name: "ip4 odd mss", isIp4: true, nPayload: 11, mss: 5, wantLens: []int{5, 5, 1}},
+		{name: "ip6 partial last", nPayload: 7, mss: 3, wantLens: []int{3, 3, 1}},
+		{name: "ip6 single", nPayload: 1, mss: 1400, wantLens: []int{1}},
+	}
+	for _, x := range tests {
+		b, l4 := testTcpPacket(x.isIp4, x.nPayload, allFlags)
+		orig := append([]byte(nil), b...)
+		hdrLen := l4 + 20
+		var (
+			lens    []int
+			payload []byte
+		)
+		segmentTcp(b, nil, testL3, l4, hdrLen, x.mss, x.isIp4, func(h, p []byte) {
+			i := len(lens)
+			lens = append(lens, len(p))
+			payload = append(payload, p...)
+			if uint(len(h)) != hdrLen {
+				t.Errorf("%s: segment %d: header length %d, want %d", x.name, i, len(h), hdrLen)
+				return
+			}
+			segLen := hdrLen + uint(len(p))
+			l4Len := segLen - l4
+			var ps checksummer
+			if x.isIp4 {
+				if got := uint(binary.BigEndian.Uint16(h[testL3+2:])); got != segLen-testL3 {
+					t.Errorf("%s: segment %d: ip4 length %d, want %d", x.name, i, got, segLen-testL3)
+				}
+				if got := binary.BigEndian.Uint16(h[testL3+4:]); got != testIp4Id+uint16(i) {
+					t.Errorf("%s: segment %d: ip4 id %#x, want %#x", x.name, i, got, testIp4Id+i)
+				}
+				var hs checksummer
+				hs.add(h[testL3:l4])
+				if hs.sum() != 0 {
+					t.Errorf("%s: segment %d: bad ip4 header checksum", x.name, i)
+				}
+				ps.add(h[testL3+12 : testL3+20])
+			} else {
+				if got := uint(binary.BigEndian.Uint16(h[testL3+4:])); got != l4Len {
+					t.Errorf("%s: segment %d: ip6 payload length %d, want %d", x.name, i, got, l4Len)
+				}
+				ps.add(h[testL3+8 : testL3+40])
+			}
+			ps.add([]byte{0, byte(ip.TCP), byte(l4Len >> 8), byte(l4Len)})
+			ps.add(h[l4:])
+			ps.add(p)
+			if ps.sum() != 0 {
+				t.Errorf("%s: segment %d: bad tcp checksum", x.name, i)
+			}
+			if got, want := binary.BigEndian.Uint32(h[l4+4:]), uint32(testTcpSeq+int(x.mss)*i); got != want {
+				t.Errorf("%s: segment %d: sequence %d, want %d", x.name, i, got, want)
+			}
+			// Only first segment keeps cwr; only last keeps fin and psh.
+			want := allFlags
+			if i > 0 {
+				want &^= tcp_flag_cwr
+			}
+			if i+1 < len(x.wantLens) {
+				want &^= tcp_flag_fin | tcp_flag_psh
+			}
+			if got := h[l4+13]; got != want {
+				t.Errorf("%s: segment %d: flags %#x, want %#x", x.name, i, got, want)
+			}
+		})
+		if len(lens) != len(x.wantLens) {
+			t.Errorf("%s: got segment lengths %v, want %v", x.name, lens, x.wantLens)
+			continue
+		}
+		for i := range lens {
+			if lens[i] != x.wantLens[i] {
+				t.Errorf("%s: got segment lengths %v, want %v", x.name, lens, x.wantLens)
+				break
+			}
+		}
+		if !bytes.Equal(payload, orig[hdrLen:]) {
+			t.Errorf("%s: segment payloads differ from packet payload", x.name)
+		}
+		if !bytes.Equal(b, orig) {
+			t.Errorf("%s: packet modified", x.name)
+		}
+	}
+}